@@ -0,0 +1,438 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shafreeck/cortana"
+	"github.com/shafreeck/guru/chat"
+)
+
+type BatchCommandOptions struct {
+	ChatGPTOptions `yaml:"chatgpt,omitempty"`
+	ClientOptions  `yaml:",inline"`
+	Prices         map[string]Price `cortana:"-, -" yaml:"prices,omitempty"`
+	Dir            string           `cortana:"--dir,-, ~/.guru, the guru directory" yaml:"dir,omitempty"`
+	System         string           `cortana:"--system, -,, the optional system prompt" yaml:"-"`
+	Prompt         string           `cortana:"--prompt, -p, , the prompt name or text to run over the inputs" yaml:"-"`
+	Input          string           `cortana:"--input, -i, , the jsonl file of the inputs, - for stdin" yaml:"-"`
+	Field          string           `cortana:"--field, -, text, the field of the jsonl rows to send, the id field identifies a row" yaml:"-"`
+	Out            string           `cortana:"--out, -o, results.jsonl, the jsonl file of the results, which is also the checkpoint to resume" yaml:"-"`
+	Concurrency    int              `cortana:"--concurrency, -, 4, the number of the inputs to run concurrently" yaml:"-"`
+	RPM            int              `cortana:"--rpm, -, 0, the max requests per minute, 0 means unlimited" yaml:"-"`
+	Restart        bool             `cortana:"--restart, -, false, run all the inputs again instead of resuming" yaml:"-"`
+	Files          []string         `cortana:"file, -" yaml:"-"`
+}
+
+// BatchItem is an input of the batch
+type BatchItem struct {
+	ID   string
+	Text string
+	err  error // the input is invalid
+}
+
+// BatchResult is a line of the results
+type BatchResult struct {
+	ID           string       `json:"id"`
+	Reply        string       `json:"reply,omitempty"`
+	FinishReason string       `json:"finish_reason,omitempty"`
+	Model        string       `json:"model,omitempty"`
+	Usage        *Usage       `json:"usage,omitempty"`
+	Cost         float64      `json:"cost,omitempty"`
+	Error        *ResultError `json:"error,omitempty"`
+	Elapsed      float64      `json:"elapsed"` // in seconds
+}
+
+// BatchCommand runs a prompt over many inputs, each input is sent in an
+// independent oneshot conversation
+func (g *Guru) BatchCommand() {
+	opts := &BatchCommandOptions{}
+	cortana.Parse(opts)
+
+	if opts.Input == "" && len(opts.Files) == 0 {
+		g.Fatalln("no inputs, use --input or the files")
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 1
+	}
+	opts.Dir = expandPath(opts.Dir)
+	if err := initGuruDirs(opts.Dir); err != nil {
+		g.Fatalln("initialize guru directories failed", err)
+	}
+
+	g.resolveAPIKey(&opts.ClientOptions, opts.Dir)
+	httpCli := g.getHTTPClient(&opts.ClientOptions)
+	ap := NewAwesomePrompts(path.Join(opts.Dir, "prompt"), httpCli, g)
+	if err := ap.Load(); err != nil {
+		g.Fatalln(err)
+	}
+	// the prompt could be a name of the prompt repository or the text
+	prompt := opts.Prompt
+	if text := ap.PromptText(opts.Prompt); text != "" {
+		prompt = text
+	}
+
+	items, err := readBatchItems(opts.Input, opts.Field, opts.Files)
+	if err != nil {
+		g.Fatalln(err)
+	}
+
+	// skip the inputs done, the failed ones are run again
+	done := make(map[string]bool)
+	if !opts.Restart {
+		if done, err = readBatchCheckpoint(opts.Out); err != nil {
+			g.Fatalln(err)
+		}
+	}
+	out, err := openBatchOutput(opts.Out, opts.Restart)
+	if err != nil {
+		g.Fatalln(err)
+	}
+	defer out.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	cli := g.newChatClient(httpCli, &opts.ClientOptions, opts.Dir)
+	b := &batch{
+		cli:     cli,
+		opts:    opts,
+		prompt:  prompt,
+		ledger:  NewLedger(opts.Dir),
+		sid:     fmt.Sprintf("batch-%d-%s", time.Now().UnixMilli(), uuid.New().String()),
+		gate:    newRateGate(opts.RPM),
+		out:     g,
+		results: out,
+	}
+	skipped := b.runAll(ctx, items, done)
+
+	g.Println(fmt.Sprintf("%d succeeded, %d failed, %d skipped, cost $%.4f, results in %s",
+		b.succeeded, b.failed, skipped, b.cost, opts.Out))
+	if ctx.Err() != nil {
+		g.Println("interrupted, run the same command again to resume")
+		out.Close()
+		os.Exit(ExitCanceled)
+	}
+	if b.failed > 0 {
+		out.Close()
+		os.Exit(ExitError)
+	}
+}
+
+// openBatchOutput opens the results to append, or truncates them if
+// restart is true. The partial line written when killed is ended, so the
+// results appended are intact
+func openBatchOutput(filename string, restart bool) (*os.File, error) {
+	flags := os.O_CREATE | os.O_RDWR | os.O_APPEND
+	if restart {
+		flags |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(filename, flags, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil || info.Size() == 0 {
+		return f, err
+	}
+	last := make([]byte, 1)
+	if _, err := f.ReadAt(last, info.Size()-1); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if last[0] != '\n' {
+		fmt.Fprintln(f)
+	}
+	return f, nil
+}
+
+type batch struct {
+	cli    chat.Chat[*Question, *Answer, *AnswerChunk]
+	opts   *BatchCommandOptions
+	prompt string
+	ledger *Ledger
+	sid    string
+	gate   *rateGate
+	out    CommandOutput // reports the failures
+
+	mu        sync.Mutex
+	results   io.Writer
+	succeeded int
+	failed    int
+	cost      float64
+}
+
+// runAll runs the items not done by the workers of the concurrency, the
+// number of the items skipped is returned
+func (b *batch) runAll(ctx context.Context, items []*BatchItem, done map[string]bool) int {
+	var skipped int
+	ch := make(chan *BatchItem)
+	go func() {
+		defer close(ch)
+		for _, item := range items {
+			if done[item.ID] {
+				skipped++
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case ch <- item:
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < b.opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range ch {
+				if r := b.run(ctx, item); r != nil && r.Error != nil {
+					b.out.Errorln(fmt.Sprintf("%s: %s", r.ID, r.Error.Message))
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return skipped
+}
+
+// run asks the item and writes the result, nil is returned if canceled,
+// so the item is run again when resuming
+func (b *batch) run(ctx context.Context, item *BatchItem) *BatchResult {
+	begin := time.Now()
+	r := &BatchResult{ID: item.ID}
+	var answer *Answer
+	err := item.err
+	if err == nil {
+		answer, err = b.ask(ctx, item)
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	r.Elapsed = time.Since(begin).Seconds()
+
+	if err == nil {
+		for _, choice := range answer.Choices {
+			if choice.Message != nil {
+				r.Reply = strings.TrimSpace(choice.Message.Content)
+				r.FinishReason = choice.FinishReason
+				break
+			}
+		}
+		r.Model = answer.Model
+		if r.Model == "" {
+			r.Model = b.opts.Model
+		}
+		u := answer.Usage
+		estimated := u.TotalTokens == 0
+		if estimated {
+			u = estimateUsage(b.messages(item), r.Reply)
+		}
+		entry := NewUsageEntry(b.opts.Prices, b.sid, r.Model, u, estimated)
+		b.ledger.Append(entry)
+		r.Usage, r.Cost = &u, entry.Cost
+	} else {
+		_, typ := classify(err)
+		r.Error = &ResultError{Type: typ, Message: err.Error()}
+	}
+
+	data, _ := json.Marshal(r)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	fmt.Fprintln(b.results, string(data))
+	if r.Error != nil {
+		b.failed++
+	} else {
+		b.succeeded++
+		b.cost += r.Cost
+	}
+	return r
+}
+
+func (b *batch) messages(item *BatchItem) []*Message {
+	var messages []*Message
+	if b.opts.System != "" {
+		messages = append(messages, &Message{Role: System, Content: b.opts.System})
+	}
+	if b.prompt != "" {
+		messages = append(messages, &Message{Role: User, Content: b.prompt})
+	}
+	return append(messages, &Message{Role: User, Content: item.Text})
+}
+
+// ask sends the item, the client retries the failed requests by itself,
+// but all the workers are paused if it is still rate limited
+func (b *batch) ask(ctx context.Context, item *BatchItem) (*Answer, error) {
+	opts := b.opts.ChatGPTOptions
+	opts.Stream = false
+	q := &Question{ChatGPTOptions: opts, Messages: b.messages(item)}
+
+	if err := b.gate.wait(ctx); err != nil {
+		return nil, err
+	}
+	ans, err := b.cli.Ask(ctx, q)
+	if err == nil && ans.Error.Message != "" {
+		err = chat.NewError(0, ans.Error.Type, ans.Error.Code, ans.Error.Message)
+	}
+	var rateLimitErr *chat.RateLimitError
+	if errors.As(err, &rateLimitErr) && !rateLimitErr.Quota {
+		d := rateLimitErr.RetryAfter
+		if d <= 0 {
+			d = b.opts.RetryBackoff
+		}
+		b.gate.pause(d)
+	}
+	return ans, err
+}
+
+// rateGate limits the requests per minute, and pauses all the requests
+// when rate limited
+type rateGate struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time // the time the next request is allowed
+}
+
+func newRateGate(rpm int) *rateGate {
+	g := &rateGate{}
+	if rpm > 0 {
+		g.interval = time.Minute / time.Duration(rpm)
+	}
+	return g
+}
+
+func (g *rateGate) wait(ctx context.Context) error {
+	g.mu.Lock()
+	now := time.Now()
+	at := g.next
+	if at.Before(now) {
+		at = now
+	}
+	g.next = at.Add(g.interval)
+	g.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(time.Until(at)):
+		return nil
+	}
+}
+
+func (g *rateGate) pause(d time.Duration) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if until := time.Now().Add(d); until.After(g.next) {
+		g.next = until
+	}
+}
+
+// readBatchItems reads the jsonl rows of input and the files, a row is
+// a json string or an object with the field, the row number is the id if
+// the row has no id
+func readBatchItems(input, field string, files []string) ([]*BatchItem, error) {
+	var items []*BatchItem
+	if input != "" {
+		r := os.Stdin
+		if input != "-" {
+			f, err := os.Open(input)
+			if err != nil {
+				return nil, err
+			}
+			defer f.Close()
+			r = f
+		}
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(nil, 64<<20)
+		for n := 1; scanner.Scan(); n++ {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" {
+				continue
+			}
+			items = append(items, parseBatchRow(n, line, field))
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, &BatchItem{ID: file, Text: string(data)})
+	}
+
+	seen := make(map[string]bool)
+	for _, item := range items {
+		if seen[item.ID] {
+			return nil, fmt.Errorf("duplicated id: %s", item.ID)
+		}
+		seen[item.ID] = true
+	}
+	return items, nil
+}
+
+func parseBatchRow(n int, line, field string) *BatchItem {
+	item := &BatchItem{ID: fmt.Sprintf("line:%d", n)}
+	var v any
+	if err := json.Unmarshal([]byte(line), &v); err != nil {
+		item.err = fmt.Errorf("invalid json: %w", err)
+		return item
+	}
+	switch row := v.(type) {
+	case string:
+		item.Text = row
+	case map[string]any:
+		if id, ok := row["id"]; ok && id != nil {
+			item.ID = fmt.Sprint(id)
+		}
+		text, ok := row[field].(string)
+		if !ok {
+			item.err = fmt.Errorf("the field %q is not a string", field)
+		}
+		item.Text = text
+	default:
+		item.err = errors.New("the row should be a string or an object")
+	}
+	return item
+}
+
+// readBatchCheckpoint returns the ids done in the results, the last
+// result of an id wins
+func readBatchCheckpoint(filename string) (map[string]bool, error) {
+	done := make(map[string]bool)
+	f, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return done, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 64<<20)
+	for scanner.Scan() {
+		r := &BatchResult{}
+		// the last line may be partial if the process is killed
+		if err := json.Unmarshal(scanner.Bytes(), r); err != nil {
+			continue
+		}
+		done[r.ID] = r.Error == nil
+	}
+	return done, scanner.Err()
+}
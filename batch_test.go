@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/shafreeck/guru/chat"
+	"github.com/shafreeck/guru/mock"
+)
+
+// newTestBatch creates a batch asking the server, the results are written
+// to the file
+func newTestBatch(t *testing.T, url string, results *os.File, out *bytes.Buffer) *batch {
+	t.Helper()
+	opts := &BatchCommandOptions{Concurrency: 2, Field: "text"}
+	opts.Model = "gpt-test"
+	opts.RetryBackoff = time.Second
+	return &batch{
+		cli:     NewChatGPTClient(http.DefaultClient, url+"/v1", "sk-test-key", &opts.ChatGPTOptions, chat.WithRetry(0, 0, 0)),
+		opts:    opts,
+		ledger:  NewLedger(t.TempDir()),
+		sid:     "batch-test",
+		gate:    newRateGate(0),
+		out:     New(WithStdout(out)),
+		results: results,
+	}
+}
+
+// readBatchResults reads the results by id, and the lines not results
+func readBatchResults(t *testing.T, filename string) (map[string][]*BatchResult, []string) {
+	t.Helper()
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	results := make(map[string][]*BatchResult)
+	var broken []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		r := &BatchResult{}
+		if err := json.Unmarshal(s.Bytes(), r); err != nil {
+			broken = append(broken, s.Text())
+			continue
+		}
+		results[r.ID] = append(results[r.ID], r)
+	}
+	return results, broken
+}
+
+func TestBatchResume(t *testing.T) {
+	var items []*BatchItem
+	for i := 1; i <= 10; i++ {
+		items = append(items, parseBatchRow(i, fmt.Sprintf(`{"id": "item-%d", "text": "text %d"}`, i, i), "text"))
+	}
+
+	// the run is interrupted at the 5th request, the requests in flight
+	// are canceled
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var served, interrupted atomic.Int32
+	interrupted.Store(1)
+	m := mock.New()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if interrupted.Load() == 1 && served.Add(1) > 4 {
+			cancel()
+			// the closed connection is noticed after the body is read
+			io.ReadAll(r.Body)
+			<-r.Context().Done()
+			return
+		}
+		m.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	filename := filepath.Join(t.TempDir(), "results.jsonl")
+	out, err := openBatchOutput(filename, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var output bytes.Buffer
+	b := newTestBatch(t, srv.URL, out, &output)
+	b.runAll(ctx, items, nil)
+	// the process is killed when writing a result
+	out.WriteString(`{"id": "item-9", "rep`)
+	out.Close()
+	// the replies in flight may be canceled too
+	first := b.succeeded
+	if first == 0 || first > 4 || b.failed != 0 {
+		t.Fatalf("%d succeeded and %d failed before interrupted", b.succeeded, b.failed)
+	}
+
+	interrupted.Store(0)
+	done, err := readBatchCheckpoint(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, err = openBatchOutput(filename, false); err != nil {
+		t.Fatal(err)
+	}
+	b = newTestBatch(t, srv.URL, out, &output)
+	skipped := b.runAll(context.Background(), items, done)
+	out.Close()
+	if skipped != first || b.succeeded != len(items)-first || b.failed != 0 {
+		t.Errorf("%d skipped and %d succeeded when resumed, %d done before", skipped, b.succeeded, first)
+	}
+
+	results, broken := readBatchResults(t, filename)
+	if len(broken) != 1 {
+		t.Errorf("the broken lines %q, want the partial one only", broken)
+	}
+	for _, item := range items {
+		rs := results[item.ID]
+		if len(rs) != 1 {
+			t.Errorf("%d results of %s, want 1", len(rs), item.ID)
+			continue
+		}
+		if rs[0].Reply != item.Text || rs[0].Error != nil {
+			t.Errorf("the result of %s is %+v", item.ID, rs[0])
+		}
+	}
+}
+
+func TestBatchFailures(t *testing.T) {
+	srv := httptest.NewServer(mock.New())
+	defer srv.Close()
+	lines := []string{
+		`"hello"`,
+		`{"id": "broken", "text": `,
+		`{"id": "number", "text": 1}`,
+		`{"id": "auth", "text": "` + mock.ErrorPrefix + `401"}`,
+		`{"id": "limited", "text": "` + mock.ErrorPrefix + `429"}`,
+		`["array"]`,
+	}
+	var items []*BatchItem
+	for i, line := range lines {
+		items = append(items, parseBatchRow(i+1, line, "text"))
+	}
+
+	filename := filepath.Join(t.TempDir(), "results.jsonl")
+	out, err := openBatchOutput(filename, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	var output bytes.Buffer
+	b := newTestBatch(t, srv.URL, out, &output)
+	b.opts.Concurrency = 1
+	b.runAll(context.Background(), items, nil)
+	if b.succeeded != 1 || b.failed != 5 {
+		t.Errorf("%d succeeded and %d failed, want 1 and 5", b.succeeded, b.failed)
+	}
+
+	results, _ := readBatchResults(t, filename)
+	want := map[string]string{
+		"line:1":  "",
+		"line:2":  "error",
+		"number":  "error",
+		"auth":    "auth_error",
+		"limited": "rate_limited",
+		"line:6":  "error",
+	}
+	for id, typ := range want {
+		rs := results[id]
+		if len(rs) != 1 {
+			t.Errorf("%d results of %s, want 1", len(rs), id)
+			continue
+		}
+		switch r := rs[0]; {
+		case typ == "" && (r.Error != nil || r.Reply != "hello" || r.Usage == nil):
+			t.Errorf("the result of %s is %+v, want the reply", id, r)
+		case typ != "" && (r.Error == nil || r.Error.Type != typ):
+			t.Errorf("the error of %s is %+v, want %s", id, r.Error, typ)
+		}
+		if typ != "" && !strings.Contains(output.String(), id+": ") {
+			t.Errorf("the failure of %s is not reported: %s", id, output.String())
+		}
+	}
+	// the failed ones are run again when resuming
+	done, _ := readBatchCheckpoint(filename)
+	if len(done) != 6 || !done["line:1"] || done["auth"] {
+		t.Errorf("checkpoint %v", done)
+	}
+
+	// the workers are paused when rate limited
+	b.gate.mu.Lock()
+	next := b.gate.next
+	b.gate.mu.Unlock()
+	if time.Until(next) < 500*time.Millisecond {
+		t.Errorf("the requests are not paused after rate limited")
+	}
+}
+
+func TestOpenBatchOutput(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "results.jsonl")
+	for _, c := range []struct {
+		content string
+		restart bool
+		want    string
+	}{
+		{"", false, "x\n"},
+		{"{}\n", false, "{}\nx\n"},
+		{"{}\n{\"id\"", false, "{}\n{\"id\"\nx\n"},
+		{"{}\n{\"id\"", true, "x\n"},
+	} {
+		os.WriteFile(filename, []byte(c.content), 0644)
+		f, err := openBatchOutput(filename, c.restart)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.WriteString("x\n")
+		f.Close()
+		if data, _ := os.ReadFile(filename); string(data) != c.want {
+			t.Errorf("%q, restart %v: got %q, want %q", c.content, c.restart, data, c.want)
+		}
+	}
+}
+
+func TestRateGate(t *testing.T) {
+	const interval = 20 * time.Millisecond
+	g := newRateGate(int(time.Minute / interval))
+
+	// the requests are spaced by the interval even if they wait together
+	start := time.Now()
+	var mu sync.Mutex
+	var times []time.Duration
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := g.wait(context.Background()); err != nil {
+				t.Error(err)
+			}
+			mu.Lock()
+			times = append(times, time.Since(start))
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+	if times[4] < 4*interval {
+		t.Errorf("5 requests in %v, want at least %v", times[4], 4*interval)
+	}
+
+	// all the requests are paused
+	g.pause(100 * time.Millisecond)
+	start = time.Now()
+	g.wait(context.Background())
+	if d := time.Since(start); d < 90*time.Millisecond {
+		t.Errorf("waited %v after paused, want 100ms", d)
+	}
+
+	// the waiting is canceled
+	g.pause(time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := g.wait(ctx); err != context.Canceled {
+		t.Errorf("err %v, want canceled", err)
+	}
+
+	// unlimited
+	g = newRateGate(0)
+	start = time.Now()
+	for i := 0; i < 100; i++ {
+		g.wait(context.Background())
+	}
+	if d := time.Since(start); d > 50*time.Millisecond {
+		t.Errorf("100 unlimited requests in %v", d)
+	}
+}
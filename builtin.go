@@ -6,7 +6,6 @@ import (
 	"os"
 	"strings"
 
-	"github.com/charmbracelet/lipgloss"
 	"github.com/shafreeck/cortana"
 	"github.com/shafreeck/guru/tui"
 )
@@ -28,9 +27,8 @@ type BuiltinCommand struct {
 func (c *BuiltinCommand) Launch(args []string) string {
 	cmd := c.SearchCommand(args)
 	if cmd == nil {
-		usage := lipgloss.NewStyle().Foreground(
-			lipgloss.AdaptiveColor{Dark: "#79b3ec", Light: "#1d73c9"}).
-			Render(c.UsageString())
+		theme := tui.CurrentTheme()
+		usage := theme.Style(theme.Text).Render(c.UsageString())
 		fmt.Fprint(tui.Stdout, usage)
 		return ""
 	}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/shafreeck/cortana"
+	"github.com/shafreeck/guru/chat"
+)
+
+// cacheEntry is the reply of a question saved in the cache, either
+// the Answer or the Chunks is set depending on the stream mode
+type cacheEntry struct {
+	Created time.Time
+	Answer  *Answer        `json:",omitempty"`
+	Chunks  []*AnswerChunk `json:",omitempty"`
+}
+
+// Cache saves the replies on disk keyed by the hash of questions
+type Cache struct {
+	dir     string
+	ttl     time.Duration
+	maxSize int64 // the max size of the cache in bytes, 0 means unlimited
+}
+
+func NewCache(dir string, ttl time.Duration, maxSize int64) *Cache {
+	return &Cache{dir: dir, ttl: ttl, maxSize: maxSize}
+}
+
+// Key hashes the normalized question, the options unrelated to the reply
+// such as stream are ignored, so a reply could be replayed in both modes.
+func (c *Cache) Key(namespace string, q *Question) (string, error) {
+	nq := *q
+	nq.Stream = false
+	nq.StreamOptions = nil
+	nq.User = ""
+	nq.Messages = nil
+	for _, m := range q.Messages {
+		nq.Messages = append(nq.Messages, &Message{Role: m.Role, Content: strings.TrimSpace(m.Content)})
+	}
+	// the schema is compacted with the keys sorted, so the same schema
+	// written in another order or format hits the cache
+	if rf := q.ResponseFormat; rf != nil && rf.JSONSchema != nil {
+		var schema any
+		if err := json.Unmarshal(rf.JSONSchema.Schema, &schema); err != nil {
+			return "", err
+		}
+		data, err := json.Marshal(schema)
+		if err != nil {
+			return "", err
+		}
+		nq.ResponseFormat = &ResponseFormat{Type: rf.Type,
+			JSONSchema: &JSONSchemaFormat{Name: rf.JSONSchema.Name, Schema: data}}
+	}
+	data, err := json.Marshal(nq)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	h.Write([]byte(namespace))
+	h.Write([]byte{0})
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Get returns the entry of key, nil if not found or expired
+func (c *Cache) Get(key string) *cacheEntry {
+	e := c.read(key)
+	if e == nil {
+		return nil
+	}
+	// touch the file, so the recently used entries are evicted last
+	now := time.Now()
+	os.Chtimes(path.Join(c.dir, key), now, now)
+	return e
+}
+
+// read returns the entry of key, the invalid or expired entry is removed
+func (c *Cache) read(key string) *cacheEntry {
+	file := path.Join(c.dir, key)
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil
+	}
+	e := &cacheEntry{}
+	if err := json.Unmarshal(data, e); err != nil {
+		os.Remove(file)
+		return nil
+	}
+	if c.ttl > 0 && time.Since(e.Created) > c.ttl {
+		os.Remove(file)
+		return nil
+	}
+	return e
+}
+
+// Put saves the entry and evicts the least recently used entries
+// if the cache size exceeds
+func (c *Cache) Put(key string, e *cacheEntry) error {
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return err
+	}
+	e.Created = time.Now()
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	// write to a temp file and rename, so a partial entry is never read
+	tmp := path.Join(c.dir, "."+key)
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path.Join(c.dir, key)); err != nil {
+		return err
+	}
+	return c.evict()
+}
+
+type cacheFile struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (c *Cache) files() ([]*cacheFile, error) {
+	entries, err := os.ReadDir(c.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var files []*cacheFile
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, &cacheFile{name: entry.Name(), size: info.Size(), modTime: info.ModTime()})
+	}
+	return files, nil
+}
+
+// evict removes the least recently used entries until the size is under the limit
+func (c *Cache) evict() error {
+	if c.maxSize <= 0 {
+		return nil
+	}
+	files, err := c.files()
+	if err != nil {
+		return err
+	}
+	var total int64
+	for _, f := range files {
+		total += f.size
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+	for _, f := range files {
+		if total <= c.maxSize {
+			break
+		}
+		if err := os.Remove(path.Join(c.dir, f.name)); err != nil {
+			return err
+		}
+		total -= f.size
+	}
+	return nil
+}
+
+// Clear removes all the entries, or only the expired ones
+func (c *Cache) Clear(expiredOnly bool) (int, error) {
+	files, err := c.files()
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, f := range files {
+		if expiredOnly {
+			// the expired entries are removed when read
+			if e := c.read(f.name); e == nil {
+				n++
+			}
+			continue
+		}
+		if err := os.Remove(path.Join(c.dir, f.name)); err != nil && !os.IsNotExist(err) {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// CachedChat replies the questions from the cache if hit, or
+// asks the underlying chat and saves the replies
+type CachedChat struct {
+	c         chat.Chat[*Question, *Answer, *AnswerChunk]
+	cache     *Cache
+	namespace string // the api the questions are sent to
+}
+
+func NewCachedChat(c chat.Chat[*Question, *Answer, *AnswerChunk], cache *Cache, namespace string) *CachedChat {
+	return &CachedChat{c: c, cache: cache, namespace: namespace}
+}
+
+func (cc *CachedChat) Ask(ctx context.Context, q *Question) (*Answer, error) {
+	key, err := cc.cache.Key(cc.namespace, q)
+	if err != nil {
+		return nil, err
+	}
+	if e := cc.cache.Get(key); e != nil {
+		ans := e.Answer
+		if ans == nil {
+			ans = answerFromChunks(e.Chunks)
+		}
+		ans.Cached = true
+		return ans, nil
+	}
+
+	ans, err := cc.c.Ask(ctx, q)
+	if err != nil || ans.Error.Message != "" {
+		return ans, err
+	}
+	// caching is best effort, it never fails the request
+	cc.cache.Put(key, &cacheEntry{Answer: ans})
+	return ans, nil
+}
+
+func (cc *CachedChat) Stream(ctx context.Context, q *Question) (chan *AnswerChunk, error) {
+	key, err := cc.cache.Key(cc.namespace, q)
+	if err != nil {
+		return nil, err
+	}
+	if e := cc.cache.Get(key); e != nil {
+		chunks := e.Chunks
+		if chunks == nil {
+			chunks = chunksFromAnswer(e.Answer)
+		}
+		return replayChunks(ctx, chunks), nil
+	}
+
+	upstream, err := cc.c.Stream(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	// tee the chunks and save them if the stream finishes without errors
+	ch := make(chan *AnswerChunk)
+	go func() {
+		defer close(ch)
+		var chunks []*AnswerChunk
+		failed := false
+		for chunk := range upstream {
+			chunks = append(chunks, chunk)
+			failed = failed || chunk.Error.Message != ""
+			select {
+			case <-ctx.Done():
+				return
+			case ch <- chunk:
+			}
+		}
+		if !failed && ctx.Err() == nil && len(chunks) > 0 {
+			cc.cache.Put(key, &cacheEntry{Chunks: chunks})
+		}
+	}()
+	return ch, nil
+}
+
+// replayChunks sends the cached chunks one by one
+func replayChunks(ctx context.Context, chunks []*AnswerChunk) chan *AnswerChunk {
+	ch := make(chan *AnswerChunk)
+	go func() {
+		defer close(ch)
+		for _, chunk := range chunks {
+			chunk.Cached = true
+			select {
+			case <-ctx.Done():
+				return
+			case ch <- chunk:
+			}
+		}
+	}()
+	return ch
+}
+
+// answerFromChunks combines the chunks into an answer
+func answerFromChunks(chunks []*AnswerChunk) *Answer {
+	ans := &Answer{Object: "chat.completion"}
+	contents := make(map[int]*strings.Builder)
+	reasons := make(map[int]string)
+	for _, chunk := range chunks {
+		ans.ID, ans.Created, ans.Model = chunk.ID, chunk.Created, chunk.Model
+		if chunk.Usage != nil {
+			ans.Usage = *chunk.Usage
+		}
+		for _, choice := range chunk.Choices {
+			b := contents[choice.Index]
+			if b == nil {
+				b = &strings.Builder{}
+				contents[choice.Index] = b
+			}
+			b.WriteString(choice.Delta.Content)
+			if choice.FinishReason != "" {
+				reasons[choice.Index] = choice.FinishReason
+			}
+		}
+	}
+	for i := 0; i < len(contents); i++ {
+		content := ""
+		if b := contents[i]; b != nil {
+			content = b.String()
+		}
+		ans.Choices = append(ans.Choices, AnswerChoice{Index: i, FinishReason: reasons[i],
+			Message: &Message{Role: Assistant, Content: content}})
+	}
+	return ans
+}
+
+// chunksFromAnswer splits the answer into chunks, a chunk for each choice
+func chunksFromAnswer(ans *Answer) []*AnswerChunk {
+	var chunks []*AnswerChunk
+	for _, choice := range ans.Choices {
+		chunk := &AnswerChunk{ID: ans.ID, Object: "chat.completion.chunk", Created: ans.Created, Model: ans.Model}
+		chunk.Choices = make([]ChunkChoice, 1)
+		chunk.Choices[0].Index = choice.Index
+		chunk.Choices[0].FinishReason = choice.FinishReason
+		if choice.Message != nil {
+			chunk.Choices[0].Delta.Content = choice.Message.Content
+		}
+		chunks = append(chunks, chunk)
+	}
+	if len(chunks) > 0 {
+		usage := ans.Usage
+		chunks[len(chunks)-1].Usage = &usage
+	}
+	return chunks
+}
+
+type cacheCommandOptions struct {
+	Dir string        `cortana:"--dir,-, ~/.guru, the guru directory" yaml:"dir"`
+	TTL time.Duration `cortana:"--cache-ttl, -, 24h, the time to live of the cached replies" yaml:"cache-ttl"`
+}
+
+// CacheStatsCommand shows the stats of the cache
+func (g *Guru) CacheStatsCommand() {
+	opts := cacheCommandOptions{}
+	cortana.Parse(&opts)
+
+	cache := NewCache(path.Join(expandPath(opts.Dir), "cache"), opts.TTL, 0)
+	files, err := cache.files()
+	if err != nil {
+		g.Fatalln(err)
+	}
+	var size int64
+	var oldest, newest time.Time
+	for _, f := range files {
+		size += f.size
+		if oldest.IsZero() || f.modTime.Before(oldest) {
+			oldest = f.modTime
+		}
+		if f.modTime.After(newest) {
+			newest = f.modTime
+		}
+	}
+	g.Println(fmt.Sprintf("%-15s %s", "directory", cache.dir))
+	g.Println(fmt.Sprintf("%-15s %d", "entries", len(files)))
+	g.Println(fmt.Sprintf("%-15s %.2f MB", "size", float64(size)/(1<<20)))
+	if len(files) > 0 {
+		g.Println(fmt.Sprintf("%-15s %s", "least used", oldest.Format(time.RFC3339)))
+		g.Println(fmt.Sprintf("%-15s %s", "recently used", newest.Format(time.RFC3339)))
+	}
+}
+
+// CacheClearCommand removes the cached replies
+func (g *Guru) CacheClearCommand() {
+	opts := struct {
+		cacheCommandOptions `yaml:",inline"`
+		Expired             bool `cortana:"--expired, -, false, clear the expired entries only"`
+	}{}
+	cortana.Parse(&opts)
+
+	cache := NewCache(path.Join(expandPath(opts.Dir), "cache"), opts.TTL, 0)
+	n, err := cache.Clear(opts.Expired)
+	if err != nil {
+		g.Fatalln(err)
+	}
+	g.Println(fmt.Sprintf("%d entries removed", n))
+}
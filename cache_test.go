@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCacheKey(t *testing.T) {
+	c := NewCache(t.TempDir(), 0, 0)
+	question := func(model, content, schema string) *Question {
+		q := &Question{Messages: []*Message{{Role: System, Content: "be brief"}, {Role: User, Content: content}}}
+		q.Model = model
+		if schema != "" {
+			q.ResponseFormat = &ResponseFormat{Type: "json_schema",
+				JSONSchema: &JSONSchemaFormat{Name: "reply", Schema: json.RawMessage(schema)}}
+		}
+		return q
+	}
+	key := func(namespace string, q *Question) string {
+		k, err := c.Key(namespace, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+
+	base := key("openai", question("gpt-4", "hello", `{"type":"object","required":["a"]}`))
+	streamed := question("gpt-4", "  hello\n", `{ "required": ["a"], "type": "object" }`)
+	streamed.Stream, streamed.User = true, "someone"
+	streamed.StreamOptions = &StreamOptions{IncludeUsage: true}
+	if got := key("openai", streamed); got != base {
+		t.Error("the whitespace, the stream options or the order of the schema changes the key")
+	}
+	for name, k := range map[string]string{
+		"model":     key("openai", question("gpt-3.5-turbo", "hello", `{"type":"object","required":["a"]}`)),
+		"content":   key("openai", question("gpt-4", "hello!", `{"type":"object","required":["a"]}`)),
+		"schema":    key("openai", question("gpt-4", "hello", `{"type":"object"}`)),
+		"namespace": key("azure", question("gpt-4", "hello", `{"type":"object","required":["a"]}`)),
+	} {
+		if k == base {
+			t.Errorf("the %s does not change the key", name)
+		}
+	}
+	if _, err := c.Key("openai", question("gpt-4", "hello", `{"type":`)); err == nil {
+		t.Error("the invalid schema is hashed")
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	c := NewCache(t.TempDir(), time.Hour, 0)
+	if err := c.Put("fresh", &cacheEntry{Answer: &Answer{ID: "fresh"}}); err != nil {
+		t.Fatal(err)
+	}
+	// an entry saved two hours ago
+	data, err := json.Marshal(&cacheEntry{Created: time.Now().Add(-2 * time.Hour), Answer: &Answer{ID: "stale"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"stale", "expired"} {
+		if err := os.WriteFile(filepath.Join(c.dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if e := c.Get("fresh"); e == nil || e.Answer.ID != "fresh" {
+		t.Errorf("the fresh entry is %+v", e)
+	}
+	if e := c.Get("stale"); e != nil {
+		t.Errorf("the expired entry is %+v", e)
+	}
+	if _, err := os.Stat(filepath.Join(c.dir, "stale")); !os.IsNotExist(err) {
+		t.Errorf("the expired entry is not removed: %v", err)
+	}
+	if n, err := c.Clear(true); err != nil || n != 1 {
+		t.Errorf("%d expired entries cleared, %v, want 1", n, err)
+	}
+	if n, err := c.Clear(false); err != nil || n != 1 {
+		t.Errorf("%d entries cleared, %v, want the fresh one", n, err)
+	}
+}
+
+func TestCacheEvict(t *testing.T) {
+	c := NewCache(t.TempDir(), 0, 0)
+	entry := func(id string) *cacheEntry { return &cacheEntry{Answer: &Answer{ID: id}} }
+	if err := c.Put("a", entry("a")); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filepath.Join(c.dir, "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// two entries fit in the cache
+	c.maxSize = 2*info.Size() + 16
+	if err := c.Put("b", entry("b")); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Minute)
+	os.Chtimes(filepath.Join(c.dir, "a"), old, old)
+	os.Chtimes(filepath.Join(c.dir, "b"), old.Add(time.Second), old.Add(time.Second))
+
+	// a is used recently, so b is evicted first
+	if c.Get("a") == nil {
+		t.Fatal("a is not found")
+	}
+	if err := c.Put("c", entry("c")); err != nil {
+		t.Fatal(err)
+	}
+	if c.Get("b") != nil {
+		t.Error("the least recently used entry is not evicted")
+	}
+	if c.Get("a") == nil || c.Get("c") == nil {
+		t.Error("the recently used entries are evicted")
+	}
+}
+
+// fakeChat replies the chunks, and counts the requests
+type fakeChat struct {
+	chunks []*AnswerChunk
+	block  chan struct{} // the chunks after the first wait for it if set
+	calls  int
+}
+
+func (f *fakeChat) Ask(ctx context.Context, q *Question) (*Answer, error) {
+	f.calls++
+	return answerFromChunks(f.chunks), nil
+}
+
+func (f *fakeChat) Stream(ctx context.Context, q *Question) (chan *AnswerChunk, error) {
+	f.calls++
+	ch := make(chan *AnswerChunk)
+	go func() {
+		defer close(ch)
+		for i, chunk := range f.chunks {
+			if i > 0 && f.block != nil {
+				<-f.block
+			}
+			ch <- chunk
+		}
+	}()
+	return ch, nil
+}
+
+func newChunk(index int, content, reason string) *AnswerChunk {
+	chunk := &AnswerChunk{ID: "chatcmpl-1", Object: "chat.completion.chunk", Model: "gpt-test",
+		Choices: make([]ChunkChoice, 1)}
+	chunk.Choices[0].Index, chunk.Choices[0].FinishReason = index, reason
+	chunk.Choices[0].Delta.Content = content
+	return chunk
+}
+
+func collect(t *testing.T, c *CachedChat, q *Question) []*AnswerChunk {
+	t.Helper()
+	ch, err := c.Stream(context.Background(), q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var chunks []*AnswerChunk
+	for chunk := range ch {
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
+func TestCachedChatReplay(t *testing.T) {
+	last := newChunk(1, "or two", "stop")
+	last.Usage = &Usage{PromptTokens: 3, CompletionTokens: 4, TotalTokens: 7}
+	fake := &fakeChat{chunks: []*AnswerChunk{newChunk(0, "one ", ""), newChunk(1, "two ", ""),
+		newChunk(0, "choice", "stop"), last}}
+	cc := NewCachedChat(fake, NewCache(t.TempDir(), 0, 0), "openai")
+	q := &Question{Messages: []*Message{{Role: User, Content: "hi"}}}
+
+	original := collect(t, cc, q)
+	if len(original) != 4 {
+		t.Fatalf("%d chunks, want 4", len(original))
+	}
+	replayed := collect(t, cc, q)
+	if fake.calls != 1 {
+		t.Fatalf("%d requests, want the replay from the cache", fake.calls)
+	}
+	for i := range replayed {
+		if !replayed[i].Cached {
+			t.Errorf("the chunk %d is not marked as cached", i)
+		}
+		replayed[i].Cached = false
+	}
+	if !reflect.DeepEqual(replayed, original) {
+		t.Errorf("the replayed stream differs:\n%+v\n%+v", replayed, original)
+	}
+
+	// the stream is replayed as an answer as well
+	ans, err := cc.Ask(context.Background(), q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fake.calls != 1 || !ans.Cached || len(ans.Choices) != 2 || ans.Usage.TotalTokens != 7 ||
+		ans.Choices[0].Message.Content != "one choice" || ans.Choices[1].Message.Content != "two or two" {
+		t.Errorf("the answer replayed is %+v", ans)
+	}
+}
+
+func TestCachedChatSkipsFailures(t *testing.T) {
+	failed := newChunk(0, "", "")
+	failed.Error.Message = "overloaded"
+	fake := &fakeChat{chunks: []*AnswerChunk{newChunk(0, "partial", ""), failed}}
+	cc := NewCachedChat(fake, NewCache(t.TempDir(), 0, 0), "openai")
+	q := &Question{Messages: []*Message{{Role: User, Content: "hi"}}}
+
+	collect(t, cc, q)
+	collect(t, cc, q)
+	if fake.calls != 2 {
+		t.Errorf("%d requests, want the errored stream not cached", fake.calls)
+	}
+
+	// the stream canceled in the middle is not cached
+	fake = &fakeChat{chunks: []*AnswerChunk{newChunk(0, "partial", ""), newChunk(0, " rest", "stop")},
+		block: make(chan struct{})}
+	cc = NewCachedChat(fake, NewCache(t.TempDir(), 0, 0), "openai")
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := cc.Stream(ctx, q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-ch
+	cancel()
+	close(fake.block)
+	for range ch {
+	}
+	if files, _ := cc.cache.files(); len(files) != 0 {
+		t.Errorf("the partial stream is cached: %d entries", len(files))
+	}
+}
@@ -0,0 +1,236 @@
+// Package cassette records the http interactions into a directory and
+// replays them offline, which makes the tests deterministic
+package cassette
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"sync"
+)
+
+// the headers carrying secrets are redacted when recording
+var redactedHeaders = []string{"Authorization", "Api-Key", "X-Api-Key", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+type Request struct {
+	Method string
+	URL    string
+	Header http.Header
+	Body   string
+}
+
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       string // the raw body, a stream is recorded as a whole
+}
+
+// Interaction is a pair of request and response
+type Interaction struct {
+	Request  Request
+	Response Response
+}
+
+// key identifies the requests, the headers are not considered
+func key(method, url string, body []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s\n", method, url)
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// redact redacts the known headers carrying secrets and the extra ones
+func redact(header http.Header, extra []string) http.Header {
+	header = header.Clone()
+	for _, list := range [][]string{redactedHeaders, extra} {
+		for _, k := range list {
+			if header.Get(k) != "" {
+				header.Set(k, "REDACTED")
+			}
+		}
+	}
+	return header
+}
+
+// readBody reads and restores the body of the request
+func readBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	data, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(data))
+	return data, nil
+}
+
+// cassette is a file holding the interactions of the same request,
+// which are replayed in order
+type cassette struct {
+	Interactions []*Interaction
+}
+
+func load(filename string) (*cassette, error) {
+	c := &cassette{}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *cassette) save(filename string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, data, 0644)
+}
+
+// Recorder is a http.RoundTripper which records the interactions
+type Recorder struct {
+	dir      string
+	next     http.RoundTripper
+	redacted []string // the headers redacted besides the known ones
+	mu       sync.Mutex
+}
+
+// NewRecorder records the interactions through next into dir,
+// http.DefaultTransport is used if next is nil. The headers like the
+// tokens of a gateway are redacted besides the known ones like Authorization
+func NewRecorder(dir string, next http.RoundTripper, redacted ...string) (*Recorder, error) {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Recorder{dir: dir, next: next, redacted: redacted}, nil
+}
+
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readBody(req)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	it := &Interaction{
+		Request: Request{Method: req.Method, URL: req.URL.String(),
+			Header: redact(req.Header, r.redacted), Body: string(body)},
+		Response: Response{StatusCode: resp.StatusCode, Header: redact(resp.Header, r.redacted)},
+	}
+	// the response body is teed and saved when closed, so a stream
+	// is still delivered in time when recording
+	resp.Body = &teeBody{ReadCloser: resp.Body, onClose: func(data []byte) error {
+		it.Response.Body = string(data)
+		return r.save(key(req.Method, req.URL.String(), body), it)
+	}}
+	return resp, nil
+}
+
+func (r *Recorder) save(key string, it *Interaction) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	filename := path.Join(r.dir, key+".json")
+	c, err := load(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		c, err = &cassette{}, nil
+	}
+	if err != nil {
+		return err
+	}
+	c.Interactions = append(c.Interactions, it)
+	return c.save(filename)
+}
+
+type teeBody struct {
+	io.ReadCloser
+	buf     bytes.Buffer
+	once    sync.Once
+	onClose func(data []byte) error
+}
+
+func (b *teeBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.buf.Write(p[:n])
+	return n, err
+}
+
+func (b *teeBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() {
+		if e := b.onClose(b.buf.Bytes()); e != nil && err == nil {
+			err = e
+		}
+	})
+	return err
+}
+
+// Replayer is a http.RoundTripper which replies the recorded interactions
+type Replayer struct {
+	dir    string
+	mu     sync.Mutex
+	played map[string]int // the number of interactions played of a request
+}
+
+func NewReplayer(dir string) (*Replayer, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	return &Replayer{dir: dir, played: make(map[string]int)}, nil
+}
+
+// RoundTrip replies the interactions of the same request in order,
+// and the last one is replied repeatedly if all have been played
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readBody(req)
+	if err != nil {
+		return nil, err
+	}
+	k := key(req.Method, req.URL.String(), body)
+	c, err := load(path.Join(r.dir, k+".json"))
+	if errors.Is(err, os.ErrNotExist) || (err == nil && len(c.Interactions) == 0) {
+		return nil, fmt.Errorf("cassette: no interaction recorded for %s %s", req.Method, req.URL)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	i := r.played[k]
+	if i >= len(c.Interactions) {
+		i = len(c.Interactions) - 1
+	}
+	r.played[k]++
+	r.mu.Unlock()
+
+	it := c.Interactions[i]
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", it.Response.StatusCode, http.StatusText(it.Response.StatusCode)),
+		StatusCode:    it.Response.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        it.Response.Header.Clone(),
+		Body:          io.NopCloser(bytes.NewBufferString(it.Response.Body)),
+		ContentLength: int64(len(it.Response.Body)),
+		Request:       req,
+	}, nil
+}
@@ -0,0 +1,142 @@
+package cassette
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// record sends a request through the recorder
+func record(t *testing.T, rec *Recorder, url, body string, header http.Header) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header = header
+	resp, err := (&http.Client{Transport: rec}).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.ReadAll(resp.Body)
+	resp.Body.Close()
+}
+
+func TestRecorderRedactsHeaders(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Set-Cookie", "session=secret-cookie")
+		io.WriteString(w, "ok")
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	rec, err := NewRecorder(dir, nil, "X-Gateway-Token", "x-team-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	record(t, rec, srv.URL, "{}", http.Header{
+		"Authorization":   {"Bearer sk-secret-key"},
+		"X-Gateway-Token": {"secret-gateway-token"},
+		"X-Team-Secret":   {"secret-team"},
+		"X-Team":          {"ai"},
+	})
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(files) != 1 {
+		t.Fatalf("%d cassettes recorded, want 1", len(files))
+	}
+	data, _ := os.ReadFile(files[0])
+	for _, secret := range []string{"sk-secret-key", "secret-gateway-token", "secret-team", "secret-cookie"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("the secret %q is recorded", secret)
+		}
+	}
+	if !strings.Contains(string(data), `"ai"`) {
+		t.Errorf("the header not secret is not recorded:\n%s", data)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	const stream = "data: {\"text\":\"hello\"}\n\ndata: [DONE]\n\n"
+	var replies int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if string(body) == `{"stream":true}` {
+			w.Header().Set("Content-Type", "text/event-stream")
+			io.WriteString(w, stream)
+			return
+		}
+		replies++
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"reply":`+string(rune('0'+replies))+`}`)
+	}))
+
+	dir := t.TempDir()
+	rec, err := NewRecorder(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := http.Header{"Authorization": {"Bearer sk-secret-key"}}
+	record(t, rec, srv.URL, `{"stream":true}`, header)
+	record(t, rec, srv.URL, `{}`, header)
+	record(t, rec, srv.URL, `{}`, header)
+	// the interactions are replayed offline
+	srv.Close()
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(files) != 2 {
+		t.Fatalf("%d cassettes recorded, want 2", len(files))
+	}
+	for _, file := range files {
+		data, _ := os.ReadFile(file)
+		if strings.Contains(string(data), "sk-secret-key") {
+			t.Errorf("the api key is recorded in %s", file)
+		}
+	}
+
+	rep, err := NewReplayer(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	do := func(body string, header http.Header) (*http.Response, string, error) {
+		req, _ := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader(body))
+		req.Header = header
+		resp, err := (&http.Client{Transport: rep}).Do(req)
+		if err != nil {
+			return nil, "", err
+		}
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+		return resp, string(data), err
+	}
+
+	// the request is matched by the body, but not the headers which
+	// are redacted
+	resp, body, err := do(`{"stream":true}`, http.Header{"Authorization": {"Bearer sk-another-key"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != stream || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Errorf("replied %q of %q, want the stream", body, resp.Header.Get("Content-Type"))
+	}
+
+	// the interactions of the same request are replayed in order, and the
+	// last one is repeated
+	for _, want := range []string{`{"reply":1}`, `{"reply":2}`, `{"reply":2}`} {
+		_, body, err := do(`{}`, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body != want {
+			t.Errorf("replied %q, want %q", body, want)
+		}
+	}
+
+	if _, _, err := do(`{"other":true}`, nil); err == nil || !strings.Contains(err.Error(), "no interaction recorded") {
+		t.Errorf("err %v, want no interaction recorded", err)
+	}
+}
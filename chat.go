@@ -3,11 +3,18 @@ package main
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
-	"net/http"
+	"os"
+	"os/signal"
+	"path"
+	"sort"
 	"strings"
+	"time"
 
+	tea "github.com/charmbracelet/bubbletea"
 	"github.com/shafreeck/guru/chat"
+	"github.com/shafreeck/guru/jsonschema"
 	"github.com/shafreeck/guru/tui"
 )
 
@@ -21,7 +28,19 @@ type ChatOptions struct {
 	Renderer          string `yaml:"renderer"`
 	NonInteractive    bool   `yaml:"non-interactive"`
 	DisableAutoShrink bool   `yaml:"disable-auto-shrink"`
+	KeepTruncated     bool   `yaml:"keep-truncated"`
+	SchemaRetries     int    `yaml:"schema-retries"`
+	ResponseFormat    bool   `yaml:"response-format"`
 	Text              string `yaml:"-"`
+
+	// Quiet returns the reply without showing it, Corrections are sent
+	// after the messages of the session but not kept, like the invalid
+	// reply and the errors of the schema
+	Quiet       bool       `yaml:"-"`
+	Corrections []*Message `yaml:"-"`
+
+	// the reply is validated against the schema if set
+	Schema *jsonschema.Schema `yaml:"-"`
 }
 
 type ChatCommand struct {
@@ -29,11 +48,50 @@ type ChatCommand struct {
 	ap        *AwesomePrompts
 	sess      *Session
 	isVerbose bool
+	timeout   time.Duration
+
+	// the usage accounting
+	ledger       *Ledger
+	prices       map[string]Price
+	budget       float64
+	budgetAction string
+
+	// emitter writes the results in json or jsonl without the tui,
+	// it is nil for the text output
+	emitter *Emitter
+
+	// failover tells the endpoint replied, it is nil without endpoints
+	failover *FailoverChat
+
+	// sink receives the reply as it arrives in the full-screen mode, which
+	// shows the reply itself, and cancels the requests by ctx
+	sink func(text string)
+	ctx  context.Context
+
+	// display are the options of the tui programs, like the input of the
+	// watch which never reads stdin
+	display []tea.ProgramOption
 }
 
-func NewChatCommand(sess *Session, ap *AwesomePrompts, httpCli *http.Client, opts *ChatCommandOptions) *ChatCommand {
-	c := NewChatGPTClient(httpCli, opts.BaseURL, opts.APIKey, &opts.ChatGPTOptions)
-	return &ChatCommand{c: c, sess: sess, ap: ap, isVerbose: opts.Verbose}
+func NewChatCommand(sess *Session, ap *AwesomePrompts, c chat.Chat[*Question, *Answer, *AnswerChunk],
+	opts *ChatCommandOptions) *ChatCommand {
+	failover, _ := c.(*FailoverChat)
+	if opts.Cache {
+		cache := NewCache(path.Join(opts.Dir, "cache"), opts.CacheTTL, opts.CacheSize<<20)
+		c = NewCachedChat(c, cache, cacheNamespace(c, opts.BaseURL))
+	}
+	return &ChatCommand{c: c, sess: sess, ap: ap, isVerbose: opts.Verbose, timeout: opts.Timeout,
+		ledger: NewLedger(opts.Dir), prices: opts.Prices,
+		budget: opts.Budget, budgetAction: opts.BudgetAction, failover: failover}
+}
+
+// cacheNamespace is the endpoints and their model mappings, so the replies
+// are not shared by the endpoints with the same base url
+func cacheNamespace(c chat.Chat[*Question, *Answer, *AnswerChunk], baseURL string) string {
+	if ns, ok := c.(interface{ namespace() string }); ok {
+		return ns.namespace()
+	}
+	return baseURL
 }
 
 func (c *ChatCommand) Talk(opts *ChatOptions) (string, error) {
@@ -50,27 +108,63 @@ func (c *ChatCommand) Talk(opts *ChatOptions) (string, error) {
 		return "", nil
 	}
 
+	if opts.Schema != nil {
+		return c.talkWithSchema(opts)
+	}
+	return c.talk(opts)
+}
+
+// talk sends the messages of the session and appends the reply
+func (c *ChatCommand) talk(opts *ChatOptions) (string, error) {
+	if err := c.checkBudget(); err != nil {
+		return "", err
+	}
+
+	// the request is canceled when timed out or interrupted by Ctrl+C,
+	// which closes the connection and stops reading the stream
+	base := context.Background()
+	if c.ctx != nil {
+		base = c.ctx
+	}
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if c.timeout > 0 {
+		ctx, cancel = context.WithTimeout(base, c.timeout)
+	} else {
+		ctx, cancel = context.WithCancel(base)
+	}
+	defer cancel()
+	if c.emitter != nil {
+		// there is no tui to catch Ctrl+C in the headless mode
+		var stop context.CancelFunc
+		ctx, stop = signal.NotifyContext(ctx, os.Interrupt)
+		defer stop()
+	}
+
 	if opts.Stream {
-		return c.stream(context.Background(), opts)
+		return c.stream(ctx, opts)
 	} else {
-		return c.ask(context.Background(), opts)
+		return c.ask(ctx, opts)
 	}
 }
 func (c *ChatCommand) verbose(text string) {
 	if !c.isVerbose {
 		return
 	}
-	c.sess.out.Println(text)
+	c.sess.out.Println(redact(text))
 }
 func (c *ChatCommand) ask(ctx context.Context, opts *ChatOptions) (string, error) {
-	q := &Question{
-		ChatGPTOptions: opts.ChatGPTOptions,
-		Messages:       c.sess.Messages(),
-	}
-	ans, err := tui.Display[tui.Model[*Answer], *Answer](ctx,
-		tui.NewSpinnerModel("thinking...", func() (*Answer, error) {
-			return c.c.Ask(ctx, q)
-		}))
+	q := c.question(opts)
+	var ans *Answer
+	var err error
+	if c.emitter != nil || c.sink != nil {
+		ans, err = c.c.Ask(ctx, q)
+	} else {
+		ans, err = tui.Display[tui.Model[*Answer], *Answer](ctx,
+			tui.NewSpinnerModel("thinking...", func() (*Answer, error) {
+				return c.c.Ask(ctx, q)
+			}), c.display...)
+	}
 	if err != nil {
 		return "", err
 	}
@@ -84,18 +178,75 @@ func (c *ChatCommand) ask(ctx context.Context, opts *ChatOptions) (string, error
 		return "", fmt.Errorf(ans.Error.Message)
 	}
 
-	out := bytes.NewBuffer(nil)
+	var candidates []*Message
+	var reasons []string // the finish reasons of candidates
 	for _, choice := range ans.Choices {
-		content := strings.TrimSpace(choice.Message.Content)
-		out.WriteByte('\n')
-		out.WriteString(content)
-		out.WriteByte('\n')
+		if choice.Message == nil {
+			continue
+		}
+		choice.Message.Content = strings.TrimSpace(choice.Message.Content)
+		candidates = append(candidates, choice.Message)
+		reasons = append(reasons, choice.FinishReason)
+	}
+	if len(candidates) == 0 {
+		return "", nil
+	}
 
-		c.sess.Append(choice.Message)
+	model := ans.Model
+	if model == "" {
+		model = opts.Model
+	}
+	var usage *UsageEntry
+	if ans.Cached {
+		usage = c.cachedUsage(model, ans.Usage)
+	} else if ans.Usage.TotalTokens == 0 {
+		usage = c.recordUsage(model, estimateUsage(q.Messages, candidates[0].Content), true)
+	} else {
+		usage = c.recordUsage(model, ans.Usage, false)
 	}
 
+	idx, err := c.choose(ctx, candidates, opts)
+	if err != nil {
+		return "", err
+	}
+	c.appendChoice(candidates, idx, usage)
+
+	if c.emitter != nil {
+		// only the chosen reply is streamed, tagged by its index
+		c.emitter.Delta(idx, candidates[idx].Content)
+		c.emitter.Reply(candidates[idx].Content, reasons[idx], usage, false)
+		return candidates[idx].Content, nil
+	}
+	// the reply is shown by the caller, like the json once validated
+	if opts.Quiet {
+		return candidates[idx].Content, nil
+	}
+
+	text, err := c.show(ctx, candidates[idx].Content, opts.Renderer)
+	if err != nil {
+		return "", err
+	}
+	if !opts.NonInteractive && c.sink == nil {
+		c.printUsage(usage)
+	}
+	return text, nil
+}
+
+// show renders the content, which is sent to the sink in the full-screen
+// mode, or printed if the tui is not renderable
+func (c *ChatCommand) show(ctx context.Context, content, renderer string) (string, error) {
+	if c.sink != nil {
+		c.sink(content)
+		return content, nil
+	}
+
+	out := bytes.NewBuffer(nil)
+	out.WriteByte('\n')
+	out.WriteString(content)
+	out.WriteByte('\n')
+
 	c.verbose("render the content")
-	text, err := tui.Display[tui.Model[string], string](ctx, tui.NewContentModel(out.String(), opts.Renderer))
+	text, err := tui.Display[tui.Model[string], string](ctx, tui.NewContentModel(out.String(), renderer), c.display...)
 	if err != nil {
 		return "", err
 	}
@@ -103,47 +254,108 @@ func (c *ChatCommand) ask(ctx context.Context, opts *ChatOptions) (string, error
 	// Print to output if the tui is not renderable
 	// in case the the stdout is not terminal
 	if !tui.IsRenderable() {
-		c.sess.out.Print(text)
-	}
-
-	if !opts.NonInteractive {
-		c.sess.out.Printf("Cost : prompt(%d) completion(%d) total(%d)",
-			ans.Usage.PromptTokens, ans.Usage.CompletionTokens, ans.Usage.TotalTokens)
+		if renderer == "markdown" {
+			c.sess.out.Print(text)
+		} else {
+			c.printRendered(content, renderer)
+		}
 	}
-
 	return text, nil
 }
 
 func (c *ChatCommand) stream(ctx context.Context, opts *ChatOptions) (string, error) {
 retry:
-	q := &Question{
-		ChatGPTOptions: opts.ChatGPTOptions,
-		Messages:       c.sess.Messages(),
+	// deltas of choices other than the first one, demultiplexed by index
+	others := make(map[int]*bytes.Buffer)
+	q := c.question(opts)
+	if opts.IncludeUsage {
+		q.StreamOptions = &StreamOptions{IncludeUsage: true}
 	}
+	// the usage is replied in the last chunk if include_usage is set
+	var usage *Usage
+	model := opts.Model
+	cached := false
+	reasons := make(map[int]string) // the finish reasons by index
 	// issue a request to the api
-	s, err := tui.Display[tui.Model[chan *AnswerChunk], chan *AnswerChunk](ctx,
-		tui.NewSpinnerModel("", func() (chan *AnswerChunk, error) {
-			return c.c.Stream(ctx, q)
-		}))
-	if err != nil {
-		return "", err
+	var s chan *AnswerChunk
+	var err error
+	if c.emitter != nil || c.sink != nil {
+		s, err = c.c.Stream(ctx, q)
+	} else {
+		s, err = tui.Display[tui.Model[chan *AnswerChunk], chan *AnswerChunk](ctx,
+			tui.NewSpinnerModel("", func() (chan *AnswerChunk, error) {
+				return c.c.Stream(ctx, q)
+			}), c.display...)
 	}
 	// ctrl+c interrupted
-	if s == nil {
+	if err == nil && s == nil {
 		return "", nil
 	}
 
 	// handle the stream and print the delta text, the whole
 	// content is returned when finished
-	content, err := tui.Display[tui.Model[string], string](ctx, tui.NewStreamModel(s, opts.Renderer, func(event *AnswerChunk) (string, error) {
+	var content string
+	onEvent := func(event *AnswerChunk) (string, error) {
 		if event.Error.Message != "" {
-			return "", fmt.Errorf("%s: %s", event.Error.Code, event.Error.Message)
+			if event.Error.Type == "guru_inner_error" {
+				return "", errors.New(event.Error.Message)
+			}
+			return "", chat.NewError(0, event.Error.Type, event.Error.Code, event.Error.Message)
+		}
+		if event.Usage != nil {
+			usage = event.Usage
 		}
-		if len(event.Choices) == 0 {
-			return "", nil
+		if event.Model != "" {
+			model = event.Model
 		}
-		return event.Choices[0].Delta.Content, nil
-	}))
+		cached = cached || event.Cached
+		// only the first choice is displayed when streaming
+		var text string
+		for _, choice := range event.Choices {
+			if choice.FinishReason != "" {
+				reasons[choice.Index] = choice.FinishReason
+			}
+			if c.emitter != nil {
+				c.emitter.Delta(choice.Index, choice.Delta.Content)
+			}
+			if choice.Index == 0 {
+				text += choice.Delta.Content
+				continue
+			}
+			buf := others[choice.Index]
+			if buf == nil {
+				buf = bytes.NewBuffer(nil)
+				others[choice.Index] = buf
+			}
+			buf.WriteString(choice.Delta.Content)
+		}
+		if c.sink != nil && text != "" {
+			c.sink(text)
+		}
+		return text, nil
+	}
+	if err == nil && (c.emitter != nil || c.sink != nil) {
+		content, err = drain(ctx, s, onEvent)
+	} else if err == nil {
+		content, err = tui.Display[tui.Model[string], string](ctx, tui.NewStreamModel(s, opts.Renderer, onEvent), c.display...)
+	}
+
+	// keep the partial reply if interrupted or timed out
+	if content != "" && isCanceled(err) {
+		if c.emitter == nil && !tui.IsRenderable() {
+			c.sess.out.Print(content)
+		}
+		// the tokens are consumed even though the reply is truncated
+		entry := c.recordUsage(model, estimateUsage(q.Messages, content), true)
+		if c.emitter != nil {
+			c.emitter.Reply(content, "", entry, true)
+		}
+		if opts.KeepTruncated {
+			c.sess.AppendReply(&Message{Role: Assistant, Content: content}, truncated(), withUsage(entry),
+				withEndpoint(c.endpoint()))
+		}
+		return content, err
+	}
 
 	// The token limit exceeded. auto shrink and retry if enabled
 	if c.IsTokenExceeded(err) {
@@ -171,23 +383,211 @@ retry:
 		return "", err
 	}
 
+	candidates := []*Message{{Role: Assistant, Content: content}}
+	indexes := []int{0} // the choice indexes of candidates
+	for index := range others {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes[1:])
+	for _, index := range indexes[1:] {
+		candidates = append(candidates, &Message{Role: Assistant, Content: others[index].String()})
+	}
+
+	var entry *UsageEntry
+	if cached {
+		var u Usage
+		if usage != nil {
+			u = *usage
+		}
+		entry = c.cachedUsage(model, u)
+	} else if usage != nil {
+		entry = c.recordUsage(model, *usage, false)
+	} else {
+		var replies string
+		for _, m := range candidates {
+			replies += m.Content
+		}
+		entry = c.recordUsage(model, estimateUsage(q.Messages, replies), true)
+	}
+
+	idx, err := c.choose(ctx, candidates, opts)
+	if err != nil {
+		return "", err
+	}
+	content = candidates[idx].Content
+
 	// Print to output if the tui is not renderable
 	// in case the the stdout is not terminal
-	if !tui.IsRenderable() {
-		c.sess.out.Print(content)
+	if c.emitter != nil {
+		c.emitter.Reply(content, reasons[indexes[idx]], entry, false)
+	} else if !tui.IsRenderable() {
+		if opts.Renderer == "markdown" {
+			c.sess.out.Print(content)
+		} else {
+			c.printRendered(content, opts.Renderer)
+		}
+	} else if idx != 0 && c.sink == nil {
+		// the first choice has been streamed, show the chosen one
+		if _, err := tui.Display[tui.Model[string], string](ctx, tui.NewContentModel(content, opts.Renderer), c.display...); err != nil {
+			return "", err
+		}
 	}
 	// append the response
-	c.sess.Append(&Message{Role: Assistant, Content: content})
+	c.appendChoice(candidates, idx, entry)
+
+	// the usage is shown in the status bar of the full-screen mode
+	if !opts.NonInteractive && c.sink == nil {
+		c.printUsage(entry)
+	}
 
 	return content, nil
 }
 
-func (c *ChatCommand) IsTokenExceeded(err error) bool {
-	if err == nil {
-		return false
+// printRendered prints the content rendered without the style of the output,
+// so the renderers like raw and code could be piped to other commands
+func (c *ChatCommand) printRendered(content, renderer string) {
+	text, err := tui.NewRenderer(renderer).Render(content)
+	if err != nil {
+		c.sess.out.Errorln(err)
+		return
+	}
+	if !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	fmt.Fprint(c.sess.out, text)
+}
+
+// drain reads the stream without the tui in the headless mode, the
+// content read is returned even if it fails, and the cancellation is
+// reported as ErrInterrupted like the tui does
+func drain(ctx context.Context, s chan *AnswerChunk, onEvent func(event *AnswerChunk) (string, error)) (string, error) {
+	var content strings.Builder
+	for {
+		select {
+		case <-ctx.Done():
+			return content.String(), interrupted(ctx)
+		case event, ok := <-s:
+			if !ok {
+				return content.String(), nil
+			}
+			text, err := onEvent(event)
+			if err != nil {
+				// report the cancellation rather than the error it causes
+				if ctx.Err() != nil {
+					err = interrupted(ctx)
+				}
+				return content.String(), err
+			}
+			content.WriteString(text)
+		}
+	}
+}
+
+// choose returns the index of the candidate selected by the user, the
+// first one is chosen if there is only one or guru is not interactive
+func (c *ChatCommand) choose(ctx context.Context, candidates []*Message, opts *ChatOptions) (int, error) {
+	if len(candidates) <= 1 || opts.NonInteractive || !tui.IsRenderable() {
+		return 0, nil
+	}
+
+	var choices []string
+	for _, m := range candidates {
+		choices = append(choices, m.Content)
 	}
-	if strings.Contains(err.Error(), "context_length_exceeded") {
-		return true
+	return tui.Display[tui.Model[int], int](ctx, tui.NewChoicesModel(choices, opts.Renderer))
+}
+
+// appendChoice appends the chosen candidate to the session,
+// the others are kept in the history as alternates
+func (c *ChatCommand) appendChoice(candidates []*Message, idx int, usage *UsageEntry) {
+	var alternates []*Message
+	for i, m := range candidates {
+		if i != idx {
+			alternates = append(alternates, m)
+		}
+	}
+	c.sess.AppendReply(candidates[idx], withAlternates(alternates), withUsage(usage),
+		withEndpoint(c.endpoint()))
+}
+
+// endpoint returns the endpoint replied the last question
+func (c *ChatCommand) endpoint() string {
+	if c.failover == nil {
+		return ""
+	}
+	name := c.failover.Take()
+	if name != "" {
+		c.verbose("endpoint: " + name)
+	}
+	return name
+}
+
+// recordUsage records the usage to the ledger
+func (c *ChatCommand) recordUsage(model string, u Usage, estimated bool) *UsageEntry {
+	e := NewUsageEntry(c.prices, c.sess.sid, model, u, estimated)
+	if err := c.ledger.Append(e); err != nil {
+		c.sess.out.Errorln(err)
+	}
+	return e
+}
+
+// cachedUsage returns the usage of a cached reply, which costs nothing
+// and is not recorded to the ledger
+func (c *ChatCommand) cachedUsage(model string, u Usage) *UsageEntry {
+	e := NewUsageEntry(c.prices, c.sess.sid, model, u, false)
+	e.Cached = true
+	e.Cost = 0
+	return e
+}
+
+func (c *ChatCommand) printUsage(e *UsageEntry) {
+	if e.Cached {
+		c.sess.out.Printf("Cost : cached")
+		return
 	}
-	return false
+	mark := ""
+	if e.Estimated {
+		mark = "~" // counted locally
+	}
+	c.sess.out.Printf("Cost : prompt(%s%d) completion(%s%d) total(%s%d) $%.4f",
+		mark, e.PromptTokens, mark, e.CompletionTokens, mark, e.TotalTokens, e.Cost)
+}
+
+// checkBudget warns or blocks the request if the monthly budget is exceeded
+func (c *ChatCommand) checkBudget() error {
+	if c.budget <= 0 {
+		return nil
+	}
+	cost, err := c.ledger.MonthCost()
+	if err != nil {
+		return err
+	}
+	if cost < c.budget {
+		return nil
+	}
+	if c.budgetAction == "block" {
+		return fmt.Errorf("the monthly budget $%.2f is exceeded: $%.4f spent", c.budget, cost)
+	}
+	c.sess.out.Errorln(fmt.Sprintf("warning: the monthly budget $%.2f is exceeded: $%.4f spent", c.budget, cost))
+	return nil
+}
+
+// isCanceled reports if the request is interrupted by the user or timed out
+// interrupted returns the error of the done ctx, canceled is taken as
+// interrupted by the user
+func interrupted(ctx context.Context) error {
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return tui.ErrInterrupted
+	}
+	return ctx.Err()
+}
+
+func isCanceled(err error) bool {
+	return errors.Is(err, tui.ErrInterrupted) ||
+		errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+func (c *ChatCommand) IsTokenExceeded(err error) bool {
+	var e *chat.ContextLengthError
+	return errors.As(err, &e)
 }
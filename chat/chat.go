@@ -1,13 +1,15 @@
 package chat
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
 	"io"
+	"mime"
 	"net/http"
 	"strings"
+
+	"github.com/shafreeck/guru/sse"
 )
 
 type Newer interface {
@@ -36,11 +38,6 @@ type AnswerChunks[A Answer] interface {
 	Combine() A
 }
 
-type Error struct {
-	Type    string
-	Message string
-}
-
 type Chat[Q Question, A Answer, AC AnswerChunk] interface {
 	Ask(ctx context.Context, q Q) (A, error)
 	Stream(ctx context.Context, q Q) (chan AC, error)
@@ -55,27 +52,72 @@ type Client[Q Question, A Answer, AC AnswerChunk] struct {
 	cli    *http.Client
 	url    string
 	apikey string
+	opts   options
 }
 
-func New[Q Question, A Answer, AC AnswerChunk](cli *http.Client, url string, apikey string) *Client[Q, A, AC] {
-	return &Client[Q, A, AC]{cli: cli, url: url, apikey: apikey}
+func New[Q Question, A Answer, AC AnswerChunk](cli *http.Client, url string, apikey string, opts ...Option) *Client[Q, A, AC] {
+	c := &Client[Q, A, AC]{cli: cli, url: url, apikey: apikey, opts: defaultOptions}
+	for _, opt := range opts {
+		opt(&c.opts)
+	}
+	return c
 }
-func (c *Client[Q, A, _]) Ask(ctx context.Context, q Q) (A, error) {
-	ans := newObj[A]()
 
-	data, err := json.Marshal(q)
+// post issues the request once, a response with failed status is turned into an error
+func (c *Client[_, _, _]) post(ctx context.Context, data []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(data))
 	if err != nil {
-		return ans, err
+		return nil, err
+	}
+	for k, vs := range c.opts.headers {
+		req.Header[k] = append(req.Header[k], vs...)
+	}
+	if c.opts.authHeader != "" {
+		req.Header.Set(c.opts.authHeader, c.opts.authPrefix+c.apikey)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.cli.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 == 2 {
+		return resp, nil
 	}
 
-	req, err := http.NewRequest(http.MethodPost, c.url, bytes.NewBuffer(data))
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return nil, errorFromResponse(resp, body)
+}
+
+// do posts the request and retries with backoff if it fails temporarily
+func (c *Client[_, _, _]) do(ctx context.Context, data []byte) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := c.post(ctx, data)
+		if err == nil {
+			return resp, nil
+		}
+		if attempt >= c.opts.maxRetries || !retryable(ctx, err) {
+			return nil, err
+		}
+		if err := sleep(ctx, c.opts.backoff(attempt, err)); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (c *Client[Q, A, _]) Ask(ctx context.Context, q Q) (A, error) {
+	ans := newObj[A]()
+
+	data, err := json.Marshal(q)
 	if err != nil {
 		return ans, err
 	}
-	req.Header.Add("Authorization", "Bearer "+c.apikey)
-	req.Header.Add("Content-Type", "application/json")
 
-	resp, err := c.cli.Do(req.WithContext(ctx))
+	resp, err := c.do(ctx, data)
 	if err != nil {
 		return ans, err
 	}
@@ -98,66 +140,104 @@ func (c *Client[Q, _, AC]) Stream(ctx context.Context, q Q) (chan AC, error) {
 		return nil, err
 	}
 
-	req, err := http.NewRequest(http.MethodPost, c.url, bytes.NewBuffer(data))
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Add("Authorization", "Bearer "+c.apikey)
-	req.Header.Add("Content-Type", "application/json")
-
-	resp, err := c.cli.Do(req.WithContext(ctx))
+	resp, err := c.do(ctx, data)
 	if err != nil {
 		return nil, err
 	}
 
 	go func() {
-		defer resp.Body.Close()
 		defer close(ch)
 
-		scanner := bufio.NewScanner(resp.Body)
-		errbuf := bytes.NewBuffer(nil)
-		for scanner.Scan() {
-			ansc := newObj[AC]()
-			line := strings.TrimSpace(scanner.Text())
-			if line == "" {
-				continue
-			}
-
-			prefix := "data:"
-			// it would be an error event if not data: prefixed
-			if !strings.HasPrefix(line, prefix) {
-				errbuf.WriteString(line)
-				continue
-			}
-			if line == "data: [DONE]" {
+		for attempt := 0; ; attempt++ {
+			dec := sse.NewDecoder(resp.Body)
+			n, err := c.readEvents(ctx, resp, dec, ch)
+			resp.Body.Close()
+			if err == nil || ctx.Err() != nil {
 				return
 			}
-			text := line[len(prefix):]
 
-			if err := json.Unmarshal([]byte(text), ansc); err != nil {
-				ansc.SetError(err)
+			// resume only if the connection dropped before the first event,
+			// or the reply would be duplicated. The request is posted again
+			// without Last-Event-ID, which makes no sense for a new POST
+			if n == 0 && attempt < c.opts.maxRetries {
+				wait := dec.Retry()
+				if wait == 0 {
+					wait = c.opts.backoff(attempt, err)
+				}
+				if err = sleep(ctx, wait); err != nil {
+					return
+				}
+				if resp, err = c.do(ctx, data); err == nil {
+					continue
+				}
 			}
 
+			ansc := newObj[AC]()
+			ansc.SetError(err)
 			select {
 			case <-ctx.Done():
-				return
 			case ch <- ansc:
 			}
-		}
-
-		if errbuf.Len() == 0 {
 			return
 		}
-		// send the error message
+	}()
+	return ch, nil
+}
+
+// readEvents decodes the events from the response and sends them to ch, it
+// returns the number of events sent and the error when reading
+func (c *Client[_, _, AC]) readEvents(ctx context.Context, resp *http.Response, dec *sse.Decoder, ch chan AC) (int, error) {
+	send := func(data []byte, err error) error {
 		ansc := newObj[AC]()
-		if err := json.Unmarshal(errbuf.Bytes(), ansc); err != nil {
+		if err != nil {
+			ansc.SetError(err)
+		} else if err := json.Unmarshal(data, ansc); err != nil {
 			ansc.SetError(err)
 		}
 		select {
 		case <-ctx.Done():
-			return
+			return ctx.Err()
 		case ch <- ansc:
+			return nil
 		}
-	}()
-	return ch, nil
+	}
+
+	// the server replies a json instead of an event stream, which is
+	// an error in most cases
+	if mt, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mt == "application/json" {
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return 0, err
+		}
+		if err := send(data, nil); err != nil {
+			return 0, err
+		}
+		return 1, nil
+	}
+
+	n := 0
+	for {
+		ev, err := dec.Decode()
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		if strings.TrimSpace(ev.Data) == "[DONE]" {
+			return n, nil
+		}
+		// the error event is reported, and the events not parsed as the
+		// chunks like ping are skipped
+		var everr error
+		if ev.Type == "error" {
+			everr = errorFromEvent([]byte(ev.Data))
+		} else if !c.opts.eventTypes[ev.Type] {
+			continue
+		}
+		if err := send([]byte(ev.Data), everr); err != nil {
+			return n, err
+		}
+		n++
+	}
 }
@@ -0,0 +1,180 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// the types of the questions and answers like the ones of the openai api,
+// the types of the main package could not be imported here
+
+type message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type question struct {
+	Model    string     `json:"model"`
+	Messages []*message `json:"messages"`
+	Stream   bool       `json:"stream,omitempty"`
+}
+
+func (q *question) New() any                 { return &question{} }
+func (q *question) Marshal() ([]byte, error) { return json.Marshal(q) }
+
+type answer struct {
+	Choices []struct {
+		Message message `json:"message"`
+	} `json:"choices"`
+}
+
+func (a *answer) New() any                 { return &answer{} }
+func (a *answer) Unmarshal(d []byte) error { return json.Unmarshal(d, a) }
+
+func (a *answer) content() string {
+	if len(a.Choices) == 0 {
+		return ""
+	}
+	return a.Choices[0].Message.Content
+}
+
+type chunk struct {
+	Choices []struct {
+		Delta message `json:"delta"`
+	} `json:"choices"`
+	Text string `json:"text"`
+	err  error
+}
+
+func (c *chunk) New() any                 { return &chunk{} }
+func (c *chunk) Unmarshal(d []byte) error { return json.Unmarshal(d, c) }
+func (c *chunk) SetError(err error)       { c.err = err }
+
+func (c *chunk) content() string {
+	if len(c.Choices) == 0 {
+		return c.Text
+	}
+	return c.Choices[0].Delta.Content
+}
+
+func ask(content string) *question {
+	return &question{Model: "mock", Messages: []*message{{Role: "user", Content: content}}}
+}
+
+// collect reads the chunks until the channel is closed
+func collect(t *testing.T, ch chan *chunk) (string, error) {
+	t.Helper()
+	var b strings.Builder
+	for c := range ch {
+		if c.err != nil {
+			return b.String(), c.err
+		}
+		b.WriteString(c.content())
+	}
+	return b.String(), nil
+}
+
+func newTestClient(t *testing.T, h http.HandlerFunc, opts ...Option) *Client[*question, *answer, *chunk] {
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	opts = append([]Option{WithRetry(2, time.Millisecond, 10*time.Millisecond)}, opts...)
+	return New[*question, *answer, *chunk](srv.Client(), srv.URL, "key", opts...)
+}
+
+func TestStreamEventTypes(t *testing.T) {
+	stream := strings.Join([]string{
+		"event: ping\ndata: {}\n\n",
+		"event: content_block_delta\ndata: {\"text\":\"hello\"}\n\n",
+		"data: {\"choices\":[{\"delta\":{\"content\":\" world\"}}]}\n\n",
+		"data: [DONE]\n\n",
+	}, "")
+	h := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, stream)
+	}
+
+	cases := []struct {
+		name  string
+		opts  []Option
+		reply string
+	}{
+		{"message only", nil, " world"},
+		{"named events", []Option{WithEventTypes("message", "content_block_delta")}, "hello world"},
+		{"named events only", []Option{WithEventTypes("content_block_delta")}, "hello"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cli := newTestClient(t, h, c.opts...)
+			ch, err := cli.Stream(context.Background(), ask("hi"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			reply, err := collect(t, ch)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reply != c.reply {
+				t.Errorf("reply %q, want %q", reply, c.reply)
+			}
+		})
+	}
+}
+
+func TestStreamErrorEvent(t *testing.T) {
+	cli := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"a\"}}]}\n\n")
+		fmt.Fprint(w, "event: error\ndata: {\"error\":{\"type\":\"server_error\",\"message\":\"overloaded\"}}\n\n")
+	})
+	ch, err := cli.Stream(context.Background(), ask("hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, err := collect(t, ch)
+	var se *ServerError
+	if !errors.As(err, &se) || se.Message != "overloaded" {
+		t.Fatalf("err %v, want the server error", err)
+	}
+	if reply != "a" {
+		t.Errorf("reply %q, want a", reply)
+	}
+}
+
+func TestStreamResumeWithoutLastEventID(t *testing.T) {
+	attempts := 0
+	cli := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if id := r.Header.Get("Last-Event-ID"); id != "" {
+			t.Errorf("Last-Event-ID %q is sent", id)
+		}
+		if attempts == 1 {
+			// drop the connection before the first event
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, "id: 1\nretry: 1\n")
+			w.(http.Flusher).Flush()
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"ok\"}}]}\n\ndata: [DONE]\n\n")
+	})
+	ch, err := cli.Stream(context.Background(), ask("hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, err := collect(t, ch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply != "ok" || attempts != 2 {
+		t.Errorf("reply %q after %d attempts, want ok after 2", reply, attempts)
+	}
+}
@@ -0,0 +1,147 @@
+package chat
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RateLimitError is returned when the request is rate limited or the quota is exceeded
+type RateLimitError struct {
+	Message    string
+	RetryAfter time.Duration // the duration suggested by the server, 0 if unknown
+	Quota      bool          // the quota is exceeded, which would not succeed by retrying
+}
+
+func (e *RateLimitError) Error() string {
+	if e.Quota {
+		return "insufficient_quota: " + e.Message
+	}
+	return "rate limit exceeded: " + e.Message
+}
+
+// AuthError is returned when the api key is invalid or not permitted
+type AuthError struct {
+	Message string
+}
+
+func (e *AuthError) Error() string {
+	return "authentication failed: " + e.Message
+}
+
+// ContextLengthError is returned when the tokens of messages exceed the limit of the model
+type ContextLengthError struct {
+	Message string
+}
+
+func (e *ContextLengthError) Error() string {
+	return "context_length_exceeded: " + e.Message
+}
+
+// ServerError is returned when the server fails to handle the request
+type ServerError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("server error(%d): %s", e.StatusCode, e.Message)
+}
+
+// Error is the error replied by the api which is not classified
+type Error struct {
+	StatusCode int
+	Type       string
+	Code       string
+	Message    string
+}
+
+func (e *Error) Error() string {
+	if e.Code != "" {
+		return e.Code + ": " + e.Message
+	}
+	if e.Type != "" {
+		return e.Type + ": " + e.Message
+	}
+	return e.Message
+}
+
+// NewError classifies the error by the status code and the type, code
+// replied by the api. The statusCode could be 0 if the error is carried
+// in a stream event.
+func NewError(statusCode int, typ, code, message string) error {
+	switch {
+	case code == "context_length_exceeded":
+		return &ContextLengthError{Message: message}
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden ||
+		code == "invalid_api_key" || typ == "authentication_error":
+		return &AuthError{Message: message}
+	case typ == "insufficient_quota" || code == "insufficient_quota":
+		return &RateLimitError{Message: message, Quota: true}
+	case statusCode == http.StatusTooManyRequests || code == "rate_limit_exceeded" || typ == "requests":
+		return &RateLimitError{Message: message}
+	case statusCode >= 500 || typ == "server_error":
+		return &ServerError{StatusCode: statusCode, Message: message}
+	}
+	return &Error{StatusCode: statusCode, Type: typ, Code: code, Message: message}
+}
+
+// errorFromResponse builds the error from a response with a failed status
+func errorFromResponse(resp *http.Response, body []byte) error {
+	err := parseError(resp.StatusCode, body, resp.Status)
+	if e, ok := err.(*RateLimitError); ok {
+		e.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+	}
+	return err
+}
+
+// errorFromEvent builds the error from the data of an error event
+func errorFromEvent(data []byte) error {
+	return parseError(0, data, "unknown error event")
+}
+
+// parseError parses the error replied like {"error": {"message": "..."}}, the
+// body is taken as the message if it is not the case, or the fallback if
+// the body is empty
+func parseError(statusCode int, body []byte, fallback string) error {
+	v := struct {
+		Error struct {
+			Message string `json:"message"`
+			Type    string `json:"type"`
+			Code    any    `json:"code"` // some apis reply the code as a number
+		} `json:"error"`
+	}{}
+	message := strings.TrimSpace(string(body))
+	var typ, code string
+	if err := json.Unmarshal(body, &v); err == nil && v.Error.Message != "" {
+		message = v.Error.Message
+		typ = v.Error.Type
+		if v.Error.Code != nil {
+			code = fmt.Sprint(v.Error.Code)
+		}
+	}
+	if message == "" {
+		message = fallback
+	}
+	return NewError(statusCode, typ, code, message)
+}
+
+// parseRetryAfter parses the Retry-After header which could be
+// seconds or a http date
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
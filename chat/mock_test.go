@@ -0,0 +1,154 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/shafreeck/guru/mock"
+)
+
+// newMockClient creates a client of the mock server, the requests to the
+// server are counted by attempts
+func newMockClient(t *testing.T, attempts *int, opts ...mock.Option) *Client[*question, *answer, *chunk] {
+	m := mock.New(opts...)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*attempts++
+		m.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return New[*question, *answer, *chunk](srv.Client(), srv.URL+"/v1/chat/completions", "key",
+		WithRetry(2, time.Millisecond, 10*time.Millisecond))
+}
+
+func TestMockAsk(t *testing.T) {
+	script := &mock.Script{Rules: []*mock.Rule{{Match: "^hello$", Reply: "Hello! How can I help you?"}}}
+	cases := []struct {
+		name  string
+		opts  []mock.Option
+		text  string
+		reply string
+	}{
+		{"echo", nil, "what is guru?", "what is guru?"},
+		{"script", []mock.Option{mock.WithScript(script)}, "hello", "Hello! How can I help you?"},
+		{"script echoes unmatched", []mock.Option{mock.WithScript(script)}, "hello guru", "hello guru"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var attempts int
+			cli := newMockClient(t, &attempts, c.opts...)
+			ans, err := cli.Ask(context.Background(), ask(c.text))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ans.content() != c.reply {
+				t.Errorf("reply %q, want %q", ans.content(), c.reply)
+			}
+
+			q := ask(c.text)
+			q.Stream = true
+			ch, err := cli.Stream(context.Background(), q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reply, err := collect(t, ch)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reply != c.reply {
+				t.Errorf("streamed %q, want %q", reply, c.reply)
+			}
+		})
+	}
+}
+
+func TestMockStreamChunks(t *testing.T) {
+	var attempts int
+	cli := newMockClient(t, &attempts, mock.WithChunkSize(1))
+	q := ask("你好, guru")
+	q.Stream = true
+	ch, err := cli.Stream(context.Background(), q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var chunks []string
+	for c := range ch {
+		if c.err != nil {
+			t.Fatal(c.err)
+		}
+		if s := c.content(); s != "" {
+			chunks = append(chunks, s)
+		}
+	}
+	// a chunk is a rune, which is not split in the middle
+	if len(chunks) != 8 || chunks[0] != "你" || chunks[1] != "好" {
+		t.Errorf("chunks %q, want the runes", chunks)
+	}
+}
+
+func TestMockErrors(t *testing.T) {
+	cases := []struct {
+		name     string
+		rules    []mock.Rule // the rules of the script, which are counted by each client
+		text     string
+		attempts int
+		err      func(err error) bool
+	}{
+		{"context length", nil, mock.ErrorPrefix + "context_length_exceeded", 1, func(err error) bool {
+			var e *ContextLengthError
+			return errors.As(err, &e)
+		}},
+		{"invalid api key", nil, mock.ErrorPrefix + "401", 1, func(err error) bool {
+			var e *AuthError
+			return errors.As(err, &e)
+		}},
+		{"insufficient quota", nil, mock.ErrorPrefix + "insufficient_quota", 1, func(err error) bool {
+			var e *RateLimitError
+			return errors.As(err, &e) && e.Quota
+		}},
+		{"rate limited", nil, mock.ErrorPrefix + "429", 3, func(err error) bool {
+			var e *RateLimitError
+			return errors.As(err, &e) && !e.Quota && e.RetryAfter == time.Second
+		}},
+		{"server error", nil, mock.ErrorPrefix + "503", 3, func(err error) bool {
+			var e *ServerError
+			return errors.As(err, &e) && e.StatusCode == http.StatusServiceUnavailable
+		}},
+		{"retried until succeeded", []mock.Rule{{Error: "rate_limit_exceeded", Times: 2}}, "hi", 3, func(err error) bool {
+			return err == nil
+		}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			for _, stream := range []bool{false, true} {
+				script := &mock.Script{}
+				for _, r := range c.rules {
+					r := r
+					script.Rules = append(script.Rules, &r)
+				}
+				var attempts int
+				cli := newMockClient(t, &attempts, mock.WithScript(script))
+				q := ask(c.text)
+				q.Stream = stream
+				var err error
+				if stream {
+					var ch chan *chunk
+					if ch, err = cli.Stream(context.Background(), q); err == nil {
+						_, err = collect(t, ch)
+					}
+				} else {
+					_, err = cli.Ask(context.Background(), q)
+				}
+				if !c.err(err) {
+					t.Errorf("stream %v: unexpected err %v", stream, err)
+				}
+				if attempts != c.attempts {
+					t.Errorf("stream %v: %d attempts, want %d", stream, attempts, c.attempts)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/shafreeck/guru/sse"
+)
+
+// Option configures the client
+type Option func(o *options)
+
+type options struct {
+	maxRetries int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+
+	authHeader string
+	authPrefix string
+	headers    http.Header
+
+	eventTypes map[string]bool // the types of the events parsed as the chunks
+}
+
+var defaultOptions = options{
+	maxRetries: 3,
+	minBackoff: time.Second,
+	maxBackoff: 30 * time.Second,
+	authHeader: "Authorization",
+	authPrefix: "Bearer ",
+	eventTypes: map[string]bool{sse.DefaultEventType: true},
+}
+
+// WithAuthHeader sends the api key by the header with the prefix, it is
+// "Authorization: Bearer <apikey>" by default, and "api-key: <apikey>" for Azure,
+// the api key is not sent if the header is empty
+func WithAuthHeader(header, prefix string) Option {
+	return func(o *options) {
+		o.authHeader = header
+		o.authPrefix = prefix
+	}
+}
+
+// WithHeader adds the header to the requests, like the headers required by
+// a gateway
+func WithHeader(key, value string) Option {
+	return func(o *options) {
+		if o.headers == nil {
+			o.headers = make(http.Header)
+		}
+		o.headers.Add(key, value)
+	}
+}
+
+// WithEventTypes sets the types of the stream events parsed as the chunks,
+// like content_block_delta, only the message events are parsed by default.
+// The error events are always reported and the others are skipped
+func WithEventTypes(types ...string) Option {
+	return func(o *options) {
+		o.eventTypes = make(map[string]bool)
+		for _, t := range types {
+			o.eventTypes[t] = true
+		}
+	}
+}
+
+// WithRetry sets the max retries and the range of the exponential backoff,
+// set maxRetries to 0 to disable retrying
+func WithRetry(maxRetries int, minBackoff, maxBackoff time.Duration) Option {
+	return func(o *options) {
+		o.maxRetries = maxRetries
+		if minBackoff > 0 {
+			o.minBackoff = minBackoff
+		}
+		if maxBackoff > 0 {
+			o.maxBackoff = maxBackoff
+		}
+		if o.maxBackoff < o.minBackoff {
+			o.maxBackoff = o.minBackoff
+		}
+	}
+}
+
+// retryable reports if the request should be retried for err, it is never
+// retried once ctx is done, like the deadline of the caller is exceeded,
+// which fails the request with a timeout net.Error
+func retryable(ctx context.Context, err error) bool {
+	var rle *RateLimitError
+	var se *ServerError
+	var ne net.Error
+	switch {
+	case ctx.Err() != nil, errors.Is(err, context.Canceled):
+		return false
+	case errors.As(err, &rle):
+		return !rle.Quota
+	case errors.As(err, &se):
+		return true
+	case errors.As(err, &ne):
+		return true
+	}
+	return false
+}
+
+// backoff returns the duration to wait before the attempt(starts from 0),
+// it grows exponentially with jitter and honors the Retry-After if any, which
+// is limited by the max backoff too
+func (o *options) backoff(attempt int, err error) time.Duration {
+	var rle *RateLimitError
+	if errors.As(err, &rle) && rle.RetryAfter > 0 {
+		if rle.RetryAfter > o.maxBackoff {
+			return o.maxBackoff
+		}
+		return rle.RetryAfter
+	}
+
+	d := o.minBackoff << attempt
+	if d <= 0 || d > o.maxBackoff { // overflowed or exceeded
+		d = o.maxBackoff
+	}
+	// equal jitter in [d/2, d]
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// sleep waits for d or returns early if ctx is done
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
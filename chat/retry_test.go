@@ -0,0 +1,128 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRetryable(t *testing.T) {
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{&RateLimitError{Message: "slow down"}, true},
+		{&RateLimitError{Message: "no money", Quota: true}, false},
+		{NewError(429, "insufficient_quota", "insufficient_quota", "no money"), false},
+		{NewError(429, "requests", "rate_limit_exceeded", "slow down"), true},
+		{&ServerError{StatusCode: 503}, true},
+		{&AuthError{}, false},
+		{&ContextLengthError{}, false},
+		{&Error{StatusCode: 400}, false},
+		{&net.OpError{Op: "dial", Err: errors.New("refused")}, true},
+		{context.Canceled, false},
+		{fmt.Errorf("wrapped: %w", &ServerError{StatusCode: 500}), true},
+	}
+	for _, c := range cases {
+		if got := retryable(context.Background(), c.err); got != c.want {
+			t.Errorf("retryable(%v) = %v, want %v", c.err, got, c.want)
+		}
+	}
+
+	// the deadline of the caller fails the request with a timeout, which
+	// is not retried like the one of the network
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	timeout := &net.OpError{Op: "read", Err: context.DeadlineExceeded}
+	if retryable(ctx, timeout) {
+		t.Error("the request is retried after the deadline of the caller")
+	}
+	if !retryable(context.Background(), timeout) {
+		t.Error("the timeout of the network is not retried")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	o := defaultOptions
+	WithRetry(3, 100*time.Millisecond, time.Second)(&o)
+
+	for attempt := 0; attempt < 10; attempt++ {
+		d := o.backoff(attempt, &ServerError{})
+		max := 100 * time.Millisecond << attempt
+		if max > time.Second {
+			max = time.Second
+		}
+		if d < max/2 || d > max {
+			t.Errorf("attempt %d: backoff %v not in [%v, %v]", attempt, d, max/2, max)
+		}
+	}
+
+	// the Retry-After is honored, but not longer than the max backoff
+	if d := o.backoff(0, &RateLimitError{RetryAfter: 300 * time.Millisecond}); d != 300*time.Millisecond {
+		t.Errorf("backoff %v, want the Retry-After 300ms", d)
+	}
+	if d := o.backoff(0, &RateLimitError{RetryAfter: time.Hour}); d != time.Second {
+		t.Errorf("backoff %v, want the max backoff 1s", d)
+	}
+}
+
+func TestAskRetry(t *testing.T) {
+	cases := []struct {
+		name     string
+		status   int
+		body     string
+		fails    int // the times the server fails before the success
+		attempts int
+		err      func(error) bool // nil if it succeeds
+	}{
+		{"server error", 503, `{"error":{"type":"server_error","message":"overloaded"}}`, 2, 3, nil},
+		{"rate limit", 429, `{"error":{"type":"requests","code":"rate_limit_exceeded","message":"slow down"}}`, 2, 3, nil},
+		{"quota", 429, `{"error":{"type":"insufficient_quota","code":"insufficient_quota","message":"no money"}}`, 3, 1,
+			func(err error) bool {
+				var e *RateLimitError
+				return errors.As(err, &e) && e.Quota
+			}},
+		{"auth", 401, `{"error":{"code":"invalid_api_key","message":"bad key"}}`, 3, 1,
+			func(err error) bool {
+				var e *AuthError
+				return errors.As(err, &e)
+			}},
+		{"retries exhausted", 500, `oops`, 3, 3,
+			func(err error) bool {
+				var e *ServerError
+				return errors.As(err, &e) && e.Message == "oops"
+			}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			attempts := 0
+			cli := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				attempts++
+				if attempts <= c.fails {
+					w.Header().Set("Retry-After", "0")
+					w.WriteHeader(c.status)
+					fmt.Fprint(w, c.body)
+					return
+				}
+				fmt.Fprint(w, `{"choices":[{"message":{"role":"assistant","content":"ok"}}]}`)
+			})
+			ans, err := cli.Ask(context.Background(), ask("hi"))
+			if c.err == nil && err != nil {
+				t.Fatal(err)
+			}
+			if c.err == nil && ans.content() != "ok" {
+				t.Errorf("reply %q, want ok", ans.content())
+			}
+			if c.err != nil && !c.err(err) {
+				t.Errorf("unexpected err %v", err)
+			}
+			if attempts != c.attempts {
+				t.Errorf("%d attempts, want %d", attempts, c.attempts)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shafreeck/guru/chat"
+	"github.com/shafreeck/guru/mock"
+	"github.com/shafreeck/guru/tui"
+)
+
+func TestTalkInterrupted(t *testing.T) {
+	srv := httptest.NewServer(mock.New(mock.WithDelay(20*time.Millisecond), mock.WithChunkSize(2)))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	sess := NewSession(dir, WithCommandOutput(New(WithStdout(io.Discard))))
+	if err := sess.Open(""); err != nil {
+		t.Fatal(err)
+	}
+	defer sess.Close()
+
+	opts := &ChatOptions{KeepTruncated: true, NonInteractive: true}
+	opts.Model, opts.Stream = "gpt-test", true
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var received strings.Builder
+	c := &ChatCommand{sess: sess, ledger: NewLedger(dir), ctx: ctx,
+		c: NewChatGPTClient(http.DefaultClient, srv.URL+"/v1", "sk-test-key", &opts.ChatGPTOptions, chat.WithRetry(0, 0, 0)),
+		sink: func(text string) {
+			// interrupted after the first chunks, like Ctrl+C in the full-screen app
+			if received.WriteString(text); received.Len() >= 4 {
+				cancel()
+			}
+		}}
+
+	opts.Text = "tell me a long story about the sea"
+	content, err := c.Talk(opts)
+	if !errors.Is(err, tui.ErrInterrupted) {
+		t.Fatalf("talk returns %v, want ErrInterrupted", err)
+	}
+	if content == "" || len(content) >= len(opts.Text) || !strings.HasPrefix(opts.Text, content) {
+		t.Fatalf("the partial reply is %q", content)
+	}
+
+	messages := sess.Messages()
+	if len(messages) != 2 {
+		t.Fatalf("%d messages in the session, want the question and the partial reply", len(messages))
+	}
+	if last := messages[1]; last.Role != Assistant || last.Content != content {
+		t.Errorf("the last message is %+v, want the partial reply %q", last, content)
+	}
+}
@@ -21,9 +21,26 @@ type Message struct {
 	Content string   `json:"content"`
 }
 
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+// ResponseFormat asks the model to reply json matching the schema
+type ResponseFormat struct {
+	Type       string            `json:"type"`
+	JSONSchema *JSONSchemaFormat `json:"json_schema,omitempty"`
+}
+
+type JSONSchemaFormat struct {
+	Name   string          `json:"name"`
+	Schema json.RawMessage `json:"schema"`
+}
+
 type Question struct {
 	ChatGPTOptions
-	Messages []*Message `json:"messages"`
+	StreamOptions  *StreamOptions  `json:"stream_options,omitempty"`
+	ResponseFormat *ResponseFormat `json:"response_format,omitempty"`
+	Messages       []*Message      `json:"messages"`
 }
 
 func (q *Question) New() any {
@@ -38,11 +55,7 @@ type Answer struct {
 	Object  string `json:"object"`
 	Created int64  `json:"created"`
 	Model   string `json:"model"`
-	Usage   struct {
-		PromptTokens     int `json:"prompt_tokens"`
-		CompletionTokens int `json:"completion_tokens"`
-		TotalTokens      int `json:"total_tokens"`
-	} `json:"usage"`
+	Usage   Usage  `json:"usage"`
 
 	Choices []AnswerChoice `json:"choices"`
 
@@ -52,6 +65,8 @@ type Answer struct {
 		Param   string `json:"param"`
 		Code    string `json:"code"`
 	}
+
+	Cached bool `json:"-"` // replied from the cache
 }
 
 func (a *Answer) New() any {
@@ -61,6 +76,12 @@ func (a *Answer) Unmarshal(data []byte) error {
 	return json.Unmarshal(data, a)
 }
 
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
 type AnswerChoice struct {
 	Message      *Message `json:"message"`
 	FinishReason string   `json:"finish_reason"`
@@ -74,18 +95,23 @@ type AnswerError struct {
 	Code    string `json:"code"`
 }
 type AnswerChunk struct {
-	ID      string `json:"id"`
-	Object  string `json:"object"`
-	Created int64  `json:"created"`
-	Model   string `json:"model"`
-	Choices []struct {
-		Delta struct {
-			Content string `json:"content"`
-		} `json:"delta"`
-		FinishReason string `json:"finish_reason"`
-		Index        int    `json:"index"`
-	} `json:"choices"`
-	Error AnswerError `json:"error"`
+	ID      string        `json:"id"`
+	Object  string        `json:"object"`
+	Created int64         `json:"created"`
+	Model   string        `json:"model"`
+	Choices []ChunkChoice `json:"choices"`
+	Usage   *Usage        `json:"usage,omitempty"` // replied in the last chunk if include_usage is set
+	Error   AnswerError   `json:"error"`
+
+	Cached bool `json:"-"` // replied from the cache
+}
+
+type ChunkChoice struct {
+	Delta struct {
+		Content string `json:"content"`
+	} `json:"delta"`
+	FinishReason string `json:"finish_reason"`
+	Index        int    `json:"index"`
 }
 
 func (ac *AnswerChunk) New() any {
@@ -110,6 +136,7 @@ type ChatGPTOptions struct {
 	PresencePenalty  float32 `yaml:"presence_penalty,omitempty" json:"presence_penalty,omitempty" cortana:"--chatgpt.presence_penalty, -, 0, Number between -2.0 and 2.0. Positive values penalize new tokens based on whether they appear in the text so far, increasing the model's likelihood to talk about new topics."`
 	FrequencyPenalty float32 `yaml:"frequency_penalty,omitempty" json:"frequency_penalty,omitempty" cortana:"--chatgpt.frequency_penalty, -, 0, Number between -2.0 and 2.0. Positive values penalize new tokens based on their existing frequency in the text so far, decreasing the model's likelihood to repeat the same line verbatim."`
 	User             string  `yaml:"user,omitempty" json:"user,omitempty" cortana:"--chatgpt.user, -, , A unique identifier representing your end-user, which can help OpenAI to monitor and detect abuse."`
+	IncludeUsage     bool    `yaml:"include_usage,omitempty" json:"-" cortana:"--chatgpt.include_usage, -, true, If set, the usage is replied in the last chunk when streaming, disable it if the compatible API does not support stream_options, the tokens are counted locally then."`
 }
 
 const ChatGPTAPIURL = "https://api.openai.com/v1"
@@ -119,9 +146,9 @@ type ChatGPTClient struct {
 	cli  *chat.Client[*Question, *Answer, *AnswerChunk]
 }
 
-func NewChatGPTClient(cli *http.Client, baseURL, apikey string, opts *ChatGPTOptions) *ChatGPTClient {
+func NewChatGPTClient(cli *http.Client, baseURL, apikey string, opts *ChatGPTOptions, chatOpts ...chat.Option) *ChatGPTClient {
 	url := baseURL + "/chat/completions"
-	chatCli := chat.New[*Question, *Answer, *AnswerChunk](cli, url, apikey)
+	chatCli := chat.New[*Question, *Answer, *AnswerChunk](cli, url, apikey, chatOpts...)
 	return &ChatGPTClient{opts: opts, cli: chatCli}
 }
 
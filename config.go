@@ -0,0 +1,632 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shafreeck/cortana"
+	"gopkg.in/yaml.v3"
+)
+
+// configFiles are the configuration files from the lowest precedence to the
+// highest, the environment variables and the flags override them
+var configFiles = []string{"~/.guru/config", "guru.yaml"}
+
+// configProfile is the profile selected by --profile or GURU_PROFILE, the
+// values in the profiles section of the files override the top level ones
+var configProfile string
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// configKey is a key of the configuration, the name is the dotted yaml path
+// like chatgpt.model, and * matches any key of a map
+type configKey struct {
+	name    string
+	typ     reflect.Type
+	flag    string
+	short   string
+	def     string
+	hasDef  bool
+	envName string
+}
+
+// configSchema collects the keys from the yaml tags of the options
+func configSchema(options ...any) map[string]*configKey {
+	schema := make(map[string]*configKey)
+	for _, o := range options {
+		collectConfigKeys(schema, "", reflect.TypeOf(o))
+	}
+	return schema
+}
+
+// commandOptions are the options of the commands configured by the files
+var commandOptions = []any{ChatCommandOptions{}, BatchCommandOptions{}, RunCommandOptions{}, WatchCommandOptions{}}
+
+// commandConfigSchema knows the keys of all the commands
+func commandConfigSchema() map[string]*configKey {
+	return configSchema(commandOptions...)
+}
+
+// valueFlags returns the flags of the options which take a value, including
+// the ones not configured by the files like --prompt
+func valueFlags(options ...any) map[string]bool {
+	flags := make(map[string]bool)
+	var collect func(t reflect.Type)
+	collect = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.Type.Kind() == reflect.Struct && f.Tag.Get("cortana") == "" {
+				collect(f.Type)
+				continue
+			}
+			long, short, _, _ := parseCortanaTag(f.Tag.Get("cortana"))
+			if !strings.HasPrefix(long, "-") || f.Type.Kind() == reflect.Bool {
+				continue
+			}
+			flags[long] = true
+			if short != "" {
+				flags[short] = true
+			}
+		}
+	}
+	for _, o := range options {
+		collect(reflect.TypeOf(o))
+	}
+	return flags
+}
+
+func collectConfigKeys(schema map[string]*configKey, prefix string, t reflect.Type) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		tag := f.Tag.Get("yaml")
+		name, flags, _ := strings.Cut(tag, ",")
+		if name == "-" {
+			continue
+		}
+		if strings.Contains(flags, "inline") {
+			collectConfigKeys(schema, prefix, f.Type)
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		name = prefix + name
+
+		switch ft := f.Type; {
+		case ft.Kind() == reflect.Struct:
+			collectConfigKeys(schema, name+".", ft)
+		case ft.Kind() == reflect.Map && ft.Elem().Kind() == reflect.Struct:
+			collectConfigKeys(schema, name+".*.", ft.Elem())
+		case ft.Kind() == reflect.Map:
+			schema[name+".*"] = &configKey{name: name + ".*", typ: ft.Elem()}
+		case ft.Kind() == reflect.Slice && ft.Elem().Kind() == reflect.Struct:
+			// the list of structs is only set in the files, like endpoints
+			schema[name] = &configKey{name: name, typ: ft}
+		case ft.Kind() == reflect.Ptr || ft.Kind() == reflect.Interface:
+		default:
+			k := &configKey{name: name, typ: ft, envName: envName(name)}
+			long, short, def, hasDef := parseCortanaTag(f.Tag.Get("cortana"))
+			if long != "-" && strings.HasPrefix(long, "-") {
+				k.flag, k.short, k.def, k.hasDef = long, short, def, hasDef
+			}
+			schema[name] = k
+		}
+	}
+}
+
+// parseCortanaTag parses the tag like "--long, -s, default, description"
+func parseCortanaTag(tag string) (long, short, def string, hasDef bool) {
+	parts := strings.SplitN(tag, ",", 4)
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	if len(parts) > 0 {
+		long = parts[0]
+	}
+	if len(parts) > 1 && parts[1] != "-" {
+		short = parts[1]
+	}
+	if len(parts) > 2 && parts[2] != "-" {
+		def, hasDef = parts[2], true
+		if def == `''` || def == `""` {
+			def = ""
+		}
+	}
+	return
+}
+
+// envName returns the environment variable of the key, like GURU_CHATGPT_MODEL
+func envName(key string) string {
+	return "GURU_" + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(key))
+}
+
+// lookupConfigKey finds the key in the schema, the segments of the maps match *
+func lookupConfigKey(schema map[string]*configKey, name string) (*configKey, bool) {
+	if k, ok := schema[name]; ok {
+		return k, true
+	}
+	segments := strings.Split(name, ".")
+	for _, k := range schema {
+		pattern := strings.Split(k.name, ".")
+		if len(pattern) != len(segments) {
+			continue
+		}
+		matched := true
+		for i := range pattern {
+			if pattern[i] != "*" && pattern[i] != segments[i] {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return k, true
+		}
+	}
+	return nil, false
+}
+
+// parse converts the text to the value of the key type, durations are kept
+// as text which yaml unmarshals to time.Duration
+func (k *configKey) parse(s string) (any, error) {
+	return parseConfigValue(k.typ, s)
+}
+
+func parseConfigValue(t reflect.Type, s string) (any, error) {
+	if t == durationType {
+		if _, err := time.ParseDuration(s); err != nil {
+			return nil, fmt.Errorf("invalid duration %q, should be like 30s or 1m", s)
+		}
+		return s, nil
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return s, nil
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bool %q, should be true or false", s)
+		}
+		return b, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(s, 10, t.Bits())
+		if err != nil {
+			return nil, fmt.Errorf("invalid integer %q", s)
+		}
+		return i, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(s, 10, t.Bits())
+		if err != nil {
+			return nil, fmt.Errorf("invalid unsigned integer %q", s)
+		}
+		return u, nil
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", s)
+		}
+		return f, nil
+	case reflect.Slice:
+		// the list is separated by commas
+		var list []any
+		for _, e := range strings.Split(s, ",") {
+			v, err := parseConfigValue(t.Elem(), strings.TrimSpace(e))
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		return list, nil
+	}
+	return nil, fmt.Errorf("unsupported type %s", t)
+}
+
+// checkConfigValue checks the value unmarshaled from the file against the key
+func checkConfigValue(k *configKey, v any) error {
+	if k.typ.Kind() == reflect.Slice && k.typ.Elem().Kind() == reflect.Struct {
+		data, err := yaml.Marshal(v)
+		if err != nil {
+			return err
+		}
+		return yaml.Unmarshal(data, reflect.New(k.typ).Interface())
+	}
+	if k.typ.Kind() == reflect.Slice {
+		list, ok := v.([]any)
+		if !ok {
+			return fmt.Errorf("should be a list")
+		}
+		for _, e := range list {
+			if _, err := parseConfigValue(k.typ.Elem(), fmt.Sprint(e)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	_, err := k.parse(fmt.Sprint(v))
+	return err
+}
+
+// flattenConfig flattens the nested maps into the dotted keys
+func flattenConfig(prefix string, m map[string]any, out map[string]any) {
+	for k, v := range m {
+		if sub, ok := v.(map[string]any); ok {
+			flattenConfig(prefix+k+".", sub, out)
+			continue
+		}
+		out[prefix+k] = v
+	}
+}
+
+// configGet returns the value of the dotted key
+func configGet(m map[string]any, key string) (any, bool) {
+	fields := strings.Split(key, ".")
+	for _, f := range fields[:len(fields)-1] {
+		sub, ok := m[f].(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		m = sub
+	}
+	v, ok := m[fields[len(fields)-1]]
+	return v, ok
+}
+
+// configSet sets the value of the dotted key, the nested maps are created
+func configSet(m map[string]any, key string, val any) error {
+	fields := strings.Split(key, ".")
+	for i, f := range fields[:len(fields)-1] {
+		switch sub := m[f].(type) {
+		case map[string]any:
+			m = sub
+		case nil:
+			m[f] = make(map[string]any)
+			m = m[f].(map[string]any)
+		default:
+			return fmt.Errorf("%s is not a map", strings.Join(fields[:i+1], "."))
+		}
+	}
+	m[fields[len(fields)-1]] = val
+	return nil
+}
+
+// configUnset removes the dotted key, and the maps left empty
+func configUnset(m map[string]any, key string) bool {
+	f, rest, nested := strings.Cut(key, ".")
+	if !nested {
+		_, ok := m[f]
+		delete(m, f)
+		return ok
+	}
+	sub, ok := m[f].(map[string]any)
+	if !ok {
+		return false
+	}
+	removed := configUnset(sub, rest)
+	if len(sub) == 0 {
+		delete(m, f)
+	}
+	return removed
+}
+
+// profileKey returns the key in the profiles section if a profile is selected
+func profileKey(key string) string {
+	if configProfile == "" {
+		return key
+	}
+	return "profiles." + configProfile + "." + key
+}
+
+// extractProfile removes --profile from the args, which is accepted by all
+// the commands, and GURU_PROFILE is used if it is not set. The args after
+// -- and the values of the flags, like --system --profile, are kept
+func extractProfile(args []string) ([]string, string) {
+	profile := os.Getenv("GURU_PROFILE")
+	flags := valueFlags(commandOptions...)
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--":
+			rest = append(rest, args[i:]...)
+			return rest, profile
+		case flags[args[i]] && i+1 < len(args):
+			rest = append(rest, args[i], args[i+1])
+			i++
+		case args[i] == "--profile" && i+1 < len(args):
+			profile = args[i+1]
+			i++
+		case strings.HasPrefix(args[i], "--profile="):
+			profile = strings.TrimPrefix(args[i], "--profile=")
+		default:
+			rest = append(rest, args[i])
+		}
+	}
+	return rest, profile
+}
+
+// yamlConfigUnmarshaler unmarshals the file and then the selected profile
+var yamlConfigUnmarshaler = cortana.UnmarshalFunc(func(data []byte, v any) error {
+	if err := yaml.Unmarshal(data, v); err != nil {
+		return err
+	}
+	if configProfile == "" {
+		return nil
+	}
+	var file struct {
+		Profiles map[string]yaml.Node `yaml:"profiles"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return err
+	}
+	if profile, ok := file.Profiles[configProfile]; ok {
+		return profile.Decode(v)
+	}
+	return nil
+})
+
+// envConfigUnmarshaler applies the environment variables like GURU_API_KEY
+var envConfigUnmarshaler = cortana.EnvUnmarshalFunc(func(v any) error {
+	values, err := envConfig(commandConfigSchema())
+	if err != nil || len(values) == 0 {
+		return err
+	}
+	data, err := yaml.Marshal(values)
+	if err != nil {
+		return err
+	}
+	return yaml.Unmarshal(data, v)
+})
+
+// envConfig returns the nested values set by the environment variables
+func envConfig(schema map[string]*configKey) (map[string]any, error) {
+	values := make(map[string]any)
+	for _, k := range schema {
+		if k.envName == "" {
+			continue
+		}
+		s, ok := os.LookupEnv(k.envName)
+		if !ok {
+			continue
+		}
+		v, err := k.parse(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", k.envName, err)
+		}
+		if err := configSet(values, k.name, v); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+// configSource is where a value comes from
+type configSource struct {
+	value  any
+	source string
+}
+
+// explainConfig resolves the values layer by layer, the later overrides the
+// earlier, and reports the unknown keys or the invalid values of the files
+func explainConfig(schema map[string]*configKey, flagArgs []string) (map[string]*configSource, []string, error) {
+	values := make(map[string]*configSource)
+	var problems []string
+	for _, k := range schema {
+		if k.hasDef {
+			values[k.name] = &configSource{value: k.def, source: "default"}
+		}
+	}
+
+	applyFile := func(name string, m map[string]any, source string) {
+		flat := make(map[string]any)
+		flattenConfig("", m, flat)
+		for key, v := range flat {
+			k, ok := lookupConfigKey(schema, key)
+			if !ok {
+				problems = append(problems, fmt.Sprintf("%s: unknown key %s", name, key))
+				continue
+			}
+			if err := checkConfigValue(k, v); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %s %v", name, key, err))
+				continue
+			}
+			values[key] = &configSource{value: v, source: source}
+		}
+	}
+
+	profileFound := false
+	for _, file := range configFiles {
+		name := expandPath(file)
+		data, err := os.ReadFile(name)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, nil, err
+		}
+		m := make(map[string]any)
+		if err := yaml.Unmarshal(data, &m); err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", name, err)
+		}
+		profiles, _ := m["profiles"].(map[string]any)
+		delete(m, "profiles")
+		applyFile(name, m, name)
+		if profile, ok := profiles[configProfile].(map[string]any); ok && configProfile != "" {
+			profileFound = true
+			applyFile(name, profile, fmt.Sprintf("%s (profile %s)", name, configProfile))
+		}
+	}
+	if configProfile != "" && !profileFound {
+		problems = append(problems, "profile not found: "+configProfile)
+	}
+
+	env, err := envConfig(schema)
+	if err != nil {
+		return nil, nil, err
+	}
+	flat := make(map[string]any)
+	flattenConfig("", env, flat)
+	for key, v := range flat {
+		values[key] = &configSource{value: v, source: "env " + schema[key].envName}
+	}
+
+	flags, err := parseConfigFlags(schema, flagArgs)
+	if err != nil {
+		return nil, nil, err
+	}
+	for key, src := range flags {
+		values[key] = src
+	}
+	sort.Strings(problems)
+	return values, problems, nil
+}
+
+// parseConfigFlags parses the flags like the commands do, so the values set
+// by them could be explained
+func parseConfigFlags(schema map[string]*configKey, args []string) (map[string]*configSource, error) {
+	index := make(map[string]*configKey)
+	for _, k := range schema {
+		if k.flag != "" {
+			index[k.flag] = k
+		}
+		if k.short != "" {
+			index[k.short] = k
+		}
+	}
+
+	values := make(map[string]*configSource)
+	for i := 0; i < len(args); i++ {
+		name, val, hasVal := strings.Cut(args[i], "=")
+		k, ok := index[name]
+		if !ok {
+			return nil, errors.New("unknown flag: " + name)
+		}
+		if !hasVal {
+			if k.typ.Kind() == reflect.Bool {
+				val = "true"
+			} else if i+1 < len(args) {
+				i++
+				val = args[i]
+			} else {
+				return nil, errors.New(name + " requires an argument")
+			}
+		}
+		v, err := k.parse(val)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		// the list flags are appended
+		if old, ok := values[k.name]; ok && k.typ.Kind() == reflect.Slice {
+			v = append(old.value.([]any), v.([]any)...)
+		}
+		values[k.name] = &configSource{value: v, source: "flag " + k.flag}
+	}
+	return values, nil
+}
+
+// formatConfigValue formats the value in one line
+func formatConfigValue(v any) string {
+	if list, ok := v.([]any); ok {
+		var items []string
+		for _, e := range list {
+			// the structs are shown by the names, which may have secrets
+			if m, ok := e.(map[string]any); ok {
+				e = m["name"]
+			}
+			items = append(items, fmt.Sprint(e))
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	}
+	return fmt.Sprint(v)
+}
+
+// ConfigUnsetCommand removes the key from the configuration file
+func (g *Guru) ConfigUnsetCommand() {
+	opts := struct {
+		File string `cortana:"--file, -f, ~/.guru/config, the configuration file"`
+		Key  string `cortana:"key, -"`
+	}{}
+	cortana.Parse(&opts)
+
+	opts.File = expandPath(opts.File)
+	data, err := os.ReadFile(opts.File)
+	if err != nil {
+		g.Fatalln(err)
+	}
+	m := make(map[string]any)
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		g.Fatalln(err)
+	}
+	if !configUnset(m, profileKey(opts.Key)) {
+		g.Fatalln("key not found: " + opts.Key)
+	}
+	data, err = yaml.Marshal(m)
+	if err != nil {
+		g.Fatalln(err)
+	}
+	if err := writeConfigFile(opts.File, data); err != nil {
+		g.Fatalln(err)
+	}
+}
+
+// ConfigExplainCommand shows the effective values and where they come from,
+// the flags after the command are explained as the chat command does
+func (g *Guru) ConfigExplainCommand() {
+	opts := struct {
+		All bool `cortana:"--all, -a, false, show the keys not set as well"`
+	}{}
+	cortana.Parse(&opts, cortana.IgnoreUnknownArgs())
+
+	schema := commandConfigSchema()
+	values, problems, err := explainConfig(schema, cortana.Args())
+	if err != nil {
+		g.Fatalln(err)
+	}
+
+	var keys []string
+	for key := range values {
+		keys = append(keys, key)
+	}
+	if opts.All {
+		for key, k := range schema {
+			if _, ok := values[key]; !ok && !strings.Contains(k.name, "*") {
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+
+	keyWidth, valWidth := len("key"), len("value")
+	for _, key := range keys {
+		if n := len(key); n > keyWidth {
+			keyWidth = n
+		}
+		if src, ok := values[key]; ok {
+			if n := len(formatConfigValue(src.value)); n > valWidth {
+				valWidth = n
+			}
+		}
+	}
+	g.StylePrintln(g.highlightStyle, fmt.Sprintf("%-*s %-*s %s", keyWidth, "key", valWidth, "value", "source"))
+	for _, key := range keys {
+		src, ok := values[key]
+		if !ok {
+			src = &configSource{value: "", source: "not set"}
+		}
+		if s, ok := src.value.(string); ok && isSecretKey(key) {
+			src = &configSource{value: maskSecret(s), source: src.source}
+		}
+		g.Println(fmt.Sprintf("%-*s %-*s %s", keyWidth, key, valWidth, redact(formatConfigValue(src.value)), src.source))
+	}
+	for _, p := range problems {
+		g.Errorln(p)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestConfigSchema(t *testing.T) {
+	schema := commandConfigSchema()
+	for _, c := range []struct {
+		name, flag, short, def, env string
+		typ                         reflect.Type
+	}{
+		{"chatgpt.model", "--chatgpt.model", "", "gpt-3.5-turbo", "GURU_CHATGPT_MODEL", reflect.TypeOf("")},
+		{"timeout", "--timeout", "", "180s", "GURU_TIMEOUT", durationType},
+		{"non-interactive", "--non-interactive", "-n", "false", "GURU_NON_INTERACTIVE", reflect.TypeOf(false)},
+		{"debounce", "--debounce", "", "500ms", "GURU_DEBOUNCE", durationType},
+	} {
+		k, ok := schema[c.name]
+		if !ok {
+			t.Errorf("%s is not in the schema", c.name)
+			continue
+		}
+		if k.flag != c.flag || k.short != c.short || k.def != c.def || k.envName != c.env || k.typ != c.typ {
+			t.Errorf("%s is %+v", c.name, k)
+		}
+	}
+	// the maps match any key, and the options not in the files are skipped
+	for name, want := range map[string]string{"headers.X-Token": "headers.*",
+		"prices.gpt-4.prompt": "prices.*.prompt", "macros.review": "macros.*"} {
+		if k, ok := lookupConfigKey(schema, name); !ok || k.name != want {
+			t.Errorf("%s matches %v, want %s", name, k, want)
+		}
+	}
+	for _, name := range []string{"text", "workflow", "files", "chatgpt.unknown"} {
+		if _, ok := lookupConfigKey(schema, name); ok {
+			t.Errorf("%s is in the schema", name)
+		}
+	}
+}
+
+func TestParseConfigValue(t *testing.T) {
+	for _, c := range []struct {
+		typ  reflect.Type
+		text string
+		want any
+		err  string
+	}{
+		{reflect.TypeOf(""), "gpt-4", "gpt-4", ""},
+		{reflect.TypeOf(false), "true", true, ""},
+		{reflect.TypeOf(false), "maybe", nil, "invalid bool"},
+		{reflect.TypeOf(0), "42", int64(42), ""},
+		{reflect.TypeOf(int8(0)), "300", nil, "invalid integer"},
+		{reflect.TypeOf(uint(0)), "-1", nil, "invalid unsigned integer"},
+		{reflect.TypeOf(float32(0)), "0.5", 0.5, ""},
+		{reflect.TypeOf(float64(0)), "high", nil, "invalid number"},
+		{durationType, "30s", "30s", ""},
+		{durationType, "30", nil, "invalid duration"},
+		{reflect.TypeOf([]string{}), "a, b", []any{"a", "b"}, ""},
+		{reflect.TypeOf([]int{}), "1,x", nil, "invalid integer"},
+		{reflect.TypeOf(map[string]string{}), "a", nil, "unsupported type"},
+	} {
+		v, err := parseConfigValue(c.typ, c.text)
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%s %q: %v, want the error %q", c.typ, c.text, err, c.err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(v, c.want) {
+			t.Errorf("%s %q: %#v, %v, want %#v", c.typ, c.text, v, err, c.want)
+		}
+	}
+}
+
+func TestExtractProfile(t *testing.T) {
+	for _, c := range []struct {
+		args, rest []string
+		env, want  string
+	}{
+		{[]string{"chat", "--profile", "work", "hi"}, []string{"chat", "hi"}, "", "work"},
+		{[]string{"--profile=work", "chat"}, []string{"chat"}, "", "work"},
+		{[]string{"chat", "hi"}, []string{"chat", "hi"}, "home", "home"},
+		{[]string{"--profile", "work", "chat"}, []string{"chat"}, "home", "work"},
+		// the args after -- and the values of the flags are kept
+		{[]string{"chat", "--", "--profile", "work"}, []string{"chat", "--", "--profile", "work"}, "", ""},
+		{[]string{"chat", "--system", "--profile", "hi"}, []string{"chat", "--system", "--profile", "hi"}, "", ""},
+		{[]string{"chat", "-p", "--profile", "--profile", "work"}, []string{"chat", "-p", "--profile"}, "", "work"},
+		// the bool flags take no value
+		{[]string{"chat", "-n", "--profile", "work"}, []string{"chat", "-n"}, "", "work"},
+	} {
+		t.Setenv("GURU_PROFILE", c.env)
+		rest, profile := extractProfile(c.args)
+		if !reflect.DeepEqual(rest, c.rest) || profile != c.want {
+			t.Errorf("%q: %q, %q, want %q, %q", c.args, rest, profile, c.rest, c.want)
+		}
+	}
+}
+
+func TestConfigUnset(t *testing.T) {
+	m := map[string]any{
+		"api-key":  "sk-top",
+		"profiles": map[string]any{"work": map[string]any{"api-key": "sk-work"}},
+		"chatgpt":  map[string]any{"model": "gpt-4", "n": 2},
+	}
+	defer func(p string) { configProfile = p }(configProfile)
+	configProfile = "work"
+	if !configUnset(m, profileKey("api-key")) {
+		t.Fatal("the key of the profile is not removed")
+	}
+	// the maps left empty are removed
+	if _, ok := m["profiles"]; ok || m["api-key"] != "sk-top" {
+		t.Errorf("the config is %v", m)
+	}
+	configProfile = ""
+	if !configUnset(m, "chatgpt.model") || configUnset(m, "chatgpt.model") || configUnset(m, "api-key.x") {
+		t.Errorf("unset chatgpt.model again or under a value")
+	}
+	if v, ok := configGet(m, "chatgpt.n"); !ok || v != 2 {
+		t.Errorf("chatgpt.n is %v, %v", v, ok)
+	}
+	if err := configSet(m, "api-key.x", "y"); err == nil {
+		t.Error("set under a value")
+	}
+}
+
+func TestExplainConfig(t *testing.T) {
+	dir := t.TempDir()
+	global := filepath.Join(dir, "config")
+	project := filepath.Join(dir, "guru.yaml")
+	writeFile := func(name, text string) {
+		if err := os.WriteFile(name, []byte(text), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(global, `
+chatgpt: {model: gpt-global, temperature: 0.2, n: 2}
+timeout: 10s
+retry-backoff: soon
+colour: red
+profiles:
+  work: {base-url: "https://work.example.com/v1"}
+`)
+	writeFile(project, "chatgpt: {model: gpt-project, n: 3}\n")
+	defer func(files []string, p string) { configFiles, configProfile = files, p }(configFiles, configProfile)
+	configFiles, configProfile = []string{global, project}, "work"
+	t.Setenv("GURU_CHATGPT_N", "4")
+	t.Setenv("GURU_TIMEOUT", "20s")
+
+	values, problems, err := explainConfig(commandConfigSchema(), []string{"--timeout", "30s", "-v"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the defaults, the files, the profile, the env and the flags by precedence
+	for key, want := range map[string]configSource{
+		"theme":               {"auto", "default"},
+		"chatgpt.temperature": {0.2, global},
+		"chatgpt.model":       {"gpt-project", project},
+		"base-url":            {"https://work.example.com/v1", global + " (profile work)"},
+		"chatgpt.n":           {int64(4), "env GURU_CHATGPT_N"},
+		"timeout":             {"30s", "flag --timeout"},
+		"verbose":             {true, "flag --verbose"},
+	} {
+		got := values[key]
+		if got == nil || !reflect.DeepEqual(*got, want) {
+			t.Errorf("%s is %+v, want %+v", key, got, want)
+		}
+	}
+	want := []string{global + ": retry-backoff invalid duration \"soon\", should be like 30s or 1m",
+		global + ": unknown key colour"}
+	if !reflect.DeepEqual(problems, want) {
+		t.Errorf("the problems are %q, want %q", problems, want)
+	}
+
+	configProfile = "home"
+	if _, problems, _ := explainConfig(commandConfigSchema(), nil); !strings.Contains(strings.Join(problems, "\n"), "profile not found: home") {
+		t.Errorf("the missing profile is not reported: %q", problems)
+	}
+	t.Setenv("GURU_TIMEOUT", "soon")
+	if _, _, err := explainConfig(commandConfigSchema(), nil); err == nil || !strings.Contains(err.Error(), "GURU_TIMEOUT") {
+		t.Errorf("the invalid env is taken: %v", err)
+	}
+	if _, _, err := explainConfig(commandConfigSchema(), []string{"--unknown"}); err == nil {
+		t.Error("the unknown flag is taken")
+	}
+}
@@ -0,0 +1,514 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shafreeck/guru/chat"
+)
+
+// the auth schemes of the endpoints
+const (
+	authBearer = "bearer"  // Authorization: Bearer <key>
+	authAPIKey = "api-key" // api-key: <key>
+	authAzure  = "azure"   // api-key: <key>, and the deployment url with api-version
+	authNone   = "none"    // the key is not sent, like the gateway authenticated by the headers or certificates
+)
+
+const defaultAzureAPIVersion = "2024-02-01"
+
+// the url templates, {base-url}, {model} and {api-version} are replaced
+const (
+	defaultURLTemplate = "{base-url}/chat/completions"
+	azureURLTemplate   = "{base-url}/openai/deployments/{model}/chat/completions?api-version={api-version}"
+)
+
+// Endpoint is an api the questions are sent to, the endpoints are tried by
+// the priority, and failed over if one is unavailable
+type Endpoint struct {
+	Name       string            `yaml:"name" json:"name"`
+	BaseURL    string            `yaml:"base-url" json:"base-url"`
+	APIKey     string            `yaml:"api-key" json:"-"`               // the key or the reference like env:VAR, the api-key is used if empty
+	Auth       string            `yaml:"auth" json:"auth"`               // bearer(default), api-key or azure
+	APIVersion string            `yaml:"api-version" json:"api-version"` // the api-version of azure
+	Priority   int               `yaml:"priority" json:"priority"`       // the smaller is tried first
+	Timeout    time.Duration     `yaml:"timeout" json:"timeout"`         // fail over if not responding in time, 0 means no limit
+	Models     map[string]string `yaml:"models" json:"models"`           // maps the model to the one or the azure deployment of the endpoint
+
+	URLTemplate string            `yaml:"url-template" json:"url-template"` // the url of the requests, base-url/chat/completions by default
+	Headers     map[string]string `yaml:"headers" json:"headers"`           // the extra headers, the values could be references like env:VAR
+}
+
+// model returns the model or the deployment of the endpoint
+func (e *Endpoint) model(model string) string {
+	if m, ok := e.Models[model]; ok {
+		return m
+	}
+	return model
+}
+
+// namespace identifies the replies of the endpoint in the cache, the
+// model could be mapped to another one by the endpoint
+func (e *Endpoint) namespace() string {
+	var models []string
+	for k, v := range e.Models {
+		models = append(models, k+"="+v)
+	}
+	sort.Strings(models)
+	return e.url("") + " " + strings.Join(models, ",")
+}
+
+// url expands the url template with the model
+func (e *Endpoint) url(model string) string {
+	tmpl, version := e.URLTemplate, e.APIVersion
+	if tmpl == "" {
+		tmpl = defaultURLTemplate
+		if e.Auth == authAzure {
+			tmpl = azureURLTemplate
+		}
+	}
+	if version == "" && e.Auth == authAzure {
+		version = defaultAzureAPIVersion
+	}
+	return strings.NewReplacer(
+		"{base-url}", strings.TrimSuffix(e.BaseURL, "/"),
+		"{model}", url.PathEscape(model),
+		"{api-version}", url.QueryEscape(version),
+	).Replace(tmpl)
+}
+
+func (e *Endpoint) chatOptions() []chat.Option {
+	var opts []chat.Option
+	switch e.Auth {
+	case authAPIKey, authAzure:
+		opts = append(opts, chat.WithAuthHeader("api-key", ""))
+	case authNone:
+		opts = append(opts, chat.WithAuthHeader("", ""))
+	}
+	for k, v := range e.Headers {
+		opts = append(opts, chat.WithHeader(k, v))
+	}
+	return opts
+}
+
+// checkAuth checks the auth scheme
+func checkAuth(auth string) error {
+	switch auth {
+	case "", authBearer, authAPIKey, authAzure, authNone:
+		return nil
+	}
+	return fmt.Errorf("unknown auth %q, can be bearer, api-key, azure or none", auth)
+}
+
+// endpointClient asks the endpoint, the clients are created by the urls
+// which differ by the deployments of azure
+type endpointClient struct {
+	*Endpoint
+	cli  *http.Client
+	opts []chat.Option
+
+	mu      sync.Mutex
+	clients map[string]*chat.Client[*Question, *Answer, *AnswerChunk]
+}
+
+func (e *endpointClient) client(model string) *chat.Client[*Question, *Answer, *AnswerChunk] {
+	u := e.url(model)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if c, ok := e.clients[u]; ok {
+		return c
+	}
+	opts := append(e.chatOptions(), e.opts...)
+	c := chat.New[*Question, *Answer, *AnswerChunk](e.cli, u, e.APIKey, opts...)
+	e.clients[u] = c
+	return c
+}
+
+// question replaces the model with the one of the endpoint
+func (e *endpointClient) question(q *Question) (*Question, string) {
+	model := e.model(q.Model)
+	if model == q.Model {
+		return q, model
+	}
+	copied := *q
+	copied.Model = model
+	return &copied, model
+}
+
+func (e *endpointClient) Ask(ctx context.Context, q *Question) (*Answer, error) {
+	eq, model := e.question(q)
+	return e.client(model).Ask(ctx, eq)
+}
+
+func (e *endpointClient) Stream(ctx context.Context, q *Question) (chan *AnswerChunk, error) {
+	eq, model := e.question(q)
+	return e.client(model).Stream(ctx, eq)
+}
+
+func newEndpointClient(cli *http.Client, ep *Endpoint, opts ...chat.Option) *endpointClient {
+	return &endpointClient{Endpoint: ep, cli: cli, opts: opts,
+		clients: make(map[string]*chat.Client[*Question, *Answer, *AnswerChunk])}
+}
+
+// endpointHealth is the health of an endpoint, it is skipped until
+// DownUntil after failing
+type endpointHealth struct {
+	Failures  int       `json:"failures"`
+	DownUntil time.Time `json:"down_until"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+// healthStore keeps the health of the endpoints in a file, so it is
+// shared by the invocations of guru
+type healthStore struct {
+	file string
+	out  CommandOutput // reports the file failed to read or write
+	mu   sync.Mutex
+	m    map[string]*endpointHealth
+}
+
+// loadHealthStore loads the health from file, all the endpoints are taken
+// as healthy if the file is corrupted
+func loadHealthStore(file string, out CommandOutput) *healthStore {
+	s := &healthStore{file: file, out: out, m: make(map[string]*endpointHealth)}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			out.Errorln("load the health of the endpoints failed:", err)
+		}
+		return s
+	}
+	if err := json.Unmarshal(data, &s.m); err != nil {
+		out.Errorln(fmt.Sprintf("the health of the endpoints in %s is corrupted and reset: %v", file, err))
+		s.m = make(map[string]*endpointHealth)
+	}
+	return s
+}
+
+func (s *healthStore) get(name string) endpointHealth {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if h, ok := s.m[name]; ok {
+		return *h
+	}
+	return endpointHealth{}
+}
+
+// fail marks the endpoint down for a duration growing with the failures,
+// or the duration suggested by the server
+func (s *healthStore) fail(name string, err error) {
+	s.mu.Lock()
+	h, ok := s.m[name]
+	if !ok {
+		h = &endpointHealth{}
+		s.m[name] = h
+	}
+	h.Failures++
+	d := 30 * time.Second << (h.Failures - 1)
+	if d <= 0 || d > 5*time.Minute {
+		d = 5 * time.Minute
+	}
+	var rle *chat.RateLimitError
+	if errors.As(err, &rle) && rle.RetryAfter > 0 {
+		d = rle.RetryAfter
+	}
+	h.DownUntil = time.Now().Add(d)
+	h.LastError = err.Error()
+	s.mu.Unlock()
+	s.save()
+}
+
+func (s *healthStore) succeed(name string) {
+	s.mu.Lock()
+	_, ok := s.m[name]
+	delete(s.m, name)
+	s.mu.Unlock()
+	if ok {
+		s.save()
+	}
+}
+
+func (s *healthStore) save() {
+	s.mu.Lock()
+	data, err := json.MarshalIndent(s.m, "", "  ")
+	s.mu.Unlock()
+	if err == nil {
+		// the last errors could tell the urls and the accounts
+		err = os.WriteFile(s.file, data, 0600)
+	}
+	if err != nil {
+		s.out.Errorln("save the health of the endpoints failed:", err)
+	}
+}
+
+// errEndpointTimeout is returned if the endpoint does not respond in time
+var errEndpointTimeout = errors.New("the endpoint does not respond in time")
+
+// shouldFailover reports whether to try the next endpoint for err
+func shouldFailover(err error) bool {
+	var rle *chat.RateLimitError
+	var se *chat.ServerError
+	var ne net.Error
+	switch {
+	case errors.Is(err, context.Canceled):
+		return false
+	case errors.Is(err, errEndpointTimeout), errors.As(err, &rle), errors.As(err, &se), errors.As(err, &ne):
+		return true
+	}
+	return false
+}
+
+// FailoverChat asks the endpoints by the priority, the next one is tried if
+// the endpoint is rate limited, fails or times out
+type FailoverChat struct {
+	endpoints []*endpointClient
+	health    *healthStore
+	out       CommandOutput // reports the endpoints failed over
+
+	mu   sync.Mutex
+	last string // the endpoint replied the last question
+}
+
+// NewFailoverChat creates the clients of the endpoints with the options
+func NewFailoverChat(cli *http.Client, endpoints []*Endpoint, healthFile string,
+	out CommandOutput, opts ...chat.Option) *FailoverChat {
+	if out == nil {
+		out = &commandStdout{}
+	}
+	f := &FailoverChat{health: loadHealthStore(healthFile, out), out: out}
+	for _, ep := range endpoints {
+		f.endpoints = append(f.endpoints, newEndpointClient(cli, ep, opts...))
+	}
+	sort.SliceStable(f.endpoints, func(i, j int) bool {
+		return f.endpoints[i].Priority < f.endpoints[j].Priority
+	})
+	return f
+}
+
+// order returns the healthy endpoints by the priority, followed by the ones
+// down by the time they recover
+func (f *FailoverChat) order() []*endpointClient {
+	now := time.Now()
+	var up, down []*endpointClient
+	for _, e := range f.endpoints {
+		if f.health.get(e.Name).DownUntil.After(now) {
+			down = append(down, e)
+			continue
+		}
+		up = append(up, e)
+	}
+	sort.SliceStable(down, func(i, j int) bool {
+		return f.health.get(down[i].Name).DownUntil.Before(f.health.get(down[j].Name).DownUntil)
+	})
+	return append(up, down...)
+}
+
+// namespace is the endpoints in order, the replies of the same endpoints
+// are shared in the cache
+func (f *FailoverChat) namespace() string {
+	var names []string
+	for _, e := range f.endpoints {
+		names = append(names, e.namespace())
+	}
+	return strings.Join(names, "\n")
+}
+
+// Take returns the endpoint replied the last question and clears it, it is
+// empty if the reply is not from the endpoints, like from the cache
+func (f *FailoverChat) Take() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	last := f.last
+	f.last = ""
+	return last
+}
+
+func (f *FailoverChat) served(name string) {
+	f.health.succeed(name)
+	f.mu.Lock()
+	f.last = name
+	f.mu.Unlock()
+}
+
+// try calls the endpoints in order until one succeeds or the error should
+// not be failed over
+func (f *FailoverChat) try(ctx context.Context, call func(ctx context.Context, e *endpointClient) error) error {
+	var err error
+	for _, e := range f.order() {
+		err = call(ctx, e)
+		if err == nil {
+			f.served(e.Name)
+			return nil
+		}
+		if ctx.Err() != nil || !shouldFailover(err) {
+			return err
+		}
+		f.health.fail(e.Name, err)
+		f.out.Errorln(fmt.Sprintf("endpoint %s failed, fail over to the next: %v", e.Name, err))
+	}
+	return err
+}
+
+func (f *FailoverChat) Ask(ctx context.Context, q *Question) (*Answer, error) {
+	var ans *Answer
+	err := f.try(ctx, func(parent context.Context, e *endpointClient) error {
+		ctx := parent
+		if e.Timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(parent, e.Timeout)
+			defer cancel()
+		}
+		a, err := e.Ask(ctx, q)
+		if err != nil && ctx.Err() != nil && parent.Err() == nil {
+			err = errEndpointTimeout
+		}
+		ans = a
+		return err
+	})
+	return ans, err
+}
+
+// Stream fails over only before the stream starts, or the reply would be
+// duplicated, the timeout of the endpoint limits the time to respond
+func (f *FailoverChat) Stream(ctx context.Context, q *Question) (chan *AnswerChunk, error) {
+	var s chan *AnswerChunk
+	err := f.try(ctx, func(parent context.Context, e *endpointClient) error {
+		ctx, cancel := context.WithCancel(parent)
+		var timer *time.Timer
+		if e.Timeout > 0 {
+			timer = time.AfterFunc(e.Timeout, cancel)
+		}
+		ch, err := e.Stream(ctx, q)
+		if timer != nil && !timer.Stop() && parent.Err() == nil {
+			cancel()
+			return errEndpointTimeout
+		}
+		if err != nil {
+			cancel()
+			return err
+		}
+		// release the context once the stream is done
+		s = make(chan *AnswerChunk)
+		go func() {
+			defer cancel()
+			defer close(s)
+			for c := range ch {
+				select {
+				case s <- c:
+				case <-parent.Done():
+					return
+				}
+			}
+		}()
+		return nil
+	})
+	return s, err
+}
+
+// headerNames returns the names of the extra headers, which are redacted
+// when recording as they are typically the tokens of the gateways
+func (opts *ClientOptions) headerNames() []string {
+	var names []string
+	for k := range opts.Headers {
+		names = append(names, k)
+	}
+	for _, h := range opts.Header {
+		if k, _, ok := strings.Cut(h, ":"); ok {
+			names = append(names, strings.TrimSpace(k))
+		}
+	}
+	for _, ep := range opts.Endpoints {
+		for k := range ep.Headers {
+			names = append(names, k)
+		}
+	}
+	return names
+}
+
+// newChatClient asks the endpoints with failover if configured, or the
+// api by base-url and api-key
+func (g *Guru) newChatClient(httpCli *http.Client, opts *ClientOptions, dir string) chat.Chat[*Question, *Answer, *AnswerChunk] {
+	keyringFile := path.Join(expandPath(dir), "keyring")
+	headers := make(map[string]string)
+	for k, v := range opts.Headers {
+		headers[k] = v
+	}
+	for _, h := range opts.Header {
+		k, v, ok := strings.Cut(h, ":")
+		if !ok {
+			g.Fatalln(fmt.Sprintf("invalid header %q, should be like 'X-Team: ai'", h))
+		}
+		headers[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	// resolve the secrets of the endpoint, the headers are added to the
+	// ones of the client options
+	resolve := func(ep *Endpoint) {
+		if err := checkAuth(ep.Auth); err != nil {
+			g.Fatalln(fmt.Sprintf("endpoint %s: %v", ep.Name, err))
+		}
+		key, err := resolveSecret(g, ep.APIKey, keyringFile)
+		if err != nil {
+			g.Fatalln(fmt.Sprintf("resolve the api-key of the endpoint %s failed: %v", ep.Name, err))
+		}
+		ep.APIKey = key
+		merged := make(map[string]string)
+		for k, v := range headers {
+			merged[k] = v
+		}
+		for k, v := range ep.Headers {
+			merged[k] = v
+		}
+		for k, v := range merged {
+			if !isSecretRef(v) {
+				continue
+			}
+			if merged[k], err = resolveSecret(g, v, keyringFile); err != nil {
+				g.Fatalln(fmt.Sprintf("resolve the header %s failed: %v", k, err))
+			}
+		}
+		ep.Headers = merged
+	}
+
+	if len(opts.Endpoints) == 0 {
+		ep := &Endpoint{Name: "default", BaseURL: opts.BaseURL, APIKey: opts.APIKey, Auth: opts.Auth,
+			APIVersion: opts.APIVersion, URLTemplate: opts.URLTemplate}
+		resolve(ep)
+		return newEndpointClient(httpCli, ep, chat.WithRetry(opts.MaxRetries, opts.RetryBackoff, 0))
+	}
+
+	var endpoints []*Endpoint
+	for i := range opts.Endpoints {
+		ep := opts.Endpoints[i]
+		if opts.Endpoint != "" && ep.Name != opts.Endpoint {
+			continue
+		}
+		if ep.Name == "" {
+			ep.Name = ep.BaseURL
+		}
+		if ep.APIKey == "" {
+			ep.APIKey = opts.APIKey
+		}
+		resolve(&ep)
+		endpoints = append(endpoints, &ep)
+	}
+	if len(endpoints) == 0 {
+		g.Fatalln("endpoint not found: " + opts.Endpoint)
+	}
+	// the endpoints do not retry by themselves but fail over to the next one
+	retry := chat.WithRetry(0, 0, 0)
+	if len(endpoints) == 1 {
+		retry = chat.WithRetry(opts.MaxRetries, opts.RetryBackoff, 0)
+	}
+	return NewFailoverChat(httpCli, endpoints, path.Join(expandPath(dir), "endpoints.json"), g, retry)
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shafreeck/guru/chat"
+	"github.com/shafreeck/guru/mock"
+)
+
+func TestHeaderNames(t *testing.T) {
+	opts := &ClientOptions{
+		Headers:   map[string]string{"X-Config-Token": "a"},
+		Header:    []string{"X-Flag-Token: b", "invalid"},
+		Endpoints: []Endpoint{{Name: "gw", Headers: map[string]string{"X-Endpoint-Token": "env:TOKEN"}}},
+	}
+	names := opts.headerNames()
+	sort.Strings(names)
+	want := []string{"X-Config-Token", "X-Endpoint-Token", "X-Flag-Token"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("header names %v, want %v", names, want)
+	}
+}
+
+func TestShouldFailover(t *testing.T) {
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{&chat.RateLimitError{Message: "slow down"}, true},
+		{&chat.RateLimitError{Message: "no quota", Quota: true}, true},
+		{&chat.ServerError{StatusCode: 503}, true},
+		{fmt.Errorf("ask: %w", errEndpointTimeout), true},
+		{&net.OpError{Op: "dial", Err: errors.New("connection refused")}, true},
+		{&chat.AuthError{Message: "invalid key"}, false},
+		{&chat.ContextLengthError{Message: "too long"}, false},
+		{&chat.Error{StatusCode: 400, Message: "bad request"}, false},
+		{context.Canceled, false},
+		{fmt.Errorf("read: %w", context.Canceled), false},
+	}
+	for _, c := range cases {
+		if got := shouldFailover(c.err); got != c.want {
+			t.Errorf("shouldFailover(%v) = %v, want %v", c.err, got, c.want)
+		}
+	}
+}
+
+// newTestFailover creates the failover of the mock servers named by the
+// keys, the endpoints are prioritized in the order of names
+func newTestFailover(t *testing.T, out *bytes.Buffer, names []string, servers map[string]*mock.Server) *FailoverChat {
+	t.Helper()
+	var endpoints []*Endpoint
+	for i, name := range names {
+		srv := httptest.NewServer(servers[name])
+		t.Cleanup(srv.Close)
+		endpoints = append(endpoints, &Endpoint{Name: name, BaseURL: srv.URL + "/v1", Priority: i})
+	}
+	return NewFailoverChat(http.DefaultClient, endpoints, filepath.Join(t.TempDir(), "endpoints.json"),
+		New(WithStdout(out)), chat.WithRetry(0, 0, 0))
+}
+
+func names(endpoints []*endpointClient) []string {
+	var names []string
+	for _, e := range endpoints {
+		names = append(names, e.Name)
+	}
+	return names
+}
+
+func TestFailoverOrder(t *testing.T) {
+	var out bytes.Buffer
+	f := newTestFailover(t, &out, []string{"a", "b", "c"}, nil)
+	if got := names(f.order()); !reflect.DeepEqual(got, []string{"a", "b", "c"}) {
+		t.Fatalf("order %v, want by the priority", got)
+	}
+
+	// the endpoints down are tried last by the time they recover
+	f.health.fail("a", &chat.RateLimitError{RetryAfter: time.Hour})
+	f.health.fail("b", &chat.RateLimitError{RetryAfter: time.Minute})
+	if got := names(f.order()); !reflect.DeepEqual(got, []string{"c", "b", "a"}) {
+		t.Errorf("order %v, want c, b, a", got)
+	}
+	f.health.succeed("a")
+	if got := names(f.order()); !reflect.DeepEqual(got, []string{"a", "c", "b"}) {
+		t.Errorf("order %v, want a, c, b", got)
+	}
+
+	// the health is shared by the invocations by the file
+	h := loadHealthStore(f.health.file, New(WithStdout(&out)))
+	if !h.get("b").DownUntil.After(time.Now()) || h.get("a").Failures != 0 {
+		t.Errorf("the health is not saved: %+v", h.m)
+	}
+	if info, err := os.Stat(f.health.file); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("the health file is not private: %v", err)
+	}
+}
+
+func TestHealthStoreCorrupted(t *testing.T) {
+	var out bytes.Buffer
+	file := filepath.Join(t.TempDir(), "endpoints.json")
+	os.WriteFile(file, []byte(`{"a": {"failures": `), 0600)
+	h := loadHealthStore(file, New(WithStdout(&out)))
+	if len(h.m) != 0 || !strings.Contains(out.String(), "corrupted") {
+		t.Errorf("the corrupted file is not reported: %q", out.String())
+	}
+
+	// the file can not be written into a directory not existing
+	out.Reset()
+	h = loadHealthStore(filepath.Join(file, "endpoints.json"), New(WithStdout(&out)))
+	h.fail("a", errors.New("failed"))
+	if !strings.Contains(out.String(), "save the health of the endpoints failed") {
+		t.Errorf("the failure to save is not reported: %q", out.String())
+	}
+}
+
+func TestFailoverTry(t *testing.T) {
+	limited := &mock.Script{Rules: []*mock.Rule{{Error: "rate_limit_exceeded"}}}
+	tooLong := &mock.Script{Rules: []*mock.Rule{{Error: "context_length_exceeded"}}}
+	cases := []struct {
+		name    string
+		servers map[string]*mock.Server
+		served  string // the endpoint replied, empty if failed
+		down    []string
+	}{
+		{"first", map[string]*mock.Server{"a": mock.New(), "b": mock.New()}, "a", nil},
+		{"rate limited", map[string]*mock.Server{"a": mock.New(mock.WithScript(limited)), "b": mock.New()}, "b", []string{"a"}},
+		{"not failed over", map[string]*mock.Server{"a": mock.New(mock.WithScript(tooLong)), "b": mock.New()}, "", nil},
+		{"all failed", map[string]*mock.Server{"a": mock.New(mock.WithScript(limited)),
+			"b": mock.New(mock.WithScript(limited))}, "", []string{"a", "b"}},
+	}
+	for _, c := range cases {
+		for _, stream := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s/stream=%v", c.name, stream), func(t *testing.T) {
+				var out bytes.Buffer
+				f := newTestFailover(t, &out, []string{"a", "b"}, c.servers)
+				q := &Question{ChatGPTOptions: ChatGPTOptions{Model: "gpt-test", Stream: stream},
+					Messages: []*Message{{Role: User, Content: "hello"}}}
+				var reply string
+				var err error
+				if stream {
+					var ch chan *AnswerChunk
+					if ch, err = f.Stream(context.Background(), q); err == nil {
+						for c := range ch {
+							for _, choice := range c.Choices {
+								reply += choice.Delta.Content
+							}
+						}
+					}
+				} else {
+					var ans *Answer
+					if ans, err = f.Ask(context.Background(), q); err == nil {
+						reply = ans.Choices[0].Message.Content
+					}
+				}
+
+				served := f.Take()
+				if served != c.served {
+					t.Errorf("served by %q, want %q", served, c.served)
+				}
+				if c.served != "" && (err != nil || reply != "hello") {
+					t.Errorf("reply %q, err %v", reply, err)
+				}
+				if c.served == "" && err == nil {
+					t.Errorf("no error, want the error of the endpoints")
+				}
+				var down []string
+				for _, name := range []string{"a", "b"} {
+					if f.health.get(name).DownUntil.After(time.Now()) {
+						down = append(down, name)
+						if !strings.Contains(out.String(), "endpoint "+name+" failed") {
+							t.Errorf("the failover of %s is not reported: %q", name, out.String())
+						}
+					}
+				}
+				if !reflect.DeepEqual(down, c.down) {
+					t.Errorf("endpoints down %v, want %v", down, c.down)
+				}
+			})
+		}
+	}
+}
+
+func TestCacheNamespace(t *testing.T) {
+	endpoint := func(models map[string]string) *Endpoint {
+		return &Endpoint{Name: "a", BaseURL: "https://api.example.com/v1", Models: models}
+	}
+	gpt4 := cacheNamespace(newEndpointClient(nil, endpoint(map[string]string{"gpt-4": "gpt-4o"})), "")
+	mini := cacheNamespace(newEndpointClient(nil, endpoint(map[string]string{"gpt-4": "gpt-4o-mini"})), "")
+	if gpt4 == mini {
+		t.Errorf("the endpoints mapping the models differently share the namespace %q", gpt4)
+	}
+
+	var out bytes.Buffer
+	file := filepath.Join(t.TempDir(), "endpoints.json")
+	one := NewFailoverChat(nil, []*Endpoint{endpoint(nil)}, file, New(WithStdout(&out)))
+	two := NewFailoverChat(nil, []*Endpoint{endpoint(nil), {Name: "b", BaseURL: "https://backup.example.com/v1"}},
+		file, New(WithStdout(&out)))
+	if cacheNamespace(one, "") == cacheNamespace(two, "") {
+		t.Errorf("the endpoint sets share the namespace")
+	}
+	if ns := cacheNamespace(one, ""); !strings.HasPrefix(ns, "https://api.example.com/v1/chat/completions") {
+		t.Errorf("namespace %q, want by the url", ns)
+	}
+}
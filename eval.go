@@ -111,6 +111,11 @@ func builtinCommandEval(sess *Session, text string) (cont bool) {
 			return false
 		}
 	}())
+	if len(args) > 0 {
+		if m, ok := macros[args[0]]; ok {
+			return m.eval(args[1:])
+		}
+	}
 
 	text = strings.TrimSpace(builtins.Launch(args))
 	if text != "" {
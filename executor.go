@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"os/exec"
 	"strings"
 	"unicode"
@@ -15,16 +16,17 @@ func NewExecutor(cmd string) *Executor {
 }
 
 // Exec invokes the executor and feed input to
-// stdin, and then return the stdout as a string
+// stdin, and then return the stdout as a string,
+// the output is returned with the error if it fails
 func (e *Executor) Exec(input string) (string, error) {
 	args := splitQuoted(e.cmd)
+	if len(args) == 0 {
+		return "", errors.New("the command is empty")
+	}
 	cmd := exec.Command(args[0], args[1:]...)
 	cmd.Stdin = strings.NewReader(input)
 	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return "", err
-	}
-	return string(output), nil
+	return string(output), err
 }
 
 // splitQuoted split s by spaces with keeping
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/shafreeck/guru/chat"
+	"github.com/shafreeck/guru/tui"
+)
+
+// the output formats
+const (
+	OutputText  = "text"  // the rendered text for humans
+	OutputJSON  = "json"  // a json object of the result when finished
+	OutputJSONL = "jsonl" // a json event per line, the deltas are streamed
+)
+
+// the exit codes in the non-interactive mode
+const (
+	ExitOK            = 0
+	ExitError         = 1
+	ExitAPIError      = 2
+	ExitRateLimited   = 3
+	ExitTokenExceeded = 4
+	ExitSchemaInvalid = 5
+	ExitTimeout       = 124
+	ExitCanceled      = 130 // 128 + SIGINT, as the shells do
+)
+
+// classify returns the exit code and the error type of err
+func classify(err error) (int, string) {
+	var rateLimitErr *chat.RateLimitError
+	var contextLengthErr *chat.ContextLengthError
+	var authErr *chat.AuthError
+	var serverErr *chat.ServerError
+	var apiErr *chat.Error
+	var schemaErr *SchemaError
+
+	switch {
+	case err == nil:
+		return ExitOK, ""
+	case errors.As(err, &rateLimitErr):
+		return ExitRateLimited, "rate_limited"
+	case errors.As(err, &contextLengthErr):
+		return ExitTokenExceeded, "token_exceeded"
+	case errors.As(err, &schemaErr):
+		return ExitSchemaInvalid, "schema_invalid"
+	case errors.As(err, &authErr):
+		return ExitAPIError, "auth_error"
+	case errors.As(err, &serverErr), errors.As(err, &apiErr):
+		return ExitAPIError, "api_error"
+	case errors.Is(err, context.DeadlineExceeded):
+		return ExitTimeout, "timeout"
+	case errors.Is(err, context.Canceled), errors.Is(err, tui.ErrInterrupted):
+		return ExitCanceled, "canceled"
+	}
+	return ExitError, "error"
+}
+
+func exitCode(err error) int {
+	code, _ := classify(err)
+	return code
+}
+
+// CommandResult is a command executed by the executor
+type CommandResult struct {
+	Command  string `json:"command"`
+	Executed bool   `json:"executed"` // false if the user does not confirm
+	Output   string `json:"output,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+type ResultError struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// Result is the machine readable result of a run
+type Result struct {
+	SessionID    string           `json:"session_id"`
+	Model        string           `json:"model,omitempty"`
+	Reply        string           `json:"reply"`
+	FinishReason string           `json:"finish_reason,omitempty"`
+	JSON         json.RawMessage  `json:"json,omitempty"` // the reply validated against the schema
+	Usage        *Usage           `json:"usage,omitempty"`
+	Cost         float64          `json:"cost"`
+	Estimated    bool             `json:"estimated,omitempty"` // the tokens are counted locally
+	Cached       bool             `json:"cached,omitempty"`
+	Truncated    bool             `json:"truncated,omitempty"` // interrupted or timed out
+	Commands     []*CommandResult `json:"commands,omitempty"`
+	Error        *ResultError     `json:"error,omitempty"`
+	ExitCode     int              `json:"exit_code"`
+}
+
+// Event is a line of the jsonl output
+type Event struct {
+	Type    string         `json:"type"` // delta, retry, command or result
+	Index   int            `json:"index,omitempty"`
+	Content string         `json:"content,omitempty"`
+	Command *CommandResult `json:"command,omitempty"`
+	Result  *Result        `json:"result,omitempty"`
+}
+
+// Emitter writes the results in json or jsonl in the headless mode,
+// nothing goes through the tui then
+type Emitter struct {
+	w      io.Writer
+	format string
+	result Result
+	err    error // the first error of writing the events
+}
+
+// NewEmitter returns nil for the text format
+func NewEmitter(w io.Writer, format string) (*Emitter, error) {
+	switch format {
+	case "", OutputText:
+		return nil, nil
+	case OutputJSON, OutputJSONL:
+		return &Emitter{w: w, format: format}, nil
+	}
+	return nil, fmt.Errorf("unknown output format: %s, should be text, json or jsonl", format)
+}
+
+// emit writes v in a line, the error is kept and reported by Finish
+func (e *Emitter) emit(v any) error {
+	data, err := json.Marshal(v)
+	if err == nil {
+		_, err = fmt.Fprintln(e.w, string(data))
+	}
+	if err != nil && e.err == nil {
+		e.err = err
+	}
+	return err
+}
+
+// Delta streams the delta of a choice in jsonl
+func (e *Emitter) Delta(index int, content string) {
+	if e.format != OutputJSONL || content == "" {
+		return
+	}
+	e.emit(&Event{Type: "delta", Index: index, Content: content})
+}
+
+// Reply sets the reply, the last one wins if asked several times
+func (e *Emitter) Reply(content, finishReason string, u *UsageEntry, truncated bool) {
+	r := &e.result
+	r.Reply, r.FinishReason, r.Truncated = content, finishReason, truncated
+	if u != nil {
+		usage := u.Usage
+		r.Model, r.Usage, r.Cost, r.Estimated, r.Cached = u.Model, &usage, u.Cost, u.Estimated, u.Cached
+	}
+}
+
+// Validated sets the reply which matches the schema
+func (e *Emitter) Validated(data []byte) {
+	e.result.JSON = json.RawMessage(data)
+}
+
+// Retry tells the reply is invalid and asked again, the content is the reason
+func (e *Emitter) Retry(reason string) {
+	if e.format == OutputJSONL {
+		e.emit(&Event{Type: "retry", Content: reason})
+	}
+}
+
+func (e *Emitter) Command(cr *CommandResult) {
+	e.result.Commands = append(e.result.Commands, cr)
+	if e.format == OutputJSONL {
+		e.emit(&Event{Type: "command", Command: cr})
+	}
+}
+
+// Finish writes the result and returns the exit code, the failure of
+// writing the events is reported as an error if the run succeeds
+func (e *Emitter) Finish(sid string, err error) int {
+	if err == nil && e.err != nil {
+		err = fmt.Errorf("write the output failed: %w", e.err)
+	}
+	r := &e.result
+	r.SessionID = sid
+	r.ExitCode, r.Error = ExitOK, nil
+	if err != nil {
+		code, typ := classify(err)
+		r.ExitCode, r.Error = code, &ResultError{Type: typ, Message: err.Error()}
+	}
+	var v any = r
+	if e.format == OutputJSONL {
+		v = &Event{Type: "result", Result: r}
+	}
+	if werr := e.emit(v); werr != nil {
+		// the result could not be marshaled, like an invalid json of the
+		// reply, which is dropped to report the error
+		r.JSON = nil
+		if r.Error == nil {
+			r.ExitCode = ExitError
+			r.Error = &ResultError{Type: "error", Message: "write the output failed: " + werr.Error()}
+		}
+		e.emit(v)
+	}
+	return r.ExitCode
+}
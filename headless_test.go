@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/shafreeck/guru/chat"
+	"github.com/shafreeck/guru/tui"
+)
+
+func TestEmitterExitCodes(t *testing.T) {
+	cases := []struct {
+		err  error
+		code int
+		typ  string
+	}{
+		{nil, ExitOK, ""},
+		{errors.New("boom"), ExitError, "error"},
+		{&chat.AuthError{Message: "bad key"}, ExitAPIError, "auth_error"},
+		{&chat.ServerError{StatusCode: 502, Message: "bad gateway"}, ExitAPIError, "api_error"},
+		{chat.NewError(400, "invalid_request_error", "", "bad request"), ExitAPIError, "api_error"},
+		{fmt.Errorf("ask: %w", &chat.RateLimitError{Message: "slow down"}), ExitRateLimited, "rate_limited"},
+		{chat.NewError(400, "invalid_request_error", "context_length_exceeded", "too long"), ExitTokenExceeded, "token_exceeded"},
+		{&SchemaError{}, ExitSchemaInvalid, "schema_invalid"},
+		{fmt.Errorf("ask: %w", context.DeadlineExceeded), ExitTimeout, "timeout"},
+		{context.Canceled, ExitCanceled, "canceled"},
+		{tui.ErrInterrupted, ExitCanceled, "canceled"},
+	}
+	for _, format := range []string{OutputJSON, OutputJSONL} {
+		for _, c := range cases {
+			var buf bytes.Buffer
+			e, err := NewEmitter(&buf, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			e.Reply("the reply", "stop", nil, false)
+			if code := e.Finish("sid-1", c.err); code != c.code {
+				t.Errorf("%s %v: exit code %d, want %d", format, c.err, code, c.code)
+			}
+
+			r := &Result{}
+			if format == OutputJSONL {
+				ev := &Event{Result: r}
+				if err := json.Unmarshal(buf.Bytes(), ev); err != nil || ev.Type != "result" {
+					t.Fatalf("%s: the event %q, %v", format, buf.String(), err)
+				}
+			} else if err := json.Unmarshal(buf.Bytes(), r); err != nil {
+				t.Fatalf("%s: the result %q, %v", format, buf.String(), err)
+			}
+			if r.ExitCode != c.code || r.SessionID != "sid-1" || r.Reply != "the reply" {
+				t.Errorf("%s %v: the result is %+v", format, c.err, r)
+			}
+			if c.err == nil && r.Error != nil || c.err != nil && (r.Error == nil || r.Error.Type != c.typ) {
+				t.Errorf("%s %v: the error is %+v, want the type %q", format, c.err, r.Error, c.typ)
+			}
+		}
+	}
+}
+
+func TestEmitterEvents(t *testing.T) {
+	var buf bytes.Buffer
+	e, _ := NewEmitter(&buf, OutputJSONL)
+	e.Delta(0, "hel")
+	e.Delta(1, "")
+	e.Delta(1, "lo")
+	e.Retry("invalid")
+	e.Command(&CommandResult{Command: "ls", Executed: true, Output: "a"})
+	e.Finish("sid", nil)
+
+	var types []string
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		ev := &Event{}
+		if err := json.Unmarshal([]byte(line), ev); err != nil {
+			t.Fatalf("%q: %v", line, err)
+		}
+		types = append(types, fmt.Sprintf("%s:%d:%s", ev.Type, ev.Index, ev.Content))
+	}
+	want := "delta:0:hel delta:1:lo retry:0:invalid command:0: result:0:"
+	if got := strings.Join(types, " "); got != want {
+		t.Errorf("the events are %s, want %s", got, want)
+	}
+
+	// only the result is written in json
+	buf.Reset()
+	e, _ = NewEmitter(&buf, OutputJSON)
+	e.Delta(0, "hello")
+	e.Retry("invalid")
+	e.Finish("sid", nil)
+	if n := strings.Count(buf.String(), "\n"); n != 1 {
+		t.Errorf("%d lines written in json, want the result only: %q", n, buf.String())
+	}
+}
+
+type failedWriter struct{}
+
+func (failedWriter) Write(p []byte) (int, error) { return 0, errors.New("broken pipe") }
+
+func TestEmitterFailures(t *testing.T) {
+	// the invalid json of the reply is dropped to report the error
+	var buf bytes.Buffer
+	e, _ := NewEmitter(&buf, OutputJSON)
+	e.Validated([]byte(`{"broken":`))
+	if code := e.Finish("sid", nil); code != ExitError {
+		t.Errorf("exit code %d, want %d", code, ExitError)
+	}
+	r := &Result{}
+	if err := json.Unmarshal(buf.Bytes(), r); err != nil {
+		t.Fatalf("the result %q, %v", buf.String(), err)
+	}
+	if r.JSON != nil || r.ExitCode != ExitError || r.Error == nil || !strings.Contains(r.Error.Message, "write the output failed") {
+		t.Errorf("the result is %+v", r)
+	}
+
+	e, _ = NewEmitter(failedWriter{}, OutputJSONL)
+	e.Delta(0, "hello")
+	if code := e.Finish("sid", nil); code != ExitError {
+		t.Errorf("exit code %d when the output is broken, want %d", code, ExitError)
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/shafreeck/guru/tui"
 )
 
 type GuruInfo struct {
@@ -32,13 +34,14 @@ func (g *GuruInfo) infoCommand() string {
 			continue
 		}
 
-		if k == "api-key" {
+		if secretKeys[k] || k == "endpoints" {
 			unsettable = append(unsettable, fmt.Sprint(fmt.Sprintf("%-30s", k),
-				g.g.errStyle.Render("sk-"+strings.Repeat("*", 48))))
+				g.g.errStyle.Render(maskValue(v))))
 			continue
 		}
+		// the others may contain the secrets resolved, like the password of the proxy
 		unsettable = append(unsettable, fmt.Sprint(fmt.Sprintf("%-30s", k),
-			g.g.errStyle.Render(fmt.Sprint(v))))
+			g.g.errStyle.Render(redact(fmt.Sprint(v)))))
 	}
 	sort.StringSlice(unsettable).Sort()
 	sort.StringSlice(settable).Sort()
@@ -78,6 +81,17 @@ func (g *GuruInfo) setCommand() (_ string) {
 		return
 	}
 
+	// the replies could not be rendered by an unknown renderer
+	if opts.Key == "renderer" {
+		if _, err := tui.LookupRenderer(opts.Value); err != nil {
+			g.g.Errorln(err)
+			return
+		}
+		if opts.Value == "" {
+			opts.Value = "markdown"
+		}
+	}
+
 	for rv.Kind() == reflect.Ptr {
 		rv = rv.Elem()
 	}
@@ -131,6 +145,33 @@ func (g *GuruInfo) setCommand() (_ string) {
 	return ""
 }
 
+// maskValue masks the secret, the values of the map like the headers, or the
+// keys and headers of the endpoints
+func maskValue(v reflect.Value) string {
+	if eps, ok := v.Interface().([]Endpoint); ok {
+		var items []string
+		for _, ep := range eps {
+			ep.APIKey = maskSecret(ep.APIKey)
+			headers := make(map[string]string, len(ep.Headers))
+			for k, h := range ep.Headers {
+				headers[k] = maskSecret(h)
+			}
+			ep.Headers = headers
+			items = append(items, redact(fmt.Sprintf("%+v", ep)))
+		}
+		return "[" + strings.Join(items, " ") + "]"
+	}
+	if v.Kind() != reflect.Map {
+		return maskSecret(v.String())
+	}
+	var items []string
+	for _, k := range v.MapKeys() {
+		items = append(items, fmt.Sprintf("%v:%s", k, maskSecret(fmt.Sprint(v.MapIndex(k)))))
+	}
+	sort.Strings(items)
+	return "map[" + strings.Join(items, " ") + "]"
+}
+
 func buildFieldIndex(v interface{}) map[string]reflect.Value {
 	m := make(map[string]reflect.Value)
 	analyze("", reflect.ValueOf(v), m)
@@ -148,10 +189,16 @@ func analyze(prefix string, val reflect.Value, m map[string]reflect.Value) {
 		vField := val.Field(i)
 		tField := val.Type().Field(i)
 
-		tag := strings.Split(tField.Tag.Get("yaml"), ",")[0]
+		tags := strings.Split(tField.Tag.Get("yaml"), ",")
+		tag := tags[0]
 		if tag == "-" {
 			continue
 		}
+		// the fields of the inlined struct are at the same level
+		if len(tags) > 1 && tags[1] == "inline" {
+			analyze(prefix, vField, m)
+			continue
+		}
 		if tag == "" {
 			tag = tField.Name
 		}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestInfoMasksSecrets(t *testing.T) {
+	const key = "sk-0123456789abcdefghijklmnopqrstuvwxyz"
+	const token = "gateway-token-0123456789abcdef"
+	const endpointKey = "sk-endpoint-0123456789abcdefghijklmnop"
+
+	var out bytes.Buffer
+	opts := &ChatCommandOptions{}
+	opts.APIKey = key
+	opts.Headers = map[string]string{"X-Gateway-Token": token}
+	opts.Endpoints = []Endpoint{{Name: "backup", BaseURL: "https://backup.example.com/v1", APIKey: endpointKey}}
+
+	gi := NewGuruInfo(New(WithStdout(&out)), opts)
+	gi.copts = &ChatOptions{}
+	gi.infoCommand()
+
+	text := out.String()
+	for _, secret := range []string{key, token, endpointKey} {
+		if strings.Contains(text, secret) {
+			t.Errorf("the secret %q is shown by :info", secret)
+		}
+	}
+	var found bool
+	for _, line := range strings.Split(text, "\n") {
+		if strings.HasPrefix(line, "api-key ") {
+			found = true
+			if !strings.Contains(line, maskSecret(key)) {
+				t.Errorf("api-key is not masked: %q", line)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("api-key is not listed:\n%s", text)
+	}
+}
+
+func TestSetRenderer(t *testing.T) {
+	var out bytes.Buffer
+	opts := &ChatCommandOptions{Renderer: "markdown"}
+	gi := NewGuruInfo(New(WithStdout(&out)), opts)
+	gi.copts = &ChatOptions{}
+	gi.registerBuiltinCommands()
+
+	builtins.Launch([]string{":set", "renderer", "foo"})
+	if opts.Renderer != "markdown" || !strings.Contains(out.String(), `unknown renderer "foo"`) {
+		t.Errorf("renderer %q, output %q, want foo rejected", opts.Renderer, out.String())
+	}
+	builtins.Launch([]string{":set", "renderer", "code"})
+	if opts.Renderer != "code" {
+		t.Errorf("renderer %q, want code", opts.Renderer)
+	}
+}
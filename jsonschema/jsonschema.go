@@ -0,0 +1,553 @@
+// Package jsonschema validates the json values against a JSON Schema, the
+// validation keywords of draft 2020-12 are supported except the ones about
+// the dynamic references, unevaluated locations and contents. The formats
+// are treated as annotations and not validated.
+package jsonschema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Error is a validation error of the value at Path, which is a json pointer
+type Error struct {
+	Path    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	p := e.Path
+	if p == "" {
+		p = "/"
+	}
+	return p + ": " + e.Message
+}
+
+// Schema is a compiled JSON Schema
+type Schema struct {
+	name string
+	raw  json.RawMessage
+	root any
+
+	patterns map[string]*regexp.Regexp
+}
+
+// Compile compiles the schema, the name is used as the name of the schema
+// when it is sent to the api
+func Compile(name string, data []byte) (*Schema, error) {
+	var root any
+	if err := json.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	switch root.(type) {
+	case map[string]any, bool:
+	default:
+		return nil, fmt.Errorf("invalid schema: should be an object or a boolean")
+	}
+	s := &Schema{name: name, raw: json.RawMessage(data), root: root, patterns: make(map[string]*regexp.Regexp)}
+	if err := s.compilePatterns(root); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Load loads and compiles the schema from file, the schema is named
+// by the title or the file name
+func Load(filename string) (*Schema, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	name := strings.TrimSuffix(path.Base(filename), path.Ext(filename))
+	s, err := Compile(name, data)
+	if err != nil {
+		return nil, err
+	}
+	if m, ok := s.root.(map[string]any); ok {
+		if title, ok := m["title"].(string); ok && title != "" {
+			s.name = title
+		}
+	}
+	return s, nil
+}
+
+var invalidNameChars = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// Name returns the name which matches ^[a-zA-Z0-9_-]+$ as the api requires
+func (s *Schema) Name() string {
+	name := strings.Trim(invalidNameChars.ReplaceAllString(s.name, "_"), "_")
+	if name == "" {
+		name = "schema"
+	}
+	return name
+}
+
+// Raw returns the schema as it is
+func (s *Schema) Raw() json.RawMessage {
+	return s.raw
+}
+
+// the keywords of the subschemas, the other keywords like enum and default
+// are the data which are not walked
+var (
+	schemaKeywords     = []string{"additionalProperties", "items", "contains", "propertyNames", "not", "if", "then", "else", "additionalItems", "unevaluatedItems", "unevaluatedProperties"}
+	schemaListKeywords = []string{"allOf", "anyOf", "oneOf", "prefixItems"}
+	schemaMapKeywords  = []string{"properties", "patternProperties", "$defs", "definitions", "dependentSchemas"}
+)
+
+// compilePatterns compiles all the regexps in the schema ahead, so the
+// invalid patterns are reported when compiling
+func (s *Schema) compilePatterns(node any) error {
+	m, ok := node.(map[string]any)
+	if !ok {
+		return nil
+	}
+	if p, ok := m["pattern"].(string); ok {
+		if err := s.compilePattern(p); err != nil {
+			return err
+		}
+	}
+	if pp, ok := m["patternProperties"].(map[string]any); ok {
+		for p := range pp {
+			if err := s.compilePattern(p); err != nil {
+				return err
+			}
+		}
+	}
+	for _, k := range schemaKeywords {
+		if err := s.compilePatterns(m[k]); err != nil {
+			return err
+		}
+	}
+	for _, k := range schemaListKeywords {
+		list, _ := m[k].([]any)
+		for _, sub := range list {
+			if err := s.compilePatterns(sub); err != nil {
+				return err
+			}
+		}
+	}
+	for _, k := range schemaMapKeywords {
+		subs, _ := m[k].(map[string]any)
+		for _, sub := range subs {
+			if err := s.compilePatterns(sub); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Schema) compilePattern(p string) error {
+	re, err := regexp.Compile(p)
+	if err != nil {
+		return fmt.Errorf("invalid pattern %q: %w", p, err)
+	}
+	s.patterns[p] = re
+	return nil
+}
+
+// Validate validates the value decoded by encoding/json, nil is returned
+// if the value is valid
+func (s *Schema) Validate(v any) []*Error {
+	var errs []*Error
+	s.validate(s.root, v, "", &errs, 0)
+	return errs
+}
+
+// ValidateJSON decodes and validates the data
+func (s *Schema) ValidateJSON(data []byte) []*Error {
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return []*Error{{Message: "invalid json: " + err.Error()}}
+	}
+	return s.Validate(v)
+}
+
+// the max references expanded for a value, which prevents the infinite
+// recursion of the cyclic references like {"$ref": "#"}. The references
+// are counted again for the items and properties, so a recursive schema
+// validates the values nested deeply
+const maxRefs = 64
+
+// validate validates v against the schema, refs is the number of the
+// references expanded for v
+func (s *Schema) validate(schema, v any, ptr string, errs *[]*Error, refs int) {
+	fail := func(format string, a ...any) {
+		*errs = append(*errs, &Error{Path: ptr, Message: fmt.Sprintf(format, a...)})
+	}
+	if refs > maxRefs {
+		fail("the references are expanded too deeply, which may be cyclic")
+		return
+	}
+
+	var m map[string]any
+	switch sc := schema.(type) {
+	case bool:
+		if !sc {
+			fail("no value is allowed")
+		}
+		return
+	case map[string]any:
+		m = sc
+	default:
+		return
+	}
+
+	if ref, ok := m["$ref"].(string); ok {
+		target, err := s.resolve(ref)
+		if err != nil {
+			fail("%v", err)
+		} else {
+			s.validate(target, v, ptr, errs, refs+1)
+		}
+	}
+
+	if t, ok := m["type"]; ok && !matchType(t, v) {
+		fail("expected %s, got %s", describeType(t), typeOf(v))
+		// the other keywords make no sense for a value of wrong type
+		return
+	}
+	if enum, ok := m["enum"].([]any); ok {
+		found := false
+		for _, e := range enum {
+			if equal(e, v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fail("should be one of %s", marshal(enum))
+		}
+	}
+	if c, ok := m["const"]; ok && !equal(c, v) {
+		fail("should be %s", marshal(c))
+	}
+
+	switch val := v.(type) {
+	case float64:
+		s.validateNumber(m, val, fail)
+	case string:
+		s.validateString(m, val, fail)
+	case []any:
+		s.validateArray(m, val, ptr, errs, fail)
+	case map[string]any:
+		s.validateObject(m, val, ptr, errs, fail)
+	}
+
+	// the combinators
+	if all, ok := m["allOf"].([]any); ok {
+		for _, sub := range all {
+			s.validate(sub, v, ptr, errs, refs)
+		}
+	}
+	if anyOf, ok := m["anyOf"].([]any); ok {
+		if n := s.countValid(anyOf, v, ptr, refs); n == 0 {
+			fail("should match at least one schema of anyOf")
+		}
+	}
+	if oneOf, ok := m["oneOf"].([]any); ok {
+		if n := s.countValid(oneOf, v, ptr, refs); n != 1 {
+			fail("should match exactly one schema of oneOf, matched %d", n)
+		}
+	}
+	if not, ok := m["not"]; ok {
+		if s.valid(not, v, ptr, refs) {
+			fail("should not match the schema of not")
+		}
+	}
+	if cond, ok := m["if"]; ok {
+		if s.valid(cond, v, ptr, refs) {
+			if then, ok := m["then"]; ok {
+				s.validate(then, v, ptr, errs, refs)
+			}
+		} else if els, ok := m["else"]; ok {
+			s.validate(els, v, ptr, errs, refs)
+		}
+	}
+}
+
+func (s *Schema) valid(schema, v any, ptr string, refs int) bool {
+	var errs []*Error
+	s.validate(schema, v, ptr, &errs, refs)
+	return len(errs) == 0
+}
+
+func (s *Schema) countValid(schemas []any, v any, ptr string, refs int) int {
+	n := 0
+	for _, sub := range schemas {
+		if s.valid(sub, v, ptr, refs) {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *Schema) validateNumber(m map[string]any, v float64, fail func(string, ...any)) {
+	if min, ok := number(m["minimum"]); ok && v < min {
+		fail("should be >= %v", min)
+	}
+	if max, ok := number(m["maximum"]); ok && v > max {
+		fail("should be <= %v", max)
+	}
+	if min, ok := number(m["exclusiveMinimum"]); ok && v <= min {
+		fail("should be > %v", min)
+	}
+	if max, ok := number(m["exclusiveMaximum"]); ok && v >= max {
+		fail("should be < %v", max)
+	}
+	if d, ok := number(m["multipleOf"]); ok && d > 0 {
+		if q := v / d; math.Abs(q-math.Round(q)) > 1e-9 {
+			fail("should be a multiple of %v", d)
+		}
+	}
+}
+
+func (s *Schema) validateString(m map[string]any, v string, fail func(string, ...any)) {
+	n := utf8.RuneCountInString(v)
+	if min, ok := number(m["minLength"]); ok && float64(n) < min {
+		fail("should have at least %v characters", min)
+	}
+	if max, ok := number(m["maxLength"]); ok && float64(n) > max {
+		fail("should have at most %v characters", max)
+	}
+	if p, ok := m["pattern"].(string); ok {
+		if re := s.patterns[p]; re != nil && !re.MatchString(v) {
+			fail("should match the pattern %q", p)
+		}
+	}
+}
+
+func (s *Schema) validateArray(m map[string]any, v []any, ptr string, errs *[]*Error, fail func(string, ...any)) {
+	if min, ok := number(m["minItems"]); ok && float64(len(v)) < min {
+		fail("should have at least %v items", min)
+	}
+	if max, ok := number(m["maxItems"]); ok && float64(len(v)) > max {
+		fail("should have at most %v items", max)
+	}
+	if unique, _ := m["uniqueItems"].(bool); unique {
+	outer:
+		for i := range v {
+			for j := i + 1; j < len(v); j++ {
+				if equal(v[i], v[j]) {
+					fail("the items %d and %d should be unique", i, j)
+					break outer
+				}
+			}
+		}
+	}
+
+	prefix, _ := m["prefixItems"].([]any)
+	for i, item := range v {
+		p := ptr + "/" + strconv.Itoa(i)
+		if i < len(prefix) {
+			s.validate(prefix[i], item, p, errs, 0)
+		} else if items, ok := m["items"]; ok {
+			s.validate(items, item, p, errs, 0)
+		}
+	}
+
+	if contains, ok := m["contains"]; ok {
+		n := 0
+		for i, item := range v {
+			if s.valid(contains, item, ptr+"/"+strconv.Itoa(i), 0) {
+				n++
+			}
+		}
+		min, hasMin := number(m["minContains"])
+		if !hasMin {
+			min = 1
+		}
+		if float64(n) < min {
+			fail("should contain at least %v matched items", min)
+		}
+		if max, ok := number(m["maxContains"]); ok && float64(n) > max {
+			fail("should contain at most %v matched items", max)
+		}
+	}
+}
+
+func (s *Schema) validateObject(m map[string]any, v map[string]any, ptr string, errs *[]*Error, fail func(string, ...any)) {
+	if min, ok := number(m["minProperties"]); ok && float64(len(v)) < min {
+		fail("should have at least %v properties", min)
+	}
+	if max, ok := number(m["maxProperties"]); ok && float64(len(v)) > max {
+		fail("should have at most %v properties", max)
+	}
+	if required, ok := m["required"].([]any); ok {
+		for _, r := range required {
+			if name, ok := r.(string); ok {
+				if _, ok := v[name]; !ok {
+					fail("missing the required property %q", name)
+				}
+			}
+		}
+	}
+	if deps, ok := m["dependentRequired"].(map[string]any); ok {
+		for name, required := range deps {
+			if _, ok := v[name]; !ok {
+				continue
+			}
+			list, _ := required.([]any)
+			for _, r := range list {
+				if dep, ok := r.(string); ok {
+					if _, ok := v[dep]; !ok {
+						fail("missing the property %q required by %q", dep, name)
+					}
+				}
+			}
+		}
+	}
+
+	props, _ := m["properties"].(map[string]any)
+	patternProps, _ := m["patternProperties"].(map[string]any)
+	additional, hasAdditional := m["additionalProperties"]
+	names, hasNames := m["propertyNames"]
+
+	// validate in order, so the errors are stable
+	keys := make([]string, 0, len(v))
+	for k := range v {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		p := ptr + "/" + escape(k)
+		if hasNames && !s.valid(names, k, p, 0) {
+			fail("the property name %q is invalid", k)
+		}
+		matched := false
+		if sub, ok := props[k]; ok {
+			matched = true
+			s.validate(sub, v[k], p, errs, 0)
+		}
+		for pattern, sub := range patternProps {
+			if re := s.patterns[pattern]; re != nil && re.MatchString(k) {
+				matched = true
+				s.validate(sub, v[k], p, errs, 0)
+			}
+		}
+		if !matched && hasAdditional {
+			if allowed, ok := additional.(bool); ok && !allowed {
+				fail("the property %q is not allowed", k)
+				continue
+			}
+			s.validate(additional, v[k], p, errs, 0)
+		}
+	}
+}
+
+// resolve resolves the local reference like "#/$defs/name"
+func (s *Schema) resolve(ref string) (any, error) {
+	if !strings.HasPrefix(ref, "#") {
+		return nil, fmt.Errorf("the remote reference %q is not supported", ref)
+	}
+	node := s.root
+	pointer := strings.TrimPrefix(ref, "#")
+	if pointer == "" {
+		return node, nil
+	}
+	for _, token := range strings.Split(strings.TrimPrefix(pointer, "/"), "/") {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		switch n := node.(type) {
+		case map[string]any:
+			next, ok := n[token]
+			if !ok {
+				return nil, fmt.Errorf("the reference %q is not found", ref)
+			}
+			node = next
+		case []any:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(n) {
+				return nil, fmt.Errorf("the reference %q is not found", ref)
+			}
+			node = n[i]
+		default:
+			return nil, fmt.Errorf("the reference %q is not found", ref)
+		}
+	}
+	return node, nil
+}
+
+func matchType(t any, v any) bool {
+	switch tt := t.(type) {
+	case string:
+		return isType(tt, v)
+	case []any:
+		for _, t := range tt {
+			if name, ok := t.(string); ok && isType(name, v) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func isType(name string, v any) bool {
+	switch name {
+	case "integer":
+		f, ok := v.(float64)
+		return ok && f == math.Trunc(f)
+	case "number":
+		_, ok := v.(float64)
+		return ok
+	}
+	return typeOf(v) == name
+}
+
+func typeOf(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func describeType(t any) string {
+	if list, ok := t.([]any); ok {
+		var names []string
+		for _, t := range list {
+			names = append(names, fmt.Sprint(t))
+		}
+		return strings.Join(names, " or ")
+	}
+	return fmt.Sprint(t)
+}
+
+func number(v any) (float64, bool) {
+	f, ok := v.(float64)
+	return f, ok
+}
+
+func equal(a, b any) bool {
+	return reflect.DeepEqual(a, b)
+}
+
+func marshal(v any) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+// escape escapes the reference token of json pointer
+func escape(token string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(token, "~", "~0"), "/", "~1")
+}
@@ -0,0 +1,213 @@
+package jsonschema
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func mustCompile(t *testing.T, schema string) *Schema {
+	t.Helper()
+	s, err := Compile("test", []byte(schema))
+	if err != nil {
+		t.Fatalf("compile %s: %v", schema, err)
+	}
+	return s
+}
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		name   string
+		schema string
+		value  string
+		errs   []string // the errors as "path: message", empty if valid
+	}{
+		// type
+		{"type string", `{"type":"string"}`, `"a"`, nil},
+		{"type mismatch", `{"type":"string"}`, `1`, []string{"/: expected string, got number"}},
+		{"type integer", `{"type":"integer"}`, `1.0`, nil},
+		{"type not integer", `{"type":"integer"}`, `1.5`, []string{"/: expected integer, got number"}},
+		{"type list", `{"type":["string","null"]}`, `null`, nil},
+		{"type list mismatch", `{"type":["string","null"]}`, `true`, []string{"/: expected string or null, got boolean"}},
+		{"boolean schema true", `true`, `{"a":1}`, nil},
+		{"boolean schema false", `false`, `1`, []string{"/: no value is allowed"}},
+
+		// required
+		{"required", `{"type":"object","required":["a","b"]}`, `{"a":1,"b":2}`, nil},
+		{"required missing", `{"type":"object","required":["a","b"]}`, `{"a":1}`,
+			[]string{`/: missing the required property "b"`}},
+		{"dependent required", `{"dependentRequired":{"a":["b"]}}`, `{"a":1}`,
+			[]string{`/: missing the property "b" required by "a"`}},
+
+		// enum and const
+		{"enum", `{"enum":["a",1,{"x":null}]}`, `{"x":null}`, nil},
+		{"enum mismatch", `{"enum":["a",1]}`, `"b"`, []string{`/: should be one of ["a",1]`}},
+		{"const", `{"const":[1,2]}`, `[1,2]`, nil},
+		{"const mismatch", `{"const":"a"}`, `"b"`, []string{`/: should be "a"`}},
+
+		// pattern and patternProperties
+		{"pattern", `{"pattern":"^a+$"}`, `"aaa"`, nil},
+		{"pattern mismatch", `{"pattern":"^a+$"}`, `"ab"`, []string{`/: should match the pattern "^a+$"`}},
+		{"pattern ignores non strings", `{"pattern":"^a+$"}`, `1`, nil},
+		{"pattern properties", `{"patternProperties":{"^x-":{"type":"string"}}}`, `{"x-a":"1","b":2}`, nil},
+		{"pattern properties mismatch", `{"patternProperties":{"^x-":{"type":"string"}}}`, `{"x-a":1}`,
+			[]string{"/x-a: expected string, got number"}},
+
+		// additionalProperties
+		{"additional false", `{"properties":{"a":{}},"additionalProperties":false}`, `{"a":1,"b":2}`,
+			[]string{`/: the property "b" is not allowed`}},
+		{"additional false with patterns", `{"properties":{"a":{}},"patternProperties":{"^x":{}},"additionalProperties":false}`,
+			`{"a":1,"xy":2}`, nil},
+		{"additional schema", `{"additionalProperties":{"type":"integer"}}`, `{"a":1,"b":"2"}`,
+			[]string{"/b: expected integer, got string"}},
+
+		// the nested paths
+		{"nested path", `{"properties":{"a/b":{"items":{"type":"number"}}}}`, `{"a/b":[1,"x"]}`,
+			[]string{"/a~1b/1: expected number, got string"}},
+
+		// numbers, strings and arrays
+		{"minimum", `{"minimum":1,"exclusiveMaximum":3}`, `3`, []string{"/: should be < 3"}},
+		{"multipleOf", `{"multipleOf":0.1}`, `0.3`, nil},
+		{"length", `{"minLength":2,"maxLength":3}`, `"日本語の"`, []string{"/: should have at most 3 characters"}},
+		{"unique", `{"uniqueItems":true}`, `[1,2,1]`, []string{"/: the items 0 and 2 should be unique"}},
+		{"prefix items", `{"prefixItems":[{"type":"string"}],"items":{"type":"number"}}`, `["a",1,"b"]`,
+			[]string{"/2: expected number, got string"}},
+		{"contains", `{"contains":{"type":"string"},"maxContains":1}`, `["a","b"]`,
+			[]string{"/: should contain at most 1 matched items"}},
+
+		// combinators
+		{"anyOf", `{"anyOf":[{"type":"string"},{"type":"number"}]}`, `true`,
+			[]string{"/: should match at least one schema of anyOf"}},
+		{"oneOf", `{"oneOf":[{"type":"number"},{"type":"integer"}]}`, `1`,
+			[]string{"/: should match exactly one schema of oneOf, matched 2"}},
+		{"not", `{"not":{"type":"null"}}`, `null`, []string{"/: should not match the schema of not"}},
+		{"if then else", `{"if":{"type":"string"},"then":{"minLength":2},"else":{"minimum":5}}`, `1`,
+			[]string{"/: should be >= 5"}},
+
+		// $ref
+		{"ref", `{"$defs":{"n":{"type":"number"}},"properties":{"a":{"$ref":"#/$defs/n"}}}`, `{"a":"x"}`,
+			[]string{"/a: expected number, got string"}},
+		{"ref not found", `{"$ref":"#/$defs/missing"}`, `1`, []string{`/: the reference "#/$defs/missing" is not found`}},
+		{"ref remote", `{"$ref":"http://example.com/s.json"}`, `1`,
+			[]string{`/: the remote reference "http://example.com/s.json" is not supported`}},
+		{"ref cycle", `{"$defs":{"a":{"$ref":"#/$defs/b"},"b":{"$ref":"#/$defs/a"}},"$ref":"#/$defs/a"}`, `1`,
+			[]string{"/: the references are expanded too deeply, which may be cyclic"}},
+		{"ref self", `{"$ref":"#"}`, `1`, []string{"/: the references are expanded too deeply, which may be cyclic"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := mustCompile(t, c.schema)
+			errs := s.ValidateJSON([]byte(c.value))
+			var got []string
+			for _, e := range errs {
+				got = append(got, e.Error())
+			}
+			if strings.Join(got, "\n") != strings.Join(c.errs, "\n") {
+				t.Errorf("errors:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(c.errs, "\n"))
+			}
+		})
+	}
+}
+
+// a recursive schema validates the values nested deeper than the
+// references could be expanded for a value
+func TestValidateDeepRecursive(t *testing.T) {
+	s := mustCompile(t, `{
+		"$defs": {"node": {"type": "object", "properties": {"child": {"$ref": "#/$defs/node"}}, "required": ["name"]}},
+		"$ref": "#/$defs/node"
+	}`)
+	const depth = 200
+	value := strings.Repeat(`{"name":"n","child":`, depth) + `{"name":"leaf"}` + strings.Repeat(`}`, depth)
+	if errs := s.ValidateJSON([]byte(value)); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs[0])
+	}
+
+	value = strings.Repeat(`{"name":"n","child":`, depth) + `{}` + strings.Repeat(`}`, depth)
+	errs := s.ValidateJSON([]byte(value))
+	if len(errs) != 1 || !strings.HasSuffix(errs[0].Path, "/child") ||
+		errs[0].Message != `missing the required property "name"` {
+		t.Fatalf("errors %v, want the missing name of the leaf", errs)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	cases := []struct {
+		name   string
+		schema string
+		err    string
+	}{
+		{"invalid json", `{`, "invalid schema"},
+		{"not an object", `[]`, "should be an object or a boolean"},
+		{"invalid pattern", `{"properties":{"a":{"pattern":"("}}}`, `invalid pattern "("`},
+		{"invalid pattern property", `{"patternProperties":{"(":{}}}`, `invalid pattern "("`},
+		{"invalid pattern in defs", `{"$defs":{"a":{"items":{"pattern":"["}}}}`, `invalid pattern "["`},
+		{"invalid pattern in anyOf", `{"anyOf":[true,{"pattern":"["}]}`, `invalid pattern "["`},
+		{"invalid pattern under a property named pattern", `{"properties":{"pattern":{"pattern":"("}}}`, `invalid pattern "("`},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := Compile("test", []byte(c.schema))
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("err %v, want %q", err, c.err)
+			}
+		})
+	}
+}
+
+// the data like enum and examples are not taken as the schema
+func TestCompileSkipsData(t *testing.T) {
+	schemas := []string{
+		`{"enum":[{"pattern":"("}]}`,
+		`{"const":{"pattern":"("}}`,
+		`{"default":{"pattern":"(","patternProperties":{"(":{}}}}`,
+		`{"examples":[{"pattern":"("}]}`,
+		`{"properties":{"a":{"examples":[{"pattern":"["}]}}}`,
+	}
+	for _, schema := range schemas {
+		if _, err := Compile("test", []byte(schema)); err != nil {
+			t.Errorf("compile %s: %v", schema, err)
+		}
+	}
+
+	// the property named pattern is a schema
+	s := mustCompile(t, `{"properties":{"pattern":{"pattern":"^a"}}}`)
+	if errs := s.ValidateJSON([]byte(`{"pattern":"b"}`)); len(errs) != 1 {
+		t.Errorf("errors %v, want the pattern mismatched", errs)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "my schema.json")
+	if err := os.WriteFile(file, []byte(`{"type":"object"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := Load(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Name() != "my_schema" {
+		t.Errorf("name %q, want my_schema", s.Name())
+	}
+	if !json.Valid(s.Raw()) {
+		t.Errorf("raw %s is not json", s.Raw())
+	}
+
+	os.WriteFile(file, []byte(`{"title":"Weather Report!"}`), 0644)
+	if s, _ = Load(file); s.Name() != "Weather_Report" {
+		t.Errorf("name %q, want Weather_Report", s.Name())
+	}
+	if _, err := Load(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("no error for the missing file")
+	}
+}
+
+func TestValidateInvalidJSON(t *testing.T) {
+	s := mustCompile(t, `{}`)
+	errs := s.ValidateJSON([]byte(`{"a":`))
+	if len(errs) != 1 || !strings.HasPrefix(errs[0].Message, "invalid json") {
+		t.Errorf("errors %v, want invalid json", errs)
+	}
+}
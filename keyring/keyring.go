@@ -0,0 +1,171 @@
+// Package keyring keeps the secrets in a local file encrypted by the key
+// derived from a passphrase with scrypt
+package keyring
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// ErrPassphrase is returned if the keyring can not be decrypted
+var ErrPassphrase = errors.New("wrong passphrase or the keyring is corrupted")
+
+// the scrypt parameters recommended for interactive logins
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+	keyLen  = 32
+
+	// the bounds of the parameters read from the file
+	minScryptN = 1 << 10
+	maxScryptN = 1 << 20
+	maxScryptR = 32
+	maxScryptP = 16
+)
+
+// file is the format of the keyring file, data is the json of the secrets
+// encrypted by AES-256-GCM
+type file struct {
+	Version int    `json:"version"`
+	N       int    `json:"n"`
+	R       int    `json:"r"`
+	P       int    `json:"p"`
+	Salt    []byte `json:"salt"`
+	Nonce   []byte `json:"nonce"`
+	Data    []byte `json:"data"`
+}
+
+type Keyring struct {
+	filename   string
+	passphrase []byte
+	secrets    map[string]string
+}
+
+// Exists reports whether the keyring file exists
+func Exists(filename string) bool {
+	_, err := os.Stat(filename)
+	return err == nil
+}
+
+// Open decrypts the keyring, an empty keyring is returned if the file does
+// not exist, which is created by Save
+func Open(filename string, passphrase []byte) (*Keyring, error) {
+	k := &Keyring{filename: filename, passphrase: passphrase, secrets: make(map[string]string)}
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return k, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	f := &file{}
+	if err := json.Unmarshal(data, f); err != nil {
+		return nil, fmt.Errorf("invalid keyring %s: %w", filename, err)
+	}
+	if f.Version != 1 {
+		return nil, fmt.Errorf("unsupported keyring version %d", f.Version)
+	}
+	// the parameters are bounded, so a crafted file can not make the key
+	// derivation take all the memory or time
+	if f.N < minScryptN || f.N > maxScryptN || f.N&(f.N-1) != 0 ||
+		f.R < 1 || f.R > maxScryptR || f.P < 1 || f.P > maxScryptP {
+		return nil, fmt.Errorf("invalid keyring %s: unsupported scrypt parameters n=%d r=%d p=%d", filename, f.N, f.R, f.P)
+	}
+	gcm, err := newGCM(passphrase, f.Salt, f.N, f.R, f.P)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := gcm.Open(nil, f.Nonce, f.Data, nil)
+	if err != nil {
+		return nil, ErrPassphrase
+	}
+	if err := json.Unmarshal(plain, &k.secrets); err != nil {
+		return nil, ErrPassphrase
+	}
+	return k, nil
+}
+
+func newGCM(passphrase, salt []byte, n, r, p int) (cipher.AEAD, error) {
+	key, err := scrypt.Key(passphrase, salt, n, r, p, keyLen)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (k *Keyring) Get(name string) (string, bool) {
+	s, ok := k.secrets[name]
+	return s, ok
+}
+
+func (k *Keyring) Set(name, secret string) {
+	k.secrets[name] = secret
+}
+
+func (k *Keyring) Delete(name string) {
+	delete(k.secrets, name)
+}
+
+// Names returns the names of the secrets in order
+func (k *Keyring) Names() []string {
+	var names []string
+	for name := range k.secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Save encrypts the secrets with a new salt and nonce, the file is only
+// readable by the owner
+func (k *Keyring) Save() error {
+	plain, err := json.Marshal(k.secrets)
+	if err != nil {
+		return err
+	}
+	f := &file{Version: 1, N: scryptN, R: scryptR, P: scryptP,
+		Salt: make([]byte, 16)}
+	if _, err := rand.Read(f.Salt); err != nil {
+		return err
+	}
+	gcm, err := newGCM(k.passphrase, f.Salt, f.N, f.R, f.P)
+	if err != nil {
+		return err
+	}
+	f.Nonce = make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(f.Nonce); err != nil {
+		return err
+	}
+	f.Data = gcm.Seal(nil, f.Nonce, plain, nil)
+
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(k.filename), 0700); err != nil {
+		return err
+	}
+	// write to a temporary file and rename, so the keyring is never half written
+	tmp := k.filename + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, k.filename)
+}
@@ -0,0 +1,90 @@
+package keyring
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "guru", "keyring")
+	if Exists(filename) {
+		t.Fatal("the keyring exists before saved")
+	}
+	k, err := Open(filename, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.Set("openai", "sk-openai")
+	k.Set("azure", "sk-azure")
+	k.Set("gone", "sk-gone")
+	k.Delete("gone")
+	if err := k.Save(); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("the keyring is %v, want 0600", info.Mode().Perm())
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "sk-openai") {
+		t.Errorf("the secret is saved in plain text: %s", data)
+	}
+
+	k, err = Open(filename, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, ok := k.Get("openai"); !ok || s != "sk-openai" {
+		t.Errorf("openai is %q, %v, want sk-openai", s, ok)
+	}
+	if names := strings.Join(k.Names(), ","); names != "azure,openai" {
+		t.Errorf("the names are %s, want azure,openai", names)
+	}
+}
+
+func TestWrongPassphrase(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "keyring")
+	k, err := Open(filename, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.Set("openai", "sk-openai")
+	if err := k.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(filename, []byte("wrong")); !errors.Is(err, ErrPassphrase) {
+		t.Errorf("open by the wrong passphrase: %v, want ErrPassphrase", err)
+	}
+}
+
+func TestScryptBounds(t *testing.T) {
+	for _, f := range []file{
+		{Version: 1, N: 1 << 30, R: scryptR, P: scryptP},
+		{Version: 1, N: 3 << 12, R: scryptR, P: scryptP},
+		{Version: 1, N: 1 << 4, R: scryptR, P: scryptP},
+		{Version: 1, N: scryptN, R: 1 << 20, P: scryptP},
+		{Version: 1, N: scryptN, R: scryptR, P: 0},
+	} {
+		data, err := json.Marshal(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		filename := filepath.Join(t.TempDir(), "keyring")
+		if err := os.WriteFile(filename, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Open(filename, []byte("passphrase")); err == nil || !strings.Contains(err.Error(), "scrypt parameters") {
+			t.Errorf("n=%d r=%d p=%d: %v, want the parameters rejected", f.N, f.R, f.P, err)
+		}
+	}
+}
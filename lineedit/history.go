@@ -0,0 +1,138 @@
+package lineedit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// History is the input history kept in a file, each entry is a json string
+// in a line, so the entries could have newlines
+type History struct {
+	file    string
+	max     int
+	entries []string
+}
+
+// OpenHistory loads the last max entries of the file, the history is kept
+// in memory only if file is empty, it is usable even if an error is returned
+func OpenHistory(file string, max int) (*History, error) {
+	h := &History{file: file, max: max}
+	if file == "" {
+		return h, nil
+	}
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return h, nil
+	} else if err != nil {
+		return h, err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	s.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	total := 0
+	for s.Scan() {
+		var entry string
+		if err := json.Unmarshal(s.Bytes(), &entry); err != nil {
+			continue
+		}
+		h.entries = append(h.entries, entry)
+		total++
+	}
+	if err := s.Err(); err != nil {
+		return h, err
+	}
+	if len(h.entries) > max {
+		h.entries = h.entries[len(h.entries)-max:]
+	}
+	// compact the file if it grows too large
+	if total > 2*max {
+		return h, h.rewrite()
+	}
+	return h, nil
+}
+
+func (h *History) Len() int {
+	return len(h.entries)
+}
+
+// Entry returns the ith entry, the oldest is 0
+func (h *History) Entry(i int) string {
+	return h.entries[i]
+}
+
+// Add appends the entry, the empty one or the one same as the last is ignored
+func (h *History) Add(entry string) error {
+	if strings.TrimSpace(entry) == "" {
+		return nil
+	}
+	if n := len(h.entries); n > 0 && h.entries[n-1] == entry {
+		return nil
+	}
+	h.entries = append(h.entries, entry)
+	if len(h.entries) > h.max {
+		h.entries = h.entries[1:]
+	}
+	if h.file == "" {
+		return nil
+	}
+
+	data, err := encode(entry)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(h.file), 0700); err != nil {
+		return err
+	}
+	// the history may have secrets typed, so it is only readable by the owner
+	f, err := os.OpenFile(h.file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(data)
+	return err
+}
+
+// encode returns the entry as a json string ending with a newline, the
+// characters like > are kept as is to be readable
+func encode(entry string) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(entry); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (h *History) rewrite() error {
+	var buf bytes.Buffer
+	for _, entry := range h.entries {
+		data, err := encode(entry)
+		if err != nil {
+			return err
+		}
+		buf.Write(data)
+	}
+	tmp := h.file + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, h.file)
+}
+
+// search finds the entry containing the query backwards from the ith, the
+// index of the entry and the rune offset of the query in it are returned
+func (h *History) search(query string, i int) (int, int) {
+	for ; i >= 0; i-- {
+		if off := strings.Index(h.entries[i], query); off >= 0 {
+			return i, len([]rune(h.entries[i][:off]))
+		}
+	}
+	return -1, 0
+}
@@ -0,0 +1,92 @@
+package lineedit
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHistoryCompaction(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "history")
+	h, err := OpenHistory(file, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 25; i++ {
+		if err := h.Add(fmt.Sprintf("entry %d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if h.Len() != 10 || h.Entry(0) != "entry 15" {
+		t.Errorf("%d entries from %q, want the last 10", h.Len(), h.Entry(0))
+	}
+
+	// the file keeps every entry until it is loaded again
+	data, _ := os.ReadFile(file)
+	if n := bytes.Count(data, []byte("\n")); n != 25 {
+		t.Errorf("%d lines in the file, want 25", n)
+	}
+	h, err = OpenHistory(file, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Len() != 10 || h.Entry(0) != "entry 15" || h.Entry(9) != "entry 24" {
+		t.Errorf("%d entries from %q, want the last 10", h.Len(), h.Entry(0))
+	}
+	data, _ = os.ReadFile(file)
+	if n := bytes.Count(data, []byte("\n")); n != 10 {
+		t.Errorf("%d lines in the file after compacted, want 10", n)
+	}
+	if fi, err := os.Stat(file); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("the file mode %v, err %v, want 0600", fi.Mode().Perm(), err)
+	}
+}
+
+func TestHistoryEntries(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "dir", "history")
+	h, _ := OpenHistory(file, 100)
+	entries := []string{"first line\nsecond line", `say "hi" <b>`, "  ", "tab\tand \\n", "tab\tand \\n"}
+	for _, entry := range entries {
+		if err := h.Add(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a broken line is skipped
+	f, _ := os.OpenFile(file, os.O_APPEND|os.O_WRONLY, 0600)
+	f.WriteString("\"broken\n")
+	f.Close()
+
+	want := []string{"first line\nsecond line", `say "hi" <b>`, "tab\tand \\n"}
+	for _, h := range []*History{h, mustOpenHistory(t, file, 100)} {
+		if h.Len() != len(want) {
+			t.Fatalf("%d entries, want %d", h.Len(), len(want))
+		}
+		for i, entry := range want {
+			if h.Entry(i) != entry {
+				t.Errorf("entry %d is %q, want %q", i, h.Entry(i), entry)
+			}
+		}
+	}
+	data, _ := os.ReadFile(file)
+	if !bytes.Contains(data, []byte(`<b>`)) {
+		t.Errorf("the entry is escaped in the file:\n%s", data)
+	}
+
+	if i, off := h.search("line", h.Len()-1); i != 0 || off != 6 {
+		t.Errorf("found %d at %d, want 0 at 6", i, off)
+	}
+	if i, _ := h.search("missing", h.Len()-1); i != -1 {
+		t.Errorf("found %d, want -1", i)
+	}
+}
+
+func mustOpenHistory(t *testing.T, file string, max int) *History {
+	t.Helper()
+	h, err := OpenHistory(file, max)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
@@ -0,0 +1,185 @@
+package lineedit
+
+import (
+	"bytes"
+	"strings"
+	"unicode/utf8"
+)
+
+type keyCode int
+
+const (
+	keyRune keyCode = iota
+	keyCtrl         // a control character like Ctrl+A
+	keyEnter
+	keyNewline // Alt+Enter, Shift+Enter or Ctrl+J
+	keyBackspace
+	keyDelete
+	keyLeft
+	keyRight
+	keyUp
+	keyDown
+	keyHome
+	keyEnd
+	keyWordLeft
+	keyWordRight
+	keyDeleteWord  // Alt+Backspace
+	keyDeleteWordF // Alt+D
+	keyTab
+	keyEsc
+	keyPaste
+	keyUnknown
+)
+
+type key struct {
+	code keyCode
+	r    rune   // the rune of keyRune or the character of keyCtrl
+	text string // the text of keyPaste
+}
+
+const (
+	pasteStart = "\x1b[200~"
+	pasteEnd   = "\x1b[201~"
+)
+
+// parseKey parses the first key of b, ok is false if b is incomplete
+func parseKey(b []byte) (k key, n int, ok bool) {
+	if len(b) == 0 {
+		return key{}, 0, false
+	}
+	switch c := b[0]; {
+	case c == 0x1b:
+		return parseEscape(b)
+	case c == '\r':
+		return key{code: keyEnter}, 1, true
+	case c == '\n':
+		return key{code: keyNewline}, 1, true
+	case c == 0x7f || c == 0x08:
+		return key{code: keyBackspace}, 1, true
+	case c == '\t':
+		return key{code: keyTab}, 1, true
+	case c < 0x20:
+		return key{code: keyCtrl, r: rune(c)}, 1, true
+	}
+	if !utf8.FullRune(b) {
+		return key{}, 0, false
+	}
+	r, size := utf8.DecodeRune(b)
+	return key{code: keyRune, r: r}, size, true
+}
+
+// parseEscape parses the sequences beginning with Esc, a lone Esc is
+// incomplete, which is taken as the key by the reader if nothing follows
+func parseEscape(b []byte) (key, int, bool) {
+	if len(b) < 2 {
+		return key{}, 0, false
+	}
+	switch b[1] {
+	case '[':
+		return parseCSI(b)
+	case 'O':
+		if len(b) < 3 {
+			return key{}, 0, false
+		}
+		switch b[2] {
+		case 'A':
+			return key{code: keyUp}, 3, true
+		case 'B':
+			return key{code: keyDown}, 3, true
+		case 'C':
+			return key{code: keyRight}, 3, true
+		case 'D':
+			return key{code: keyLeft}, 3, true
+		case 'H':
+			return key{code: keyHome}, 3, true
+		case 'F':
+			return key{code: keyEnd}, 3, true
+		}
+		return key{code: keyUnknown}, 3, true
+	case '\r', '\n':
+		return key{code: keyNewline}, 2, true
+	case 'b', 'B':
+		return key{code: keyWordLeft}, 2, true
+	case 'f', 'F':
+		return key{code: keyWordRight}, 2, true
+	case 'd', 'D':
+		return key{code: keyDeleteWordF}, 2, true
+	case 0x7f, 0x08:
+		return key{code: keyDeleteWord}, 2, true
+	case 0x1b:
+		return key{code: keyEsc}, 1, true
+	}
+	return key{code: keyEsc}, 1, true
+}
+
+// parseCSI parses the sequences like ESC [ 1 ; 5 C
+func parseCSI(b []byte) (key, int, bool) {
+	i := 2
+	for i < len(b) && (b[i] < 0x40 || b[i] > 0x7e) {
+		i++
+	}
+	if i == len(b) {
+		// a broken sequence should not block the input forever
+		if len(b) > 32 {
+			return key{code: keyUnknown}, len(b), true
+		}
+		return key{}, 0, false
+	}
+	params, final, n := string(b[2:i]), b[i], i+1
+
+	// the pasted text is kept as is until the end of pasting
+	if final == '~' && params == "200" {
+		end := bytes.Index(b[n:], []byte(pasteEnd))
+		if end < 0 {
+			return key{}, 0, false
+		}
+		text := string(b[n : n+end])
+		text = strings.NewReplacer("\r\n", "\n", "\r", "\n").Replace(text)
+		return key{code: keyPaste, text: text}, n + end + len(pasteEnd), true
+	}
+
+	// the modifiers like 1;5C for Ctrl+Right
+	modifier := ""
+	if _, m, ok := strings.Cut(params, ";"); ok {
+		modifier = m
+	}
+	word := modifier == "3" || modifier == "5"
+	switch final {
+	case 'A':
+		return key{code: keyUp}, n, true
+	case 'B':
+		return key{code: keyDown}, n, true
+	case 'C':
+		if word {
+			return key{code: keyWordRight}, n, true
+		}
+		return key{code: keyRight}, n, true
+	case 'D':
+		if word {
+			return key{code: keyWordLeft}, n, true
+		}
+		return key{code: keyLeft}, n, true
+	case 'H':
+		return key{code: keyHome}, n, true
+	case 'F':
+		return key{code: keyEnd}, n, true
+	case 'u':
+		// the keys reported by the kitty protocol, like 13;2u for Shift+Enter
+		if params == "13;2" || params == "13;3" {
+			return key{code: keyNewline}, n, true
+		}
+	case '~':
+		switch params {
+		case "1", "7":
+			return key{code: keyHome}, n, true
+		case "4", "8":
+			return key{code: keyEnd}, n, true
+		case "3":
+			return key{code: keyDelete}, n, true
+		case "27;2;13", "27;3;13":
+			// Shift+Enter or Alt+Enter reported by xterm's modifyOtherKeys
+			return key{code: keyNewline}, n, true
+		}
+	}
+	return key{code: keyUnknown}, n, true
+}
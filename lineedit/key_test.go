@@ -0,0 +1,62 @@
+package lineedit
+
+import "testing"
+
+func TestParseKey(t *testing.T) {
+	cases := []struct {
+		name  string
+		input string
+		want  key
+		n     int
+		ok    bool
+	}{
+		{"rune", "a", key{code: keyRune, r: 'a'}, 1, true},
+		{"multibyte rune", "中文", key{code: keyRune, r: '中'}, 3, true},
+		{"incomplete rune", "\xe4\xb8", key{}, 0, false},
+		{"enter", "\r", key{code: keyEnter}, 1, true},
+		{"ctrl+j", "\n", key{code: keyNewline}, 1, true},
+		{"ctrl+a", "\x01", key{code: keyCtrl, r: ctrl('a')}, 1, true},
+		{"lone esc", "\x1b", key{}, 0, false},
+		{"esc esc", "\x1b\x1b", key{code: keyEsc}, 1, true},
+		{"alt+b", "\x1bb", key{code: keyWordLeft}, 2, true},
+		{"alt+enter", "\x1b\r", key{code: keyNewline}, 2, true},
+		{"alt+backspace", "\x1b\x7f", key{code: keyDeleteWord}, 2, true},
+		{"ss3 up", "\x1bOA", key{code: keyUp}, 3, true},
+		{"right", "\x1b[C", key{code: keyRight}, 3, true},
+		{"ctrl+right", "\x1b[1;5C", key{code: keyWordRight}, 6, true},
+		{"alt+left", "\x1b[1;3D", key{code: keyWordLeft}, 6, true},
+		{"shift+right", "\x1b[1;2C", key{code: keyRight}, 6, true},
+		{"incomplete csi", "\x1b[1;5", key{}, 0, false},
+		{"delete", "\x1b[3~", key{code: keyDelete}, 4, true},
+		{"home", "\x1b[1~", key{code: keyHome}, 4, true},
+		{"kitty shift+enter", "\x1b[13;2u", key{code: keyNewline}, 7, true},
+		{"kitty alt+enter", "\x1b[13;3u", key{code: keyNewline}, 7, true},
+		{"xterm shift+enter", "\x1b[27;2;13~", key{code: keyNewline}, 10, true},
+		{"unknown csi", "\x1b[99X", key{code: keyUnknown}, 5, true},
+		{"paste", pasteStart + "a\r\nb\rc" + pasteEnd + "x", key{code: keyPaste, text: "a\nb\nc"}, 18, true},
+		{"paste not ended", pasteStart + "hello" + pasteEnd[:3], key{}, 0, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			k, n, ok := parseKey([]byte(c.input))
+			if k != c.want || n != c.n || ok != c.ok {
+				t.Errorf("got %+v, %d, %v, want %+v, %d, %v", k, n, ok, c.want, c.n, c.ok)
+			}
+		})
+	}
+}
+
+func TestParsePasteSplit(t *testing.T) {
+	// the pasted text arrives in pieces, it is a key only if the end of
+	// pasting is read
+	input := pasteStart + "line one\r\nline two" + pasteEnd
+	for i := 1; i < len(input); i++ {
+		if k, _, ok := parseKey([]byte(input[:i])); ok {
+			t.Fatalf("the key %+v is parsed from the first %d bytes", k, i)
+		}
+	}
+	k, n, ok := parseKey([]byte(input))
+	if !ok || n != len(input) || k.code != keyPaste || k.text != "line one\nline two" {
+		t.Errorf("got %+v, %d, %v", k, n, ok)
+	}
+}
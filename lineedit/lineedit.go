@@ -0,0 +1,757 @@
+// Package lineedit reads the input from the terminal like readline, but the
+// input could have more than one line: Alt+Enter, Shift+Enter or Ctrl+J
+// inserts a newline, and the pasted text is kept as a whole. The history is
+// searched by Ctrl+R, and Ctrl+X Ctrl+E edits the input in $EDITOR.
+package lineedit
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/mattn/go-runewidth"
+	"github.com/muesli/reflow/ansi"
+	"golang.org/x/term"
+)
+
+// ErrInterrupt is returned when Ctrl+C is pressed on an empty input
+var ErrInterrupt = errors.New("interrupted")
+
+const tabWidth = 4
+
+type Config struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+
+	// Width returns the width of the terminal, 80 is used if nil
+	Width func() int
+
+	// AutoComplete returns the candidates to insert at pos, and the length
+	// of the text before pos they complete
+	AutoComplete func(line []rune, pos int) ([][]rune, int)
+}
+
+type Editor struct {
+	cfg     Config
+	prompt  string
+	history *History
+
+	buf       []rune
+	pos       int
+	cursorRow int // the row of the cursor from the first row of the prompt
+
+	// the index of the history browsed, and the input before browsing
+	histIdx int
+	draft   []rune
+
+	// the reverse search of the history
+	searching bool
+	failed    bool
+	query     []rune
+	searchIdx int
+	saved     []rune
+	savedPos  int
+
+	ctrlX   bool   // Ctrl+X is pressed, waiting for Ctrl+E
+	pending []byte // the input read but not handled
+	rbuf    []byte
+	reading chan readResult // the read not finished in time, nil if none
+
+	// the terminal in the raw mode, nil if the input is not a local terminal
+	tty   *os.File
+	state *term.State
+}
+
+func New(cfg Config) *Editor {
+	if cfg.Stdin == nil {
+		cfg.Stdin = os.Stdin
+	}
+	if cfg.Stdout == nil {
+		cfg.Stdout = os.Stdout
+	}
+	return &Editor{cfg: cfg, history: &History{max: 1000}, rbuf: make([]byte, 4096)}
+}
+
+func (e *Editor) SetPrompt(prompt string) {
+	e.prompt = prompt
+}
+
+// SetHistory sets the history browsed and searched
+func (e *Editor) SetHistory(h *History) {
+	e.history = h
+}
+
+// Readline reads the input until Enter is pressed, io.EOF is returned if
+// Ctrl+D is pressed on an empty input, and ErrInterrupt for Ctrl+C
+func (e *Editor) Readline() (string, error) {
+	// the remote terminal like ssh is in the raw mode already
+	if f, ok := e.cfg.Stdin.(*os.File); ok && term.IsTerminal(int(f.Fd())) {
+		state, err := term.MakeRaw(int(f.Fd()))
+		if err != nil {
+			return "", err
+		}
+		e.tty, e.state = f, state
+		defer func() {
+			term.Restore(int(f.Fd()), state)
+			e.tty, e.state = nil, nil
+		}()
+	}
+	e.write(enablePaste)
+	defer e.write(disablePaste)
+
+	e.buf, e.pos, e.cursorRow = nil, 0, 0
+	e.histIdx, e.draft = e.history.Len(), nil
+	e.searching, e.ctrlX = false, false
+	e.render(false)
+	for {
+		k, err := e.readKey()
+		if err != nil {
+			e.render(true)
+			return "", err
+		}
+		if done, line, err := e.handle(k); done {
+			return line, err
+		}
+	}
+}
+
+const (
+	enablePaste  = "\x1b[?2004h"
+	disablePaste = "\x1b[?2004l"
+)
+
+func (e *Editor) write(s string) {
+	io.WriteString(e.cfg.Stdout, s)
+}
+
+func (e *Editor) width() int {
+	if e.cfg.Width != nil {
+		if w := e.cfg.Width(); w > 0 {
+			return w
+		}
+	}
+	return 80
+}
+
+// escTimeout is the time to wait for the rest of a sequence after Esc, the
+// Esc is taken as the key itself if nothing follows
+var escTimeout = 100 * time.Millisecond
+
+// readKey returns the next key, more input is read if the key is incomplete
+func (e *Editor) readKey() (key, error) {
+	for {
+		if k, n, ok := parseKey(e.pending); ok {
+			e.pending = e.pending[n:]
+			return k, nil
+		}
+		// a lone Esc could not be told from the beginning of Alt+B
+		// until the next byte or the timeout
+		var timeout time.Duration
+		if len(e.pending) == 1 && e.pending[0] == 0x1b {
+			timeout = escTimeout
+		}
+		n := len(e.pending)
+		read, err := e.read(timeout)
+		if !read {
+			e.pending = e.pending[1:]
+			return key{code: keyEsc}, nil
+		}
+		if len(e.pending) > n {
+			continue
+		}
+		if err != nil {
+			return key{}, err
+		}
+	}
+}
+
+type readResult struct {
+	data []byte
+	err  error
+}
+
+// read reads the input into pending, read is false if it is not done in
+// timeout, and the read is finished by the next call. It waits without a
+// limit if timeout is 0
+func (e *Editor) read(timeout time.Duration) (read bool, err error) {
+	if e.reading == nil && timeout == 0 {
+		n, err := e.cfg.Stdin.Read(e.rbuf)
+		e.pending = append(e.pending, e.rbuf[:n]...)
+		return true, err
+	}
+	if e.reading == nil {
+		// the reader could not be interrupted, so it reads in another
+		// goroutine
+		ch := make(chan readResult, 1)
+		go func() {
+			buf := make([]byte, len(e.rbuf))
+			n, err := e.cfg.Stdin.Read(buf)
+			ch <- readResult{data: buf[:n], err: err}
+		}()
+		e.reading = ch
+	}
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case r := <-e.reading:
+		e.reading = nil
+		e.pending = append(e.pending, r.data...)
+		return true, r.err
+	case <-expired:
+		return false, nil
+	}
+}
+
+func ctrl(c byte) rune {
+	return rune(c & 0x1f)
+}
+
+// handle handles the key, done is true if the input is finished
+func (e *Editor) handle(k key) (done bool, line string, err error) {
+	if e.searching {
+		if done, line, err, handled := e.handleSearch(k); handled {
+			return done, line, err
+		}
+	}
+	ctrlX := e.ctrlX
+	e.ctrlX = false
+
+	switch k.code {
+	case keyRune:
+		e.insert([]rune{k.r})
+	case keyPaste:
+		e.insert([]rune(k.text))
+	case keyNewline:
+		e.insert([]rune{'\n'})
+	case keyEnter:
+		// the lines are pasted if more input follows Enter immediately,
+		// which happens if the terminal does not support bracketed paste
+		if len(e.pending) > 0 {
+			e.insert([]rune{'\n'})
+			break
+		}
+		// a line ending with \ is continued
+		if e.pos == len(e.buf) && e.pos > 0 && e.buf[e.pos-1] == '\\' {
+			e.buf[e.pos-1] = '\n'
+			break
+		}
+		e.pos = len(e.buf)
+		e.render(true)
+		return true, string(e.buf), nil
+	case keyBackspace:
+		if e.pos > 0 {
+			e.delete(e.pos-1, e.pos)
+		}
+	case keyDelete:
+		if e.pos < len(e.buf) {
+			e.delete(e.pos, e.pos+1)
+		}
+	case keyLeft:
+		if e.pos > 0 {
+			e.pos--
+		}
+	case keyRight:
+		if e.pos < len(e.buf) {
+			e.pos++
+		}
+	case keyUp:
+		if !e.lineUp() {
+			e.historyPrev()
+		}
+	case keyDown:
+		if !e.lineDown() {
+			e.historyNext()
+		}
+	case keyHome:
+		e.pos = e.lineStart(e.pos)
+	case keyEnd:
+		e.pos = e.lineEnd(e.pos)
+	case keyWordLeft:
+		e.pos = e.wordLeft()
+	case keyWordRight:
+		e.pos = e.wordRight()
+	case keyDeleteWord:
+		e.delete(e.wordLeft(), e.pos)
+	case keyDeleteWordF:
+		e.delete(e.pos, e.wordRight())
+	case keyTab:
+		e.complete()
+	case keyCtrl:
+		switch k.r {
+		case ctrl('a'):
+			e.pos = e.lineStart(e.pos)
+		case ctrl('e'):
+			if ctrlX {
+				e.external()
+				break
+			}
+			e.pos = e.lineEnd(e.pos)
+		case ctrl('b'):
+			if e.pos > 0 {
+				e.pos--
+			}
+		case ctrl('f'):
+			if e.pos < len(e.buf) {
+				e.pos++
+			}
+		case ctrl('p'):
+			if !e.lineUp() {
+				e.historyPrev()
+			}
+		case ctrl('n'):
+			if !e.lineDown() {
+				e.historyNext()
+			}
+		case ctrl('c'):
+			if len(e.buf) == 0 {
+				e.render(true)
+				return true, "", ErrInterrupt
+			}
+			// drop the input and start over
+			e.pos = len(e.buf)
+			e.render(false)
+			e.write("^C\r\n")
+			e.cursorRow = 0
+			e.buf, e.pos = nil, 0
+			e.histIdx, e.draft = e.history.Len(), nil
+		case ctrl('d'):
+			if len(e.buf) == 0 {
+				e.render(true)
+				return true, "", io.EOF
+			}
+			if e.pos < len(e.buf) {
+				e.delete(e.pos, e.pos+1)
+			}
+		case ctrl('k'):
+			end := e.lineEnd(e.pos)
+			// join the next line at the end of the line
+			if end == e.pos && end < len(e.buf) {
+				end++
+			}
+			e.delete(e.pos, end)
+		case ctrl('u'):
+			e.delete(e.lineStart(e.pos), e.pos)
+		case ctrl('w'):
+			start := e.pos
+			for start > 0 && unicode.IsSpace(e.buf[start-1]) {
+				start--
+			}
+			for start > 0 && !unicode.IsSpace(e.buf[start-1]) {
+				start--
+			}
+			e.delete(start, e.pos)
+		case ctrl('l'):
+			e.write("\x1b[H\x1b[2J")
+			e.cursorRow = 0
+		case ctrl('r'):
+			e.startSearch()
+		case ctrl('x'):
+			e.ctrlX = true
+		}
+	}
+	e.render(false)
+	return false, "", nil
+}
+
+// insert inserts the text at the cursor, the control characters except
+// newlines and tabs are dropped
+func (e *Editor) insert(text []rune) {
+	var runes []rune
+	for _, r := range text {
+		if r == '\n' || r == '\t' || !unicode.IsControl(r) {
+			runes = append(runes, r)
+		}
+	}
+	buf := make([]rune, 0, len(e.buf)+len(runes))
+	buf = append(buf, e.buf[:e.pos]...)
+	buf = append(buf, runes...)
+	e.buf = append(buf, e.buf[e.pos:]...)
+	e.pos += len(runes)
+}
+
+func (e *Editor) delete(start, end int) {
+	if start >= end {
+		return
+	}
+	e.buf = append(e.buf[:start], e.buf[end:]...)
+	e.pos = start
+}
+
+// lineStart returns the start of the line at i
+func (e *Editor) lineStart(i int) int {
+	for i > 0 && e.buf[i-1] != '\n' {
+		i--
+	}
+	return i
+}
+
+// lineEnd returns the end of the line at i, which is the newline or the end
+func (e *Editor) lineEnd(i int) int {
+	for i < len(e.buf) && e.buf[i] != '\n' {
+		i++
+	}
+	return i
+}
+
+// lineUp moves the cursor to the previous line, false if it is the first line
+func (e *Editor) lineUp() bool {
+	start := e.lineStart(e.pos)
+	if start == 0 {
+		return false
+	}
+	col := e.pos - start
+	prev := e.lineStart(start - 1)
+	if col > start-1-prev {
+		col = start - 1 - prev
+	}
+	e.pos = prev + col
+	return true
+}
+
+// lineDown moves the cursor to the next line, false if it is the last line
+func (e *Editor) lineDown() bool {
+	end := e.lineEnd(e.pos)
+	if end == len(e.buf) {
+		return false
+	}
+	col := e.pos - e.lineStart(e.pos)
+	next := end + 1
+	if n := e.lineEnd(next) - next; col > n {
+		col = n
+	}
+	e.pos = next + col
+	return true
+}
+
+func (e *Editor) wordLeft() int {
+	i := e.pos
+	for i > 0 && !isWord(e.buf[i-1]) {
+		i--
+	}
+	for i > 0 && isWord(e.buf[i-1]) {
+		i--
+	}
+	return i
+}
+
+func (e *Editor) wordRight() int {
+	i := e.pos
+	for i < len(e.buf) && !isWord(e.buf[i]) {
+		i++
+	}
+	for i < len(e.buf) && isWord(e.buf[i]) {
+		i++
+	}
+	return i
+}
+
+func isWord(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+func (e *Editor) historyPrev() {
+	if e.histIdx == 0 {
+		return
+	}
+	if e.histIdx == e.history.Len() {
+		e.draft = append([]rune(nil), e.buf...)
+	}
+	e.histIdx--
+	e.buf = []rune(e.history.Entry(e.histIdx))
+	e.pos = len(e.buf)
+}
+
+func (e *Editor) historyNext() {
+	if e.histIdx >= e.history.Len() {
+		return
+	}
+	e.histIdx++
+	if e.histIdx == e.history.Len() {
+		e.buf = e.draft
+	} else {
+		e.buf = []rune(e.history.Entry(e.histIdx))
+	}
+	e.pos = len(e.buf)
+}
+
+func (e *Editor) startSearch() {
+	e.searching, e.failed, e.query = true, false, nil
+	e.saved, e.savedPos = append([]rune(nil), e.buf...), e.pos
+	e.searchIdx = e.history.Len()
+}
+
+// find searches the query backwards from the ith entry
+func (e *Editor) find(i int) {
+	if len(e.query) == 0 {
+		e.failed = false
+		return
+	}
+	if i > e.history.Len()-1 {
+		i = e.history.Len() - 1
+	}
+	idx, off := e.history.search(string(e.query), i)
+	if idx < 0 {
+		e.failed = true
+		return
+	}
+	e.failed, e.searchIdx = false, idx
+	e.buf, e.pos = []rune(e.history.Entry(idx)), off
+}
+
+// handleSearch handles the key in the reverse search, the keys other than
+// editing the query accept the entry found and are handled as usual
+func (e *Editor) handleSearch(k key) (done bool, line string, err error, handled bool) {
+	switch {
+	case k.code == keyRune || k.code == keyPaste:
+		if k.code == keyRune {
+			e.query = append(e.query, k.r)
+		} else {
+			e.query = append(e.query, []rune(k.text)...)
+		}
+		e.find(e.searchIdx)
+	case k.code == keyBackspace:
+		if len(e.query) > 0 {
+			e.query = e.query[:len(e.query)-1]
+		}
+		e.find(e.history.Len() - 1)
+	case k.code == keyCtrl && k.r == ctrl('r'):
+		e.find(e.searchIdx - 1)
+	case k.code == keyEsc || (k.code == keyCtrl && (k.r == ctrl('g') || k.r == ctrl('c'))):
+		e.searching = false
+		e.buf, e.pos = e.saved, e.savedPos
+	default:
+		e.searching = false
+		if e.searchIdx < e.history.Len() {
+			e.histIdx, e.draft = e.searchIdx, e.saved
+		}
+		return false, "", nil, false
+	}
+	e.render(false)
+	return false, "", nil, true
+}
+
+// complete inserts the only candidate or the common prefix of the
+// candidates, or lists the candidates if there is no common prefix
+func (e *Editor) complete() {
+	if e.cfg.AutoComplete == nil {
+		return
+	}
+	candidates, n := e.cfg.AutoComplete(append([]rune(nil), e.buf...), e.pos)
+	switch len(candidates) {
+	case 0:
+		return
+	case 1:
+		e.insert(candidates[0])
+		return
+	}
+	if prefix := commonPrefix(candidates); len(prefix) > 0 {
+		e.insert(prefix)
+		return
+	}
+	if n > e.pos {
+		n = e.pos
+	}
+	typed := string(e.buf[e.pos-n : e.pos])
+
+	var items []string
+	cell := 0
+	for _, c := range candidates {
+		item := typed + string(c)
+		items = append(items, item)
+		if w := runewidth.StringWidth(item) + 2; w > cell {
+			cell = w
+		}
+	}
+	cols := e.width() / cell
+	if cols < 1 {
+		cols = 1
+	}
+	var out strings.Builder
+	for i, item := range items {
+		out.WriteString(item)
+		if (i+1)%cols == 0 || i == len(items)-1 {
+			out.WriteString("\r\n")
+		} else {
+			out.WriteString(strings.Repeat(" ", cell-runewidth.StringWidth(item)))
+		}
+	}
+	e.message(strings.TrimSuffix(out.String(), "\r\n"))
+}
+
+func commonPrefix(candidates [][]rune) []rune {
+	prefix := candidates[0]
+	for _, c := range candidates[1:] {
+		i := 0
+		for i < len(prefix) && i < len(c) && prefix[i] == c[i] {
+			i++
+		}
+		prefix = prefix[:i]
+	}
+	return prefix
+}
+
+// message shows the text below the input, which is rendered again after it
+func (e *Editor) message(text string) {
+	pos := e.pos
+	e.pos = len(e.buf)
+	e.render(true)
+	e.pos = pos
+	e.write(text + "\r\n")
+}
+
+// external edits the input in $VISUAL or $EDITOR
+func (e *Editor) external() {
+	if e.tty == nil {
+		e.message("the editor is only supported in the local terminal")
+		return
+	}
+	text, err := e.edit(string(e.buf))
+	if err != nil {
+		e.message(err.Error())
+		return
+	}
+	e.buf = []rune(strings.TrimSuffix(text, "\n"))
+	e.pos = len(e.buf)
+}
+
+func (e *Editor) edit(text string) (string, error) {
+	f, err := os.CreateTemp("", "guru-*.md")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(text)
+	f.Close()
+	if err != nil {
+		return "", err
+	}
+
+	// the editor runs in the normal mode of the terminal
+	fd := int(e.tty.Fd())
+	e.write(disablePaste)
+	term.Restore(fd, e.state)
+	cmd := EditorCommand(f.Name())
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = e.tty, e.cfg.Stdout, e.cfg.Stdout
+	err = cmd.Run()
+	if _, rerr := term.MakeRaw(fd); rerr != nil && err == nil {
+		err = rerr
+	}
+	e.write(enablePaste)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", cmd.Args[0], err)
+	}
+
+	data, err := os.ReadFile(f.Name())
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// EditorCommand returns the command editing the file in $VISUAL or $EDITOR,
+// vi is used if neither is set
+func EditorCommand(file string) *exec.Cmd {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+	// the editor could have arguments like "code --wait"
+	args := append(strings.Fields(editor), file)
+	return exec.Command(args[0], args[1:]...)
+}
+
+// render draws the prompt and the input from the first row of the prompt,
+// the cursor is moved to the next line after the input if final is true
+func (e *Editor) render(final bool) {
+	width := e.width()
+	prompt := e.prompt
+	if e.searching {
+		prompt = fmt.Sprintf("(reverse-i-search)`%s': ", string(e.query))
+		if e.failed {
+			prompt = "(failed " + prompt[1:]
+		}
+	}
+
+	var out strings.Builder
+	if e.cursorRow > 0 {
+		fmt.Fprintf(&out, "\x1b[%dA", e.cursorRow)
+	}
+	out.WriteString("\r\x1b[J")
+	out.WriteString(prompt)
+
+	// the position after the prompt, a full row waits to wrap at col == width
+	promptWidth := ansi.PrintableRuneWidth(prompt)
+	row, col := promptWidth/width, promptWidth%width
+	if col == 0 && promptWidth > 0 {
+		row, col = row-1, width
+	}
+	// the lines after the first are aligned with the first one
+	indent := promptWidth
+	if indent >= width/2 {
+		indent = 0
+	}
+
+	curRow, curCol := row, col
+	for i := 0; i <= len(e.buf); i++ {
+		if i == e.pos {
+			curRow, curCol = row, col
+			if col == width {
+				curRow, curCol = row+1, 0
+			}
+		}
+		if i == len(e.buf) {
+			break
+		}
+		r := e.buf[i]
+		if r == '\n' {
+			out.WriteString("\r\n" + strings.Repeat(" ", indent))
+			row, col = row+1, indent
+			continue
+		}
+		text, w := string(r), runewidth.RuneWidth(r)
+		if r == '\t' {
+			text, w = strings.Repeat(" ", tabWidth), tabWidth
+		}
+		// the wide character does not fit is wrapped to the next row
+		if col+w > width {
+			if col < width {
+				out.WriteString(strings.Repeat(" ", width-col))
+			}
+			row, col = row+1, 0
+		}
+		out.WriteString(text)
+		col += w
+	}
+	// move to the next row if the last one is full, so the cursor is there
+	if col == width && !final {
+		out.WriteString("\r\n")
+		row, col = row+1, 0
+	}
+
+	if final {
+		out.WriteString("\r\n")
+		e.cursorRow = 0
+	} else {
+		if up := row - curRow; up > 0 {
+			fmt.Fprintf(&out, "\x1b[%dA", up)
+		}
+		out.WriteString("\r")
+		if curCol > 0 {
+			fmt.Fprintf(&out, "\x1b[%dC", curCol)
+		}
+		e.cursorRow = curRow
+	}
+	e.write(out.String())
+}
@@ -0,0 +1,118 @@
+package lineedit
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+// result is the result of Readline
+type result struct {
+	line string
+	err  error
+}
+
+// readline runs Readline with the inputs typed one by one, an input is a
+// key or the keys typed at once, and the Editor waits for more input after
+// them. An empty input is a pause longer than the Esc timeout
+func readline(t *testing.T, h *History, inputs ...string) (string, error) {
+	t.Helper()
+	timeout := escTimeout
+	escTimeout = 10 * time.Millisecond
+	defer func() { escTimeout = timeout }()
+
+	r, w := io.Pipe()
+	e := New(Config{Stdin: r, Stdout: io.Discard})
+	if h != nil {
+		e.SetHistory(h)
+	}
+	done := make(chan result, 1)
+	go func() {
+		line, err := e.Readline()
+		done <- result{line, err}
+	}()
+	for _, input := range inputs {
+		if input == "" {
+			time.Sleep(10 * escTimeout)
+			continue
+		}
+		select {
+		case res := <-done:
+			t.Fatalf("finished with %q, %v before %q is typed", res.line, res.err, input)
+		default:
+		}
+		w.Write([]byte(input))
+	}
+	w.Close()
+	select {
+	case res := <-done:
+		return res.line, res.err
+	case <-time.After(time.Second):
+		t.Fatal("Readline is not finished")
+	}
+	return "", nil
+}
+
+func TestReadline(t *testing.T) {
+	history := func() *History {
+		h, _ := OpenHistory("", 10)
+		h.Add("echo hello world")
+		h.Add("ls -l\n/tmp")
+		return h
+	}
+	cases := []struct {
+		name   string
+		inputs []string
+		want   string
+		err    error
+	}{
+		{"line", []string{"hello", "\r"}, "hello", nil},
+		{"alt+enter", []string{"one", "\x1b\r", "two", "\r"}, "one\ntwo", nil},
+		{"shift+enter", []string{"one", "\x1b[13;2u", "two", "\r"}, "one\ntwo", nil},
+		{"continued", []string{"one\\", "\r", "two", "\r"}, "one\ntwo", nil},
+		{"enter pasted", []string{"one\rtwo", "\r"}, "one\ntwo", nil},
+		{"bracketed paste", []string{pasteStart + "one\r", "two" + pasteEnd, "\r"}, "one\ntwo", nil},
+		{"alt+b", []string{"foo bar", "\x1bb", "x", "\r"}, "foo xbar", nil},
+		{"esc then b", []string{"foo bar", "\x1b", "", "b", "\r"}, "foo barb", nil},
+		{"ctrl+w", []string{"foo bar", "\x17", "\r"}, "foo ", nil},
+		{"ctrl+a ctrl+k", []string{"one", "\x1b\r", "two", "\x01", "\x0b", "\r"}, "one\n", nil},
+		{"up between lines", []string{"one", "\x1b\r", "two", "\x1b[A", "x", "\r"}, "onex\ntwo", nil},
+		{"history", []string{"draft", "\x1b[A", "\x1b[A", "\x1b[B", "\r"}, "ls -l\n/tmp", nil},
+		{"history back to draft", []string{"draft", "\x10", "\x0e", "\r"}, "draft", nil},
+		{"ctrl+c drops the input", []string{"draft", "\x03", "new", "\r"}, "new", nil},
+		{"ctrl+c", []string{"\x03"}, "", ErrInterrupt},
+		{"ctrl+d", []string{"\x04"}, "", io.EOF},
+		{"eof", []string{"partial"}, "", io.EOF},
+		{"search", []string{"\x12", "hello", "\r"}, "echo hello world", nil},
+		{"search again", []string{"\x12", "l", "\x12", "\r"}, "echo hello world", nil},
+		{"search edited", []string{"\x12", "hello", "\x1b[C", "!", "\r"}, "echo h!ello world", nil},
+		{"search canceled by esc", []string{"draft", "\x12", "world", "\x1b", "", "\r"}, "draft", nil},
+		{"search canceled by ctrl+g", []string{"draft", "\x12", "world", "\x07", "\r"}, "draft", nil},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			line, err := readline(t, history(), c.inputs...)
+			if line != c.want || err != c.err {
+				t.Errorf("got %q, %v, want %q, %v", line, err, c.want, c.err)
+			}
+		})
+	}
+}
+
+func TestReadlineComplete(t *testing.T) {
+	r, w := io.Pipe()
+	e := New(Config{Stdin: r, Stdout: io.Discard, AutoComplete: func(line []rune, pos int) ([][]rune, int) {
+		if string(line[:pos]) == ":he" {
+			return [][]rune{[]rune("lp"), []rune("llo")}, 3
+		}
+		return nil, 0
+	}})
+	go func() {
+		for _, input := range []string{":he", "\t", "\r"} {
+			w.Write([]byte(input))
+		}
+	}()
+	if line, err := e.Readline(); line != ":hel" || err != nil {
+		t.Errorf("got %q, %v, want the common prefix completed", line, err)
+	}
+}
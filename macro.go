@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Macro is a builtin command defined by the user in the config, like
+//
+//	:review = ":reset; :act as Code Reviewer; $git diff"
+//
+// the steps separated by ; are evaluated in order as they are typed, $1 to $9
+// are replaced by the arguments, $@ by all of them and $$ by $. The model is
+// asked once after the steps if any of them sends a message
+type Macro struct {
+	Name       string
+	Definition string
+	Steps      []string
+
+	sess    *Session
+	running bool // to stop the macro calling itself
+}
+
+// the macros by name, they are evaluated by builtinCommandEval
+var macros = make(map[string]*Macro)
+
+// registerMacros adds the macros as the builtin commands, the one without
+// steps and arguments is added as an alias
+func registerMacros(sess *Session, defs map[string]string) error {
+	for name, def := range defs {
+		if !strings.HasPrefix(name, ":") {
+			name = ":" + name
+		}
+		if strings.ContainsAny(name, " \t") {
+			return fmt.Errorf("macro %q should be one word", name)
+		}
+		// the macros registered by the former sessions of the ssh app are
+		// replaced, but not the builtin commands
+		if _, ok := macros[name]; !ok && hasBuiltin(name) {
+			return fmt.Errorf("macro %s conflicts with the builtin command", name)
+		}
+		m := &Macro{Name: name, Definition: def, Steps: splitSteps(def), sess: sess}
+		if len(m.Steps) == 0 {
+			return fmt.Errorf("macro %s has no steps", name)
+		}
+		macros[name] = m
+
+		if step := m.Steps[0]; len(m.Steps) == 1 && step[0] == ':' && !strings.Contains(step, "$") {
+			builtins.Alias(name, step)
+			continue
+		}
+		builtins.AddCommand(name, m.command, "macro: "+def, m.complete)
+	}
+	return nil
+}
+
+// splitSteps splits the definition by ; out of the quotes
+func splitSteps(def string) []string {
+	var steps []string
+	var step strings.Builder
+	var quote rune
+	add := func() {
+		if s := strings.TrimSpace(step.String()); s != "" {
+			steps = append(steps, s)
+		}
+		step.Reset()
+	}
+	for _, r := range def {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote == 0 && (r == '\'' || r == '"'):
+			quote = r
+		case quote == 0 && r == ';':
+			add()
+			continue
+		}
+		step.WriteRune(r)
+	}
+	add()
+	return steps
+}
+
+// expand replaces the arguments in the step
+func expand(step string, args []string) string {
+	var out strings.Builder
+	for i := 0; i < len(step); i++ {
+		if step[i] != '$' || i+1 == len(step) {
+			out.WriteByte(step[i])
+			continue
+		}
+		switch c := step[i+1]; {
+		case c == '@':
+			out.WriteString(strings.Join(args, " "))
+			i++
+		case c >= '1' && c <= '9':
+			if n := int(c - '1'); n < len(args) {
+				out.WriteString(args[n])
+			}
+			i++
+		case c == '$':
+			out.WriteByte('$')
+			i++
+		default:
+			out.WriteByte('$')
+		}
+	}
+	return out.String()
+}
+
+// isArg reports if the step begins with an argument instead of a command
+func isArg(step string) bool {
+	return len(step) > 1 && step[0] == '$' && (step[1] == '@' || step[1] >= '1' && step[1] <= '9')
+}
+
+// eval evaluates the steps, cont is true if a message is sent, the
+// messages are appended to the session as the steps are typed one by one
+func (m *Macro) eval(args []string) (cont bool) {
+	if m.running {
+		m.sess.out.Errorln("macro " + m.Name + " is called recursively")
+		return false
+	}
+	m.running = true
+	defer func() { m.running = false }()
+
+	for _, step := range m.Steps {
+		text := expand(step, args)
+		switch {
+		case isArg(step):
+			cont = appendText(m.sess, text) || cont
+		case step[0] == ':':
+			cont = builtinCommandEval(m.sess, text) || cont
+		case step[0] == '$':
+			sysCommandEval(m.sess, strings.TrimSpace(text[1:]))
+		default:
+			cont = appendText(m.sess, text) || cont
+		}
+	}
+	return cont
+}
+
+func appendText(sess *Session, text string) bool {
+	if text = strings.TrimSpace(text); text == "" {
+		return false
+	}
+	sess.Append(&Message{Role: User, Content: text})
+	return true
+}
+
+// command runs the macro launched by the builtins directly, the model is
+// not asked then
+func (m *Macro) command() (_ string) {
+	opts := struct {
+		Args []string `cortana:"args, -"`
+	}{}
+	if usage := builtins.Parse(&opts); usage {
+		return
+	}
+	m.eval(opts.Args)
+	return
+}
+
+// complete completes the arguments as the step taking them, like the role
+// of `:act as $@`
+func (m *Macro) complete(line []rune, pos int) ([][]rune, int) {
+	args := strings.TrimLeft(strings.TrimPrefix(strings.TrimLeft(string(line), " "), m.Name), " ")
+	for _, step := range m.Steps {
+		i := strings.Index(step, "$@")
+		if i < 0 {
+			i = strings.Index(step, "$1")
+		}
+		if i < 0 || step[0] != ':' {
+			continue
+		}
+		prefix := []rune(step[:i] + args)
+		return completes.Complete(prefix, len(prefix))
+	}
+	return nil, 0
+}
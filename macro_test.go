@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestSplitSteps(t *testing.T) {
+	cases := []struct {
+		def   string
+		steps []string
+	}{
+		{":reset; :act as Code Reviewer; $git diff", []string{":reset", ":act as Code Reviewer", "$git diff"}},
+		{"  one ;; two;  ", []string{"one", "two"}},
+		{`:act as "a; b"; hello`, []string{`:act as "a; b"`, "hello"}},
+		{`$echo 'say "hi; there"'; x`, []string{`$echo 'say "hi; there"'`, "x"}},
+		{`$echo "it's; fine"; y`, []string{`$echo "it's; fine"`, "y"}},
+		{`"not closed; at all`, []string{`"not closed; at all`}},
+		{" ; ", nil},
+	}
+	for _, c := range cases {
+		steps := splitSteps(c.def)
+		if strings.Join(steps, "|") != strings.Join(c.steps, "|") || len(steps) != len(c.steps) {
+			t.Errorf("splitSteps(%q) = %q, want %q", c.def, steps, c.steps)
+		}
+	}
+}
+
+func TestExpand(t *testing.T) {
+	args := []string{"one", "two", "three"}
+	cases := []struct {
+		step string
+		args []string
+		want string
+	}{
+		{"$git diff $@", args, "$git diff one two three"},
+		{"$cat $1 $3", args, "$cat one three"},
+		{"$1-$2-$9", args, "one-two-"},
+		{"$@", nil, ""},
+		{"$echo $$1 $$@ $$", args, "$echo $1 $@ $"},
+		{"$echo $$$1", args, "$echo $one"},
+		{"$echo $HOME $0 $", args, "$echo $HOME $0 $"},
+		{"price: $10", args, "price: one0"},
+	}
+	for _, c := range cases {
+		if got := expand(c.step, c.args); got != c.want {
+			t.Errorf("expand(%q, %q) = %q, want %q", c.step, c.args, got, c.want)
+		}
+	}
+}
+
+// newMacroSession creates a session with the macros registered, which are
+// removed after the test
+func newMacroSession(t *testing.T, defs map[string]string) (*Session, *bytes.Buffer) {
+	t.Helper()
+	var out bytes.Buffer
+	sess := NewSession(t.TempDir(), WithCommandOutput(New(WithStdout(&out))))
+	if err := sess.Open(""); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		sess.Close()
+		for name := range defs {
+			delete(macros, ":"+name)
+		}
+	})
+	if err := registerMacros(sess, defs); err != nil {
+		t.Fatal(err)
+	}
+	return sess, &out
+}
+
+func contents(sess *Session) []string {
+	var texts []string
+	for _, m := range sess.Messages() {
+		texts = append(texts, m.Content)
+	}
+	return texts
+}
+
+func TestMacroEval(t *testing.T) {
+	sess, out := newMacroSession(t, map[string]string{
+		"explain": `explain "this; that" in short; $1 and $2`,
+		"quiet":   ":message list",
+	})
+
+	if !builtinCommandEval(sess, ":explain first") {
+		t.Errorf("the model is not asked after the macro sending messages")
+	}
+	// the missing argument is empty
+	if got := contents(sess); strings.Join(got, "|") != `explain "this; that" in short|first and` {
+		t.Errorf("messages %q", got)
+	}
+	if builtinCommandEval(sess, ":quiet") {
+		t.Errorf("the model is asked after the macro of the builtin command")
+	}
+	if strings.Contains(out.String(), "recursively") {
+		t.Errorf("unexpected output: %s", out.String())
+	}
+}
+
+func TestMacroRecursion(t *testing.T) {
+	sess, out := newMacroSession(t, map[string]string{
+		"loop": ":loop; looped",
+		"ping": ":pong; ping",
+		"pong": ":ping; pong",
+	})
+
+	// the macro calling itself stops at the second call
+	builtinCommandEval(sess, ":loop")
+	if got := contents(sess); strings.Join(got, "|") != "looped" {
+		t.Errorf("messages %q, want looped once", got)
+	}
+	if !strings.Contains(out.String(), "macro :loop is called recursively") {
+		t.Errorf("output %q, want the recursion reported", out.String())
+	}
+
+	// and so do the macros calling each other
+	sess.ClearMessage()
+	out.Reset()
+	builtinCommandEval(sess, ":ping")
+	if got := contents(sess); strings.Join(got, "|") != "pong|ping" {
+		t.Errorf("messages %q, want pong and ping once", got)
+	}
+	if !strings.Contains(out.String(), "macro :ping is called recursively") {
+		t.Errorf("output %q, want the recursion reported", out.String())
+	}
+
+	// the macro could be called again after the recursion is stopped
+	sess.ClearMessage()
+	builtinCommandEval(sess, ":pong")
+	if got := contents(sess); strings.Join(got, "|") != "ping|pong" {
+		t.Errorf("messages %q, want ping and pong once", got)
+	}
+}
+
+func TestRegisterMacros(t *testing.T) {
+	sess := NewSession(t.TempDir(), WithCommandOutput(New(WithStdout(&bytes.Buffer{}))))
+	for _, defs := range []map[string]string{
+		{"reset": "hello"},
+		{"two words": "hello"},
+		{"empty": " ; "},
+	} {
+		if err := registerMacros(sess, defs); err == nil {
+			t.Errorf("macros %q are registered", defs)
+		}
+	}
+}
@@ -2,9 +2,9 @@ package main
 
 import (
 	"encoding/json"
+	"os"
 
 	"github.com/shafreeck/cortana"
-	"gopkg.in/yaml.v3"
 )
 
 func main() {
@@ -12,14 +12,32 @@ func main() {
 	unmarshaler := cortana.UnmarshalFunc(json.Unmarshal)
 	cortana.AddConfig("guru.json", unmarshaler)                // deprecated
 	cortana.AddConfig("~/.config/guru/guru.json", unmarshaler) // deprecated
-	cortana.AddConfig("guru.yaml", cortana.UnmarshalFunc(yaml.Unmarshal))
-	cortana.AddConfig("~/.guru/config", cortana.UnmarshalFunc(yaml.Unmarshal))
-	cortana.Use(cortana.ConfFlag("--conf", "-c", unmarshaler))
+	// the project guru.yaml overrides the global config
+	for _, file := range configFiles {
+		cortana.AddConfig(file, yamlConfigUnmarshaler)
+	}
+	cortana.Use(cortana.ConfFlag("--conf", "-c", unmarshaler), func(c *cortana.Cortana) {
+		c.AddEnvUnmarshaler(envConfigUnmarshaler)
+	})
+
+	// --profile is accepted by all the commands
+	args, profile := extractProfile(os.Args[1:])
+	os.Args, configProfile = append(os.Args[:1], args...), profile
 
 	cortana.AddRootCommand(g.ChatCommand)
 	cortana.AddCommand("chat", g.ChatCommand, "chat with ChatGPT")
 	cortana.AddCommand("config", g.ConfigCommand, "configure guru")
+	cortana.AddCommand("config unset", g.ConfigUnsetCommand, "remove the key from the configuration")
+	cortana.AddCommand("config set-key", g.ConfigSetKeyCommand, "save the api key in the keyring without echo")
+	cortana.AddCommand("config explain", g.ConfigExplainCommand, "show the effective configuration and where each value comes from")
+	cortana.AddCommand("batch", g.BatchCommand, "run a prompt over many inputs concurrently")
+	cortana.AddCommand("run", g.RunCommand, "run the workflow of prompts and commands")
+	cortana.AddCommand("watch", g.WatchCommand, "ask the model again when the files change")
+	cortana.AddCommand("usage", g.UsageCommand, "report the usage and cost")
+	cortana.AddCommand("cache stats", g.CacheStatsCommand, "show the stats of the response cache")
+	cortana.AddCommand("cache clear", g.CacheClearCommand, "clear the response cache")
 	cortana.AddCommand("serve ssh", g.ServeSSH, "serve as an ssh app")
+	cortana.AddCommand("serve mock", g.ServeMock, "serve a fake chat completions api for development")
 
 	// Avoid using same word of command and prompt name, or it cause confused for cortana.
 	// Ex. alias cheatsheet = "chat --prompt cheatsheet", when run with `chat --prompt cheatsheet`,
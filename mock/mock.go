@@ -0,0 +1,424 @@
+// Package mock is a fake server of the OpenAI chat completions api, it
+// replies the questions by echoing or following a script, and replies
+// the errors on demand. It could be served as a standalone server by
+// `guru serve mock`, or used in process as a test fixture, where Q, A and C
+// are the question, answer and chunk types of the caller like the ones in
+// chat/chat_test.go:
+//
+//	srv := httptest.NewServer(mock.New(mock.WithScript(script)))
+//	defer srv.Close()
+//	cli := chat.New[Q, A, C](srv.Client(), srv.URL+"/v1/chat/completions", "")
+package mock
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shafreeck/guru/sse"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultModel is the model replied if the question does not set one
+const DefaultModel = "mock"
+
+// ErrorPrefix triggers an error on demand in any mode, a message like
+// "mock:error rate_limit_exceeded" or "mock:error 429" replies the error
+const ErrorPrefix = "mock:error "
+
+// errorTemplate is the error replied by the api
+type errorTemplate struct {
+	status  int
+	typ     string
+	code    string
+	message string
+}
+
+// knownErrors are the errors could be replied, which are named by the code or the status
+var knownErrors = map[string]*errorTemplate{
+	"context_length_exceeded": {http.StatusBadRequest, "invalid_request_error", "context_length_exceeded",
+		"This model's maximum context length is 4097 tokens. However, your messages resulted in more tokens. Please reduce the length of the messages."},
+	"rate_limit_exceeded": {http.StatusTooManyRequests, "requests", "rate_limit_exceeded",
+		"Rate limit reached for requests. Please try again in 1s."},
+	"insufficient_quota": {http.StatusTooManyRequests, "insufficient_quota", "insufficient_quota",
+		"You exceeded your current quota, please check your plan and billing details."},
+	"invalid_api_key": {http.StatusUnauthorized, "invalid_request_error", "invalid_api_key",
+		"Incorrect API key provided."},
+	"server_error": {http.StatusInternalServerError, "server_error", "",
+		"The server had an error while processing your request. Sorry about that!"},
+	"service_unavailable": {http.StatusServiceUnavailable, "server_error", "",
+		"The engine is currently overloaded, please try again later."},
+}
+
+// aliases of the errors by the status code
+var statusErrors = map[string]string{
+	"400": "context_length_exceeded",
+	"401": "invalid_api_key",
+	"429": "rate_limit_exceeded",
+	"500": "server_error",
+	"503": "service_unavailable",
+}
+
+func lookupError(name string) (*errorTemplate, bool) {
+	name = strings.TrimSpace(name)
+	if alias, ok := statusErrors[name]; ok {
+		name = alias
+	}
+	e, ok := knownErrors[name]
+	return e, ok
+}
+
+// Rule replies the question whose last user message matches
+type Rule struct {
+	Match      string        `yaml:"match"`       // the regexp to match, empty matches any message
+	Reply      string        `yaml:"reply"`       // the content to reply
+	Error      string        `yaml:"error"`       // the error to reply instead, named by the code or the status
+	Times      int           `yaml:"times"`       // the times the rule could be applied, 0 means unlimited
+	RetryAfter time.Duration `yaml:"retry_after"` // the Retry-After header replied with the error
+
+	re    *regexp.Regexp
+	count int
+}
+
+// Script is a list of rules tried in order, the first matched rule
+// replies, and the message is echoed if no rule matches
+type Script struct {
+	Model string  `yaml:"model"`
+	Rules []*Rule `yaml:"rules"`
+
+	mu sync.Mutex
+}
+
+// LoadScript loads the script from a yaml file
+func LoadScript(filename string) (*Script, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	s := &Script{}
+	if err := yaml.Unmarshal(data, s); err != nil {
+		return nil, err
+	}
+	if err := s.compile(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Script) compile() error {
+	for _, r := range s.Rules {
+		re, err := regexp.Compile(r.Match)
+		if err != nil {
+			return fmt.Errorf("invalid match %q: %w", r.Match, err)
+		}
+		r.re = re
+		if r.Error != "" {
+			if _, ok := lookupError(r.Error); !ok {
+				return fmt.Errorf("unknown error %q", r.Error)
+			}
+		}
+	}
+	return nil
+}
+
+// match returns the first rule matching the message, nil if none
+func (s *Script) match(msg string) *Rule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range s.Rules {
+		if r.Times > 0 && r.count >= r.Times {
+			continue
+		}
+		if r.re != nil && !r.re.MatchString(msg) {
+			continue
+		}
+		r.count++
+		return r
+	}
+	return nil
+}
+
+type Option func(s *Server)
+
+// WithScript replies by the script instead of echoing
+func WithScript(script *Script) Option {
+	return func(s *Server) {
+		s.script = script
+	}
+}
+
+// WithDelay sleeps the duration between chunks when streaming
+func WithDelay(d time.Duration) Option {
+	return func(s *Server) {
+		s.delay = d
+	}
+}
+
+// WithChunkSize sets the runes of a chunk when streaming
+func WithChunkSize(n int) Option {
+	return func(s *Server) {
+		s.chunkSize = n
+	}
+}
+
+// Server serves the chat completions api
+type Server struct {
+	script    *Script
+	delay     time.Duration
+	chunkSize int
+
+	mu  sync.Mutex
+	seq int // the sequence of the completion ids
+}
+
+// New creates a server, it panics if the rules of the script are invalid
+func New(opts ...Option) *Server {
+	s := &Server{chunkSize: 4}
+	for _, o := range opts {
+		o(s)
+	}
+	if s.script != nil {
+		// the rules of a script not loaded from file are compiled here
+		if err := s.script.compile(); err != nil {
+			panic(err)
+		}
+	}
+	return s
+}
+
+type message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type question struct {
+	Model         string     `json:"model"`
+	N             int        `json:"n"`
+	Stream        bool       `json:"stream"`
+	Messages      []*message `json:"messages"`
+	StreamOptions *struct {
+		IncludeUsage bool `json:"include_usage"`
+	} `json:"stream_options"`
+}
+
+type usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// ServeHTTP serves the path ending with /chat/completions
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasSuffix(r.URL.Path, "/chat/completions") {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		s.writeError(w, &errorTemplate{http.StatusMethodNotAllowed, "invalid_request_error", "",
+			"Only POST is allowed."}, 0)
+		return
+	}
+
+	q := &question{}
+	if err := json.NewDecoder(r.Body).Decode(q); err != nil {
+		s.writeError(w, &errorTemplate{http.StatusBadRequest, "invalid_request_error", "",
+			"We could not parse the JSON body of your request: " + err.Error()}, 0)
+		return
+	}
+	if len(q.Messages) == 0 {
+		s.writeError(w, &errorTemplate{http.StatusBadRequest, "invalid_request_error", "",
+			"[] is too short - 'messages'"}, 0)
+		return
+	}
+
+	msg := lastUserMessage(q.Messages)
+	reply := msg
+	if strings.HasPrefix(msg, ErrorPrefix) {
+		if e, ok := lookupError(strings.TrimPrefix(msg, ErrorPrefix)); ok {
+			s.writeError(w, e, time.Second)
+			return
+		}
+	}
+	if s.script != nil {
+		if rule := s.script.match(msg); rule != nil {
+			if rule.Error != "" {
+				e, _ := lookupError(rule.Error)
+				s.writeError(w, e, rule.RetryAfter)
+				return
+			}
+			reply = rule.Reply
+		}
+	}
+
+	model := q.Model
+	if s.script != nil && s.script.Model != "" {
+		model = s.script.Model
+	}
+	if model == "" {
+		model = DefaultModel
+	}
+	n := q.N
+	if n <= 0 {
+		n = 1
+	}
+	u := usage{CompletionTokens: countTokens(reply) * n}
+	for _, m := range q.Messages {
+		u.PromptTokens += countTokens(m.Content)
+	}
+	u.TotalTokens = u.PromptTokens + u.CompletionTokens
+
+	s.mu.Lock()
+	s.seq++
+	id := fmt.Sprintf("chatcmpl-mock-%d", s.seq)
+	s.mu.Unlock()
+
+	if q.Stream {
+		includeUsage := q.StreamOptions != nil && q.StreamOptions.IncludeUsage
+		s.stream(w, r, id, model, reply, n, u, includeUsage)
+		return
+	}
+
+	type choice struct {
+		Index        int      `json:"index"`
+		Message      *message `json:"message"`
+		FinishReason string   `json:"finish_reason"`
+	}
+	ans := struct {
+		ID      string   `json:"id"`
+		Object  string   `json:"object"`
+		Created int64    `json:"created"`
+		Model   string   `json:"model"`
+		Choices []choice `json:"choices"`
+		Usage   usage    `json:"usage"`
+	}{ID: id, Object: "chat.completion", Created: time.Now().Unix(), Model: model, Usage: u}
+	for i := 0; i < n; i++ {
+		ans.Choices = append(ans.Choices, choice{Index: i, FinishReason: "stop",
+			Message: &message{Role: "assistant", Content: reply}})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ans)
+}
+
+// stream replies the content in chunks as server-sent events
+func (s *Server) stream(w http.ResponseWriter, r *http.Request, id, model, reply string,
+	n int, u usage, includeUsage bool) {
+	type delta struct {
+		Role    string `json:"role,omitempty"`
+		Content string `json:"content"`
+	}
+	type choice struct {
+		Index        int     `json:"index"`
+		Delta        delta   `json:"delta"`
+		FinishReason *string `json:"finish_reason"`
+	}
+	type chunk struct {
+		ID      string   `json:"id"`
+		Object  string   `json:"object"`
+		Created int64    `json:"created"`
+		Model   string   `json:"model"`
+		Choices []choice `json:"choices"`
+		Usage   *usage   `json:"usage,omitempty"`
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	enc := sse.NewEncoder(w)
+	created := time.Now().Unix()
+	send := func(c *chunk) bool {
+		data, _ := json.Marshal(c)
+		if err := enc.Encode(&sse.Event{Data: string(data)}); err != nil {
+			return false
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return true
+	}
+
+	pieces := split(reply, s.chunkSize)
+	stop := "stop"
+	for i := 0; i < n; i++ {
+		c := &chunk{ID: id, Object: "chat.completion.chunk", Created: created, Model: model}
+		c.Choices = []choice{{Index: i, Delta: delta{Role: "assistant"}}}
+		if !send(c) {
+			return
+		}
+		for _, piece := range pieces {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(s.delay):
+			}
+			c.Choices = []choice{{Index: i, Delta: delta{Content: piece}}}
+			if !send(c) {
+				return
+			}
+		}
+		c.Choices = []choice{{Index: i, FinishReason: &stop}}
+		if !send(c) {
+			return
+		}
+	}
+	if includeUsage {
+		send(&chunk{ID: id, Object: "chat.completion.chunk", Created: created, Model: model,
+			Choices: []choice{}, Usage: &u})
+	}
+	enc.Encode(&sse.Event{Data: "[DONE]"})
+}
+
+func (s *Server) writeError(w http.ResponseWriter, e *errorTemplate, retryAfter time.Duration) {
+	if e.status == http.StatusTooManyRequests && retryAfter > 0 {
+		w.Header().Set("Retry-After", fmt.Sprint(int(retryAfter.Round(time.Second).Seconds())))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.status)
+
+	var code any // the code is null if not set
+	if e.code != "" {
+		code = e.code
+	}
+	json.NewEncoder(w).Encode(map[string]any{"error": map[string]any{
+		"message": e.message, "type": e.typ, "param": nil, "code": code}})
+}
+
+// lastUserMessage returns the last user message which is not blank,
+// the content is trimmed
+func lastUserMessage(messages []*message) string {
+	for i := len(messages) - 1; i >= 0; i-- {
+		content := strings.TrimSpace(messages[i].Content)
+		if messages[i].Role == "user" && content != "" {
+			return content
+		}
+	}
+	return strings.TrimSpace(messages[len(messages)-1].Content)
+}
+
+// countTokens counts the words as tokens, which is good enough for a mock
+func countTokens(text string) int {
+	return len(strings.Fields(text))
+}
+
+// split splits the text into pieces of n runes
+func split(text string, n int) []string {
+	if n <= 0 {
+		return []string{text}
+	}
+	var pieces []string
+	runes := []rune(text)
+	for len(runes) > n {
+		pieces = append(pieces, string(runes[:n]))
+		runes = runes[n:]
+	}
+	if len(runes) > 0 {
+		pieces = append(pieces, string(runes))
+	}
+	return pieces
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Plugin is an external builtin command, the executable named guru-foo in
+// the plugins directory or $PATH is added as :foo. The plugin reads the
+// request as json from stdin and writes the response as json to stdout
+type Plugin struct {
+	Name string // the command name like :foo
+	Path string
+
+	sess *Session
+	cc   *ChatCommand // the plugin is canceled with its context if set
+
+	// the completions by the line, the plugin is not forked on every Tab
+	completions map[string][]string
+}
+
+// PluginRequest is written to the stdin of the plugin
+type PluginRequest struct {
+	// Action is run to launch the command, or complete to complete the line
+	Action   string     `json:"action"`
+	Command  string     `json:"command"`
+	Args     []string   `json:"args"`
+	Line     string     `json:"line,omitempty"` // the line to complete
+	Session  string     `json:"session"`
+	Messages []*Message `json:"messages"`
+}
+
+// PluginResponse is read from the stdout of the plugin, the output not in
+// json is displayed as is
+type PluginResponse struct {
+	Send        string     `json:"send,omitempty"`   // sent to the model as the user
+	Append      []*Message `json:"append,omitempty"` // appended without asking
+	Output      string     `json:"output,omitempty"` // displayed only
+	Completions []string   `json:"completions,omitempty"`
+}
+
+const pluginPrefix = "guru-"
+
+// the completion should not block the typing too long
+var pluginCompleteTimeout = 2 * time.Second
+
+// the plugins by name
+var plugins = make(map[string]*Plugin)
+
+// lookupPlugins finds the plugins in the dirs, the former dir takes
+// precedence if the same plugin is found
+func lookupPlugins(dirs ...string) map[string]string {
+	found := make(map[string]string)
+	for _, dir := range dirs {
+		entries, _ := os.ReadDir(dir)
+		for _, entry := range entries {
+			name := strings.TrimPrefix(entry.Name(), pluginPrefix)
+			if name == entry.Name() || name == "" || entry.IsDir() {
+				continue
+			}
+			if _, ok := found[name]; ok {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil || info.Mode()&0111 == 0 {
+				continue
+			}
+			found[name] = filepath.Join(dir, entry.Name())
+		}
+	}
+	return found
+}
+
+// registerPlugins adds the plugins found in dir and $PATH as the builtin
+// commands, the builtin commands and macros are not overridden
+func registerPlugins(sess *Session, cc *ChatCommand, dir string) {
+	dirs := append([]string{dir}, filepath.SplitList(os.Getenv("PATH"))...)
+	for name, file := range lookupPlugins(dirs...) {
+		name = ":" + name
+		// the plugins registered by the former sessions of the ssh app are
+		// replaced
+		if _, ok := plugins[name]; !ok && hasBuiltin(name) {
+			continue
+		}
+		p := &Plugin{Name: name, Path: file, sess: sess, cc: cc}
+		plugins[name] = p
+		builtins.AddCommand(name, p.command, "plugin: "+file, p.complete)
+	}
+}
+
+func hasBuiltin(name string) bool {
+	for _, cmd := range builtins.Commands() {
+		if cmd.Path == name {
+			return true
+		}
+	}
+	return false
+}
+
+// command runs the plugin, the text to send is returned to ask the model
+func (p *Plugin) command() string {
+	// the plugin is killed when interrupted by Ctrl+C, or canceled by the
+	// full-screen app, like the requests of talk
+	base := context.Background()
+	if p.cc != nil && p.cc.ctx != nil {
+		base = p.cc.ctx
+	}
+	ctx, stop := signal.NotifyContext(base, os.Interrupt)
+	defer stop()
+	// the completions may change after running
+	p.completions = nil
+
+	resp, err := p.run(ctx, &PluginRequest{Action: "run", Args: builtins.Args()})
+	if err != nil {
+		p.sess.out.Errorln(err)
+		return ""
+	}
+	if resp.Output != "" {
+		fmt.Fprintln(p.sess.out, strings.TrimRight(resp.Output, "\n"))
+	}
+	for _, m := range resp.Append {
+		if m.Role == "" {
+			m.Role = User
+		}
+		p.sess.Append(m)
+	}
+	return resp.Send
+}
+
+// complete asks the plugin for the candidates of the word typed, the
+// candidates not matching the word are dropped
+func (p *Plugin) complete(line []rune, pos int) ([][]rune, int) {
+	text := string(line[:pos])
+	fields := strings.Fields(text)
+	var args []string
+	if len(fields) > 0 {
+		args = fields[1:]
+	}
+	var word string
+	if len(args) > 0 && !strings.HasSuffix(text, " ") {
+		word = args[len(args)-1]
+	}
+
+	completions, ok := p.completions[text]
+	if !ok {
+		ctx, cancel := context.WithTimeout(context.Background(), pluginCompleteTimeout)
+		defer cancel()
+		// the failure is cached as well, which should not block the typing
+		// again
+		if resp, err := p.run(ctx, &PluginRequest{Action: "complete", Args: args, Line: text}); err == nil {
+			completions = resp.Completions
+		}
+		if p.completions == nil {
+			p.completions = make(map[string][]string)
+		}
+		p.completions[text] = completions
+	}
+	var suggests [][]rune
+	for _, c := range completions {
+		if strings.HasPrefix(c, word) {
+			suggests = append(suggests, []rune(strings.TrimPrefix(c, word)))
+		}
+	}
+	return suggests, len([]rune(word))
+}
+
+// run runs the plugin with the request, the stderr is reported if it fails
+func (p *Plugin) run(ctx context.Context, req *PluginRequest) (*PluginResponse, error) {
+	req.Command = strings.TrimPrefix(p.Name, ":")
+	req.Session = p.sess.sid
+	// the empty lists are encoded as [] instead of null
+	req.Messages = append([]*Message{}, p.sess.Messages()...)
+	if req.Args == nil {
+		req.Args = []string{}
+	}
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, p.Path, req.Args...)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.Env = append(os.Environ(), "GURU_PLUGIN_ACTION="+req.Action)
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("plugin %s: %s", p.Name, msg)
+		}
+		return nil, fmt.Errorf("plugin %s: %w", p.Name, err)
+	}
+
+	resp := &PluginResponse{}
+	out := bytes.TrimSpace(stdout.Bytes())
+	if len(out) == 0 {
+		return resp, nil
+	}
+	if out[0] != '{' || json.Unmarshal(out, resp) != nil {
+		// the plain text is displayed, but not for the completion
+		if req.Action == "complete" {
+			return resp, nil
+		}
+		return &PluginResponse{Output: stdout.String()}, nil
+	}
+	return resp, nil
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writePlugin writes the shell script as the plugin
+func writePlugin(t *testing.T, dir, name, script string, mode os.FileMode) string {
+	t.Helper()
+	file := filepath.Join(dir, name)
+	if err := os.WriteFile(file, []byte("#!/bin/sh\n"+script), mode); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func newTestPlugin(t *testing.T, script string) (*Plugin, *bytes.Buffer) {
+	t.Helper()
+	var out bytes.Buffer
+	sess := NewSession(t.TempDir(), WithCommandOutput(New(WithStdout(&out))))
+	if err := sess.Open(""); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sess.Close() })
+	file := writePlugin(t, t.TempDir(), "guru-test", script, 0755)
+	return &Plugin{Name: ":test", Path: file, sess: sess}, &out
+}
+
+func TestLookupPlugins(t *testing.T) {
+	first, second := t.TempDir(), t.TempDir()
+	writePlugin(t, first, "guru-foo", "", 0755)
+	writePlugin(t, first, "guru-bar", "", 0644) // not executable
+	writePlugin(t, first, "guru-", "", 0755)
+	writePlugin(t, first, "foo", "", 0755)
+	os.Mkdir(filepath.Join(first, "guru-dir"), 0755)
+	writePlugin(t, second, "guru-foo", "", 0755)
+	writePlugin(t, second, "guru-baz", "", 0755)
+
+	found := lookupPlugins(first, filepath.Join(first, "missing"), second)
+	want := map[string]string{
+		"foo": filepath.Join(first, "guru-foo"),
+		"baz": filepath.Join(second, "guru-baz"),
+	}
+	if !reflect.DeepEqual(found, want) {
+		t.Errorf("found %v, want %v", found, want)
+	}
+}
+
+func TestPluginRun(t *testing.T) {
+	cases := []struct {
+		name   string
+		script string
+		resp   *PluginResponse
+		err    string
+	}{
+		{"json", `echo '{"output": "shown", "append": [{"content": "appended"}], "send": "sent"}'`,
+			&PluginResponse{Output: "shown", Append: []*Message{{Content: "appended"}}, Send: "sent"}, ""},
+		{"plain text", "echo hello; echo world", &PluginResponse{Output: "hello\nworld\n"}, ""},
+		{"broken json", `echo '{"output": '`, &PluginResponse{Output: "{\"output\": \n"}, ""},
+		{"nothing", "true", &PluginResponse{}, ""},
+		{"stderr", "echo broken >&2; exit 1", nil, "plugin :test: broken"},
+		{"exit code", "exit 2", nil, "plugin :test: exit status 2"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p, _ := newTestPlugin(t, c.script)
+			resp, err := p.run(context.Background(), &PluginRequest{Action: "run"})
+			if c.err != "" {
+				if err == nil || err.Error() != c.err {
+					t.Errorf("err %v, want %s", err, c.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(resp, c.resp) {
+				t.Errorf("response %+v, want %+v", resp, c.resp)
+			}
+		})
+	}
+}
+
+func TestPluginRequest(t *testing.T) {
+	dir := t.TempDir()
+	p, _ := newTestPlugin(t, `echo "$GURU_PLUGIN_ACTION $@" > `+dir+`/args; cat > `+dir+`/request`)
+
+	if _, err := p.run(context.Background(), &PluginRequest{Action: "run"}); err != nil {
+		t.Fatal(err)
+	}
+	// the empty lists are sent as []
+	data, _ := os.ReadFile(filepath.Join(dir, "request"))
+	if !strings.Contains(string(data), `"args":[]`) || !strings.Contains(string(data), `"messages":[]`) {
+		t.Errorf("request %s, want the empty lists", data)
+	}
+
+	p.sess.Append(&Message{Role: User, Content: "hello"})
+	if _, err := p.run(context.Background(), &PluginRequest{Action: "complete", Args: []string{"a", "b c"}, Line: ":test a"}); err != nil {
+		t.Fatal(err)
+	}
+	args, _ := os.ReadFile(filepath.Join(dir, "args"))
+	if string(args) != "complete a b c\n" {
+		t.Errorf("args %q", args)
+	}
+	req := &PluginRequest{}
+	data, _ = os.ReadFile(filepath.Join(dir, "request"))
+	if err := json.Unmarshal(data, req); err != nil {
+		t.Fatal(err)
+	}
+	if req.Command != "test" || req.Session != p.sess.sid || req.Line != ":test a" ||
+		len(req.Messages) != 1 || req.Messages[0].Content != "hello" {
+		t.Errorf("request %s", data)
+	}
+}
+
+func TestPluginCommand(t *testing.T) {
+	var out bytes.Buffer
+	sess := NewSession(t.TempDir(), WithCommandOutput(New(WithStdout(&out))))
+	if err := sess.Open(""); err != nil {
+		t.Fatal(err)
+	}
+	defer sess.Close()
+	dir := t.TempDir()
+	writePlugin(t, dir, "guru-plugintest", `echo '{"output": "shown", "append": [{"content": "'$1'"}], "send": "sent"}'`, 0755)
+	writePlugin(t, dir, "guru-pluginsleep", "exec sleep 10", 0755)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	registerPlugins(sess, &ChatCommand{ctx: ctx}, dir)
+	if text := builtins.Launch([]string{":plugintest", "appended"}); text != "sent" {
+		t.Errorf("text %q, want sent", text)
+	}
+	if msgs := sess.Messages(); len(msgs) != 1 || msgs[0].Role != User || msgs[0].Content != "appended" {
+		t.Errorf("the message is not appended: %d messages", len(msgs))
+	}
+	if out.String() != "shown\n" {
+		t.Errorf("output %q, want shown", out.String())
+	}
+
+	// the plugin is killed when the chat command is canceled
+	time.AfterFunc(100*time.Millisecond, cancel)
+	start := time.Now()
+	builtins.Launch([]string{":pluginsleep"})
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("the plugin is canceled after %v", d)
+	}
+	if !strings.Contains(out.String(), "plugin :pluginsleep: signal: killed") {
+		t.Errorf("output %q, want the plugin killed", out.String())
+	}
+}
+
+func TestPluginCompleteCache(t *testing.T) {
+	dir := t.TempDir()
+	p, _ := newTestPlugin(t, `echo "$GURU_PLUGIN_ACTION" >> `+dir+`/forked
+echo '{"completions": ["alpha", "beta", "alps"]}'`)
+	forked := func() int {
+		data, _ := os.ReadFile(filepath.Join(dir, "forked"))
+		return strings.Count(string(data), "complete")
+	}
+	complete := func(line string) []string {
+		suggests, n := p.complete([]rune(line), len([]rune(line)))
+		var words []string
+		for _, s := range suggests {
+			words = append(words, line[len(line)-n:]+string(s))
+		}
+		return words
+	}
+
+	for i := 0; i < 3; i++ {
+		if words := complete(":test al"); strings.Join(words, " ") != "alpha alps" {
+			t.Errorf("completed %q", words)
+		}
+	}
+	if n := forked(); n != 1 {
+		t.Errorf("forked %d times for the same line, want 1", n)
+	}
+	if words := complete(":test "); len(words) != 3 {
+		t.Errorf("completed %q, want all", words)
+	}
+	if n := forked(); n != 2 {
+		t.Errorf("forked %d times, want 2", n)
+	}
+
+	// the completions are forgotten after running
+	p.cc = &ChatCommand{}
+	p.command()
+	complete(":test al")
+	if n := forked(); n != 3 {
+		t.Errorf("forked %d times, want 3", n)
+	}
+}
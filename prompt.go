@@ -9,6 +9,7 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"os/signal"
 	"path"
 	"strings"
 
@@ -29,6 +30,8 @@ var defaultAwesomeRepos = []*AwesomeRepo{
 type PromptEntry struct {
 	Act    string
 	Prompt string
+	// Renderer is used instead of markdown if the renderer is not specified
+	Renderer string `json:",omitempty"`
 }
 type AwesomePrompts struct {
 	dir string
@@ -130,6 +133,16 @@ func (ap *AwesomePrompts) PromptText(act string) string {
 	return ""
 }
 
+// PromptRenderer returns the renderer of the prompt, it is empty if the
+// prompt does not specify one
+func (ap *AwesomePrompts) PromptRenderer(act string) string {
+	p := ap.dict[act]
+	if p != nil {
+		return p.Renderer
+	}
+	return ""
+}
+
 func (ap *AwesomePrompts) actasCommand() string {
 	opts := struct {
 		Role []string `cortana:"role, -, -"`
@@ -253,14 +266,26 @@ func (ar *AwesomeRepos) load() error {
 
 	return nil
 }
+
+// sync downloads the repos by the client of the api, so the proxy and the
+// TLS options are the same, it could be interrupted by Ctrl+C
 func (ar *AwesomeRepos) sync() error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
 	for _, repo := range ar.repos {
-		resp, err := ar.ap.cli.Get(repo.Url)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, repo.Url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := ar.ap.cli.Do(req)
 		if err != nil {
 			return err
 		}
 		r := resp.Body
 		defer r.Close() // there would not have too much repos, so it's ok to use defer
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("sync %s failed: %s", repo.Url, resp.Status)
+		}
 
 		data, err := io.ReadAll(r)
 		if err != nil {
@@ -2,18 +2,14 @@ package main
 
 import (
 	"bytes"
+	"fmt"
+	"path"
 
 	"github.com/charmbracelet/lipgloss"
-	"github.com/chzyer/readline"
+	"github.com/shafreeck/guru/lineedit"
 	"github.com/shafreeck/guru/tui"
 )
 
-type completer func(line []rune, pos int) ([][]rune, int)
-
-func (c completer) Do(line []rune, pos int) ([][]rune, int) {
-	return c(line, pos)
-}
-
 // {prefix} {delimiter} [suffix...]
 // for prefix=guru, delimiter = >, and suffix = >
 // the prompt string is:
@@ -52,34 +48,56 @@ func (lp *LivePrompt) Render() string {
 
 type Repl struct {
 	prompt *LivePrompt
+
+	// the histories are kept by the evaluation modes in the directory
+	historyDir string
+	histories  map[EvalMode]*lineedit.History
 }
 
-func NewRepl(lp *LivePrompt) *Repl {
-	return &Repl{prompt: lp}
+func NewRepl(lp *LivePrompt, historyDir string) *Repl {
+	return &Repl{prompt: lp, historyDir: historyDir, histories: make(map[EvalMode]*lineedit.History)}
 }
 
-func (repl *Repl) Loop(e *Evaluator) error {
-	rl, err := readline.NewEx(&readline.Config{
-		Prompt:         repl.prompt.Render(),
-		AutoComplete:   completer(completes.Complete),
-		Stdin:          tui.Stdin,
-		Stdout:         tui.Stdout,
-		Stderr:         tui.Stderr,
-		FuncIsTerminal: func() bool { return true },
-	})
+// the history files of the evaluation modes
+var historyNames = map[EvalMode]string{
+	ChatEval:           "chat",
+	SysCommandEval:     "shell",
+	BuiltinCommandEval: "builtin",
+}
+
+func (repl *Repl) history(mode EvalMode) *lineedit.History {
+	if h, ok := repl.histories[mode]; ok {
+		return h
+	}
+	h, err := lineedit.OpenHistory(path.Join(repl.historyDir, historyNames[mode]), 1000)
 	if err != nil {
-		return err
+		fmt.Fprintln(tui.Stderr, "load the history failed:", err)
 	}
-	defer rl.Close()
+	repl.histories[mode] = h
+	return h
+}
+
+func (repl *Repl) Loop(e *Evaluator) error {
+	ed := lineedit.New(lineedit.Config{
+		Stdin:        tui.Stdin,
+		Stdout:       tui.Stdout,
+		Width:        tui.Width,
+		AutoComplete: completes.Complete,
+	})
 
 	for {
-		rl.SetPrompt(repl.prompt.Render())
+		ed.SetPrompt(repl.prompt.Render())
+		h := repl.history(e.mode)
+		ed.SetHistory(h)
 
-		line, err := rl.Readline()
+		text, err := ed.Readline()
 		if err != nil {
 			break
 		}
-		e.eval(line)
+		if err := h.Add(text); err != nil {
+			fmt.Fprintln(tui.Stderr, "save the history failed:", err)
+		}
+		e.eval(text)
 	}
 	return nil
 }
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/shafreeck/guru/mock"
+	"github.com/shafreeck/guru/tui"
+)
+
+// recordReplay runs fn with the client of --record against the server, and
+// runs it again with the client of --replay after the server is closed, the
+// outputs of both runs should be the same
+func recordReplay(t *testing.T, h http.Handler, fn func(cli *http.Client, url string) string) string {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	dir := t.TempDir()
+	g := New(WithStdout(io.Discard))
+
+	recorded := fn(g.getHTTPClient(&ClientOptions{Record: dir}), srv.URL)
+	srv.Close()
+	replayed := fn(g.getHTTPClient(&ClientOptions{Replay: dir}), srv.URL)
+	if replayed != recorded {
+		t.Errorf("replayed %q, but recorded %q", replayed, recorded)
+	}
+	return replayed
+}
+
+// newTestChatCommand creates a chat command in a temp dir, the output of
+// the session is written to out
+func newTestChatCommand(t *testing.T, cli *http.Client, url string, out io.Writer) *ChatCommand {
+	t.Helper()
+	dir := t.TempDir()
+	sess := NewSession(dir, WithCommandOutput(New(WithStdout(out))))
+	if err := sess.Open(""); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sess.Close() })
+
+	opts := &ChatCommandOptions{Dir: dir}
+	c := NewChatGPTClient(cli, url+"/v1", "sk-test-key", &opts.ChatGPTOptions)
+	cc := NewChatCommand(sess, nil, c, opts)
+	cc.display = []tea.ProgramOption{tea.WithInput(strings.NewReader(""))}
+	return cc
+}
+
+var testScript = &mock.Script{Rules: []*mock.Rule{{Match: "^hello$", Reply: "Hello! How can I help you today?"}}}
+
+func TestChatCommandReplay(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		name := "ask"
+		if stream {
+			name = "stream"
+		}
+		t.Run(name, func(t *testing.T) {
+			output := recordReplay(t, mock.New(mock.WithScript(testScript)), func(cli *http.Client, url string) string {
+				var out bytes.Buffer
+				cc := newTestChatCommand(t, cli, url, &out)
+				reply, err := cc.Talk(&ChatOptions{ChatGPTOptions: ChatGPTOptions{Model: "gpt-test", Stream: stream},
+					Renderer: "markdown", NonInteractive: true, Text: "hello"})
+				if err != nil {
+					t.Fatal(err)
+				}
+				msgs := cc.sess.Messages()
+				if len(msgs) != 2 || msgs[1].Role != Assistant {
+					t.Fatalf("the reply is not appended to the session: %d messages", len(msgs))
+				}
+				// the reply returned is rendered if not streamed
+				if !strings.Contains(reply, msgs[1].Content) {
+					t.Errorf("reply %q, want %q", reply, msgs[1].Content)
+				}
+				return msgs[1].Content + "|" + out.String()
+			})
+			reply := "Hello! How can I help you today?"
+			if content, printed, _ := strings.Cut(output, "|"); content != reply || !strings.Contains(printed, reply) {
+				t.Errorf("output %q, want the reply", output)
+			}
+		})
+	}
+}
+
+func TestAwesomeReposSyncReplay(t *testing.T) {
+	const csv = "act,prompt\nLinux Terminal,I want you to act as a linux terminal.\n"
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/prompts.csv" {
+			http.NotFound(w, r)
+			return
+		}
+		io.WriteString(w, csv)
+	})
+
+	recordReplay(t, h, func(cli *http.Client, url string) string {
+		var out bytes.Buffer
+		dir := t.TempDir()
+		ap := NewAwesomePrompts(dir, cli, New(WithStdout(&out)))
+		ap.repos.repos = []*AwesomeRepo{{Url: url + "/prompts.csv", Format: "csv", Saveas: "prompts.csv"}}
+		if err := ap.repos.save(); err != nil {
+			t.Fatal(err)
+		}
+		if err := ap.repos.sync(); err != nil {
+			t.Fatal(err)
+		}
+		if err := ap.Load(); err != nil {
+			t.Fatal(err)
+		}
+		if p := ap.PromptText("Linux Terminal"); p != "I want you to act as a linux terminal." {
+			t.Errorf("prompt %q, want the synced one", p)
+		}
+		data, _ := os.ReadFile(path.Join(dir, "prompts.csv"))
+		if string(data) != csv {
+			t.Errorf("saved %q, want %q", data, csv)
+		}
+		return out.String()
+	})
+
+	// the repos not recorded fail offline
+	dir := t.TempDir()
+	ap := NewAwesomePrompts(t.TempDir(), New(WithStdout(io.Discard)).getHTTPClient(&ClientOptions{Replay: dir}), nil)
+	ap.repos.repos = []*AwesomeRepo{{Url: "http://127.0.0.1:1/other.csv", Format: "csv", Saveas: "other.csv"}}
+	if err := ap.repos.sync(); err == nil || !strings.Contains(err.Error(), "no interaction recorded") {
+		t.Errorf("err %v, want no interaction recorded", err)
+	}
+}
+
+// typist types a line per read, the lines read at once are pasted
+type typist struct {
+	lines []string
+}
+
+func (t *typist) Read(p []byte) (int, error) {
+	if len(t.lines) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, t.lines[0])
+	t.lines[0] = t.lines[0][n:]
+	if t.lines[0] == "" {
+		t.lines = t.lines[1:]
+	}
+	return n, nil
+}
+
+func TestReplReplay(t *testing.T) {
+	stdin, stdout := tui.Stdin, tui.Stdout
+	defer func() { tui.Stdin, tui.Stdout = stdin, stdout }()
+
+	recordReplay(t, mock.New(mock.WithScript(testScript)), func(cli *http.Client, url string) string {
+		var out bytes.Buffer
+		cc := newTestChatCommand(t, cli, url, &out)
+
+		// the lines are typed into the repl, which ends at EOF
+		tui.Stdin = io.NopCloser(&typist{lines: []string{"hello\r", "how are you\r"}})
+		tui.Stdout = io.Discard
+		var replies []string
+		e := NewEvaluator(cc.sess, &LivePrompt{Prefix: "guru", Delimiter: ">"}, func(text string) {
+			reply, err := cc.Talk(&ChatOptions{ChatGPTOptions: ChatGPTOptions{Model: "gpt-test", Stream: true},
+				Renderer: "markdown", NonInteractive: true, Text: text})
+			if err != nil {
+				t.Fatal(err)
+			}
+			replies = append(replies, reply)
+		})
+		if err := NewRepl(e.lp, t.TempDir()).Loop(e); err != nil {
+			t.Fatal(err)
+		}
+
+		// the context is sent, and the unmatched message is echoed
+		want := []string{"Hello! How can I help you today?", "how are you"}
+		if strings.Join(replies, "\n") != strings.Join(want, "\n") {
+			t.Errorf("replies %q, want %q", replies, want)
+		}
+		if n := len(cc.sess.Messages()); n != 4 {
+			t.Errorf("%d messages in the session, want 4", n)
+		}
+		return out.String()
+	})
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/shafreeck/guru/chat"
+	"github.com/shafreeck/guru/jsonschema"
+)
+
+// SchemaError is returned if the reply still does not match the schema
+// after asking again
+type SchemaError struct {
+	Errors []*jsonschema.Error
+}
+
+func (e *SchemaError) Error() string {
+	return "the reply does not match the schema:\n" + formatSchemaErrors(e.Errors)
+}
+
+func formatSchemaErrors(errs []*jsonschema.Error) string {
+	var lines []string
+	for _, e := range errs {
+		lines = append(lines, "  - "+e.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// question builds the question from the session messages, the schema is
+// sent as the response_format, or put into the messages if the api does
+// not support it
+func (c *ChatCommand) question(opts *ChatOptions) *Question {
+	q := &Question{
+		ChatGPTOptions: opts.ChatGPTOptions,
+		Messages:       c.sess.Messages(),
+	}
+	if len(opts.Corrections) > 0 {
+		q.Messages = append(append([]*Message{}, q.Messages...), opts.Corrections...)
+	}
+	if opts.Schema == nil {
+		return q
+	}
+	if opts.ResponseFormat {
+		q.ResponseFormat = &ResponseFormat{Type: "json_schema",
+			JSONSchema: &JSONSchemaFormat{Name: opts.Schema.Name(), Schema: opts.Schema.Raw()}}
+		return q
+	}
+	// copy the messages, so the instruction is not kept in the session
+	messages := make([]*Message, 0, len(q.Messages)+1)
+	messages = append(messages, q.Messages...)
+	q.Messages = append(messages, &Message{Role: System, Content: "Reply only a JSON value without any explanation " +
+		"or markdown code fence, the JSON must match the JSON Schema:\n" + string(opts.Schema.Raw())})
+	return q
+}
+
+// talkWithSchema validates the reply against the schema, and asks again
+// with the validation errors if the reply is invalid. The reply is shown
+// once it is validated, and the invalid ones are not kept in the session
+func (c *ChatCommand) talkWithSchema(opts *ChatOptions) (string, error) {
+	// the reply is validated as a whole, there is nothing to stream
+	o := *opts
+	o.Stream, o.Quiet, o.Corrections = false, true, nil
+	for retries := 0; ; {
+		reply, err := c.talk(&o)
+		if err != nil && o.ResponseFormat && isResponseFormatUnsupported(err) {
+			c.sess.out.Errorln("response_format is not supported, put the schema into the messages instead")
+			o.ResponseFormat, opts.ResponseFormat = false, false
+			continue
+		}
+		if err != nil {
+			return reply, err
+		}
+
+		data, errs := validateReply(opts.Schema, reply)
+		if len(errs) == 0 {
+			indented := bytes.NewBuffer(nil)
+			json.Indent(indented, data, "", "  ")
+			if c.emitter != nil {
+				c.emitter.Validated(data)
+				return indented.String(), nil
+			}
+			// the json is rendered as it is, not reflowed as markdown
+			renderer := opts.Renderer
+			if renderer == "markdown" {
+				renderer = "json"
+			}
+			ctx := c.ctx
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			return c.show(ctx, indented.String(), renderer)
+		}
+		// the invalid reply is sent with the errors when asking again
+		c.sess.DropLast()
+		if retries >= opts.SchemaRetries {
+			return reply, &SchemaError{Errors: errs}
+		}
+		retries++
+
+		feedback := formatSchemaErrors(errs)
+		c.sess.out.Errorln(fmt.Sprintf("the reply does not match the schema, asking again(%d/%d):\n%s",
+			retries, opts.SchemaRetries, feedback))
+		if c.emitter != nil {
+			c.emitter.Retry(feedback)
+		}
+		o.Corrections = []*Message{{Role: Assistant, Content: reply},
+			{Role: User, Content: "Your reply does not match the JSON Schema:\n" + feedback +
+				"\n\nReply again with only the JSON value which matches the schema."}}
+	}
+}
+
+// validateReply extracts the json from the reply and validates it
+func validateReply(schema *jsonschema.Schema, reply string) ([]byte, []*jsonschema.Error) {
+	data := extractJSON(reply)
+	if !json.Valid(data) {
+		return nil, []*jsonschema.Error{{Message: "the reply is not a valid JSON"}}
+	}
+	return data, schema.ValidateJSON(data)
+}
+
+// extractJSON strips the markdown code fence or the text around the json
+func extractJSON(reply string) []byte {
+	text := strings.TrimSpace(reply)
+	if strings.HasPrefix(text, "```") {
+		// remove the fence line like ```json and the closing fence
+		if i := strings.IndexByte(text, '\n'); i >= 0 {
+			text = text[i+1:]
+		}
+		text = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(text), "```"))
+	}
+	if json.Valid([]byte(text)) {
+		return []byte(text)
+	}
+	// the model may explain before or after the json
+	begin := strings.IndexAny(text, "{[")
+	end := strings.LastIndexAny(text, "}]")
+	if begin >= 0 && end > begin {
+		return []byte(text[begin : end+1])
+	}
+	return []byte(text)
+}
+
+// isResponseFormatUnsupported reports if the api rejects the response_format
+func isResponseFormatUnsupported(err error) bool {
+	var e *chat.Error
+	return errors.As(err, &e) && strings.Contains(e.Message, "response_format")
+}
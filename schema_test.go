@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/shafreeck/guru/chat"
+	"github.com/shafreeck/guru/jsonschema"
+	"github.com/shafreeck/guru/mock"
+)
+
+func TestTalkWithSchema(t *testing.T) {
+	script := &mock.Script{Rules: []*mock.Rule{
+		{Match: "does not match the JSON Schema", Reply: "```json\n{\"name\": \"guru\", \"stars\": 3}\n```"},
+		{Reply: `Sure! {"name": 1}`},
+	}}
+	m := mock.New(mock.WithScript(script))
+	var mu sync.Mutex
+	var questions []*Question
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		q := &Question{}
+		json.Unmarshal(data, q)
+		mu.Lock()
+		questions = append(questions, q)
+		mu.Unlock()
+		r.Body = io.NopCloser(bytes.NewReader(data))
+		m.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	schema, err := jsonschema.Compile("repo", []byte(`{"type": "object", "required": ["name", "stars"],
+		"properties": {"name": {"type": "string"}, "stars": {"type": "integer"}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	var out bytes.Buffer
+	sess := NewSession(dir, WithCommandOutput(New(WithStdout(&out))))
+	if err := sess.Open(""); err != nil {
+		t.Fatal(err)
+	}
+	defer sess.Close()
+	opts := &ChatOptions{NonInteractive: true, Renderer: "markdown", SchemaRetries: 2, Schema: schema, Text: "describe guru"}
+	opts.Model, opts.Stream = "gpt-test", true
+	var shown []string
+	c := &ChatCommand{sess: sess, ledger: NewLedger(dir), sink: func(text string) { shown = append(shown, text) },
+		c: NewChatGPTClient(http.DefaultClient, srv.URL+"/v1", "sk-test-key", &opts.ChatGPTOptions, chat.WithRetry(0, 0, 0))}
+
+	reply, err := c.Talk(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "{\n  \"name\": \"guru\",\n  \"stars\": 3\n}"
+	// only the validated json is shown, pretty printed
+	if reply != want || len(shown) != 1 || shown[0] != want {
+		t.Errorf("the reply is %q, shown %q, want %q", reply, shown, want)
+	}
+	if !strings.Contains(out.String(), "asking again(1/2)") {
+		t.Errorf("the retry is not reported: %q", out.String())
+	}
+
+	// the invalid reply is sent with the errors, but not kept in the session
+	if len(questions) != 2 {
+		t.Fatalf("%d questions, want 2", len(questions))
+	}
+	if questions[0].Stream || questions[1].Stream {
+		t.Error("the reply to validate is streamed")
+	}
+	// the question, the invalid reply, the errors and the schema instruction
+	retry := questions[1].Messages
+	if len(retry) != 4 || retry[1].Content != `Sure! {"name": 1}` ||
+		!strings.Contains(retry[2].Content, "does not match the JSON Schema") || retry[3].Role != System {
+		t.Errorf("the retry question is %+v", retry)
+	}
+	var contents []string
+	for _, m := range sess.Messages() {
+		contents = append(contents, string(m.Role)+": "+m.Content)
+	}
+	if got := strings.Join(contents, "|"); got != "user: describe guru|assistant: ```json\n{\"name\": \"guru\", \"stars\": 3}\n```" {
+		t.Errorf("the session keeps %q", got)
+	}
+}
+
+func TestTalkWithSchemaExhausted(t *testing.T) {
+	srv := httptest.NewServer(mock.New(mock.WithScript(&mock.Script{Rules: []*mock.Rule{{Reply: "no json here"}}})))
+	defer srv.Close()
+	schema, err := jsonschema.Compile("any", []byte(`{"type": "object"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	sess := NewSession(dir, WithCommandOutput(New(WithStdout(io.Discard))))
+	if err := sess.Open(""); err != nil {
+		t.Fatal(err)
+	}
+	defer sess.Close()
+	opts := &ChatOptions{NonInteractive: true, SchemaRetries: 1, Schema: schema, Text: "describe guru"}
+	opts.Model = "gpt-test"
+	c := &ChatCommand{sess: sess, ledger: NewLedger(dir), sink: func(string) { t.Error("the invalid reply is shown") },
+		c: NewChatGPTClient(http.DefaultClient, srv.URL+"/v1", "sk-test-key", &opts.ChatGPTOptions, chat.WithRetry(0, 0, 0))}
+
+	_, err = c.Talk(opts)
+	if exitCode(err) != ExitSchemaInvalid {
+		t.Errorf("talk returns %v, want the schema error", err)
+	}
+	if messages := sess.Messages(); len(messages) != 1 || messages[0].Role != User {
+		t.Errorf("the invalid replies are kept: %+v", messages)
+	}
+}
@@ -22,6 +22,46 @@ type record struct {
 	Op     string
 	Msg    *Message
 	Offset int64
+
+	// Alternates are the candidates not chosen when
+	// more than one choice is replied
+	Alternates []*Message `json:",omitempty"`
+	// Truncated is true if the message is interrupted before finished
+	Truncated bool `json:",omitempty"`
+	// Usage is the usage of the request which replies the message
+	Usage *UsageEntry `json:",omitempty"`
+	// Endpoint is the name of the endpoint replied the message
+	Endpoint string `json:",omitempty"`
+}
+
+type recordOption func(r *record)
+
+// withAlternates keeps the candidates not chosen in the record
+func withAlternates(alternates []*Message) recordOption {
+	return func(r *record) {
+		r.Alternates = alternates
+	}
+}
+
+// truncated marks the message in the record as truncated
+func truncated() recordOption {
+	return func(r *record) {
+		r.Truncated = true
+	}
+}
+
+// withUsage keeps the usage of the request in the record
+func withUsage(u *UsageEntry) recordOption {
+	return func(r *record) {
+		r.Usage = u
+	}
+}
+
+// withEndpoint keeps the endpoint replied the message in the record
+func withEndpoint(name string) recordOption {
+	return func(r *record) {
+		r.Endpoint = name
+	}
 }
 
 type history struct {
@@ -36,8 +76,11 @@ func (h *history) Write(data []byte) (n int, err error) {
 	return n, err
 }
 
-func (h *history) append(op string, v *Message) error {
+func (h *history) append(op string, v *Message, opts ...recordOption) error {
 	r := &record{Op: op, Msg: v, Offset: h.offset}
+	for _, opt := range opts {
+		opt(r)
+	}
 	data, err := json.Marshal(r)
 	if err != nil {
 		return err
@@ -76,12 +119,11 @@ func WithHighlightStyle(style lipgloss.Style) SessionOption {
 }
 
 func NewSession(dir string, opts ...SessionOption) *Session {
-	blue := lipgloss.NewStyle().Foreground(lipgloss.Color("#2da9d2"))
-
+	theme := tui.CurrentTheme()
 	s := &Session{
 		dir:       dir,
 		out:       &commandStdout{},
-		highlight: blue,
+		highlight: theme.Style(theme.Accent),
 	}
 
 	for _, opt := range opts {
@@ -158,6 +200,28 @@ func (s *Session) Append(m *Message, pin ...bool) {
 	}
 }
 
+// AppendReply appends the message replied by the api, the details
+// such as the alternates or usage are kept in the history
+func (s *Session) AppendReply(m *Message, opts ...recordOption) {
+	s.mm.append(m)
+	if err := s.history.append(":append", m, opts...); err != nil {
+		s.out.Errorln(err)
+	}
+}
+
+// DropLast removes the last message, like an invalid reply which is not
+// kept, the command is saved to be replayed when loaded
+func (s *Session) DropLast() {
+	n := len(s.mm.messages)
+	if n == 0 {
+		return
+	}
+	s.mm.messages = s.mm.messages[:n-1]
+	if err := s.history.append(fmt.Sprintf(":message delete %d", n-1), nil); err != nil {
+		s.out.Errorln(err)
+	}
+}
+
 func (s *Session) LastSessionID() string {
 	last := path.Join(path.Dir(s.dir), "last")
 	target, _ := os.Readlink(last)
@@ -314,6 +378,49 @@ func (s *Session) newCommand() string {
 	return strings.Join(opts.Texts, " ")
 }
 
+// forkCommand creates a new session with the messages of the current one
+func (s *Session) forkCommand() (_ string) {
+	opts := struct {
+		SID string `cortana:"--session-id, -s, ,the session id"`
+	}{}
+	if usage := builtins.Parse(&opts); usage {
+		return
+	}
+
+	if opts.SID != "" {
+		if _, err := os.Stat(path.Join(s.dir, opts.SID)); err == nil {
+			s.out.Errorln("session \"" + opts.SID + "\" exist")
+			return
+		}
+	}
+
+	messages, pinned := s.mm.messages, s.mm.pinned
+	from := s.sid
+	s.switchSession(opts.SID)
+	for i, m := range messages {
+		s.Append(m, pinned[m])
+		// the pin is saved as the command replayed when loaded
+		if pinned[m] {
+			if err := s.history.append(fmt.Sprintf(":message pin %d", i), nil); err != nil {
+				s.out.Errorln(err)
+			}
+		}
+	}
+	s.out.Println("session " + s.sid + " forked from " + from)
+	return
+}
+
+// usage sums the tokens and the cost of the replies in the session
+func (s *Session) usage() (tokens int, cost float64) {
+	for _, r := range s.history.records {
+		if r.Usage != nil {
+			tokens += r.Usage.TotalTokens
+			cost += r.Usage.Cost
+		}
+	}
+	return
+}
+
 func (s *Session) shrinkCommand() (_ string) {
 	opts := struct {
 		Expr string `cortana:"expr"`
@@ -452,6 +559,7 @@ func (s *Session) registerBuiltinCommands() {
 	builtins.AddCommand(":session shrink", s.shrinkCommand, "shrink sessions")
 	builtins.AddCommand(":session list", s.listCommand, "list sessions")
 	builtins.AddCommand(":session switch", s.switchCommand, "switch a session")
+	builtins.AddCommand(":session fork", s.forkCommand, "create a new session with the current messages")
 	builtins.AddCommand(":session history", s.historyCommand, "print history of current session")
 	builtins.AddCommand(":session stack", s.stackShowCommand, "show the session stack")
 	builtins.AddCommand(":session stack push", s.stackPushCommand, "create a new session, and stash the current")
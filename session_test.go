@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestForkCommand(t *testing.T) {
+	var out bytes.Buffer
+	dir := t.TempDir()
+	sess := NewSession(dir, WithCommandOutput(New(WithStdout(&out))))
+	if err := sess.Open(""); err != nil {
+		t.Fatal(err)
+	}
+	defer sess.Close()
+	sess.Append(&Message{Role: System, Content: "you are guru"})
+	sess.Append(&Message{Role: User, Content: "hello"})
+	sess.AppendReply(&Message{Role: Assistant, Content: "hi"})
+	builtins.Launch([]string{":message", "pin", "0"})
+	from := sess.sid
+
+	// the session existing is not overwritten
+	builtins.Launch([]string{":session", "fork", "-s", from})
+	if sess.sid != from || !strings.Contains(out.String(), "exist") {
+		t.Fatalf("forked to %q: %s", sess.sid, out.String())
+	}
+
+	builtins.Launch([]string{":session", "fork", "-s", "forked"})
+	if sess.sid != "forked" || !strings.Contains(out.String(), "session forked forked from "+from) {
+		t.Fatalf("forked to %q: %s", sess.sid, out.String())
+	}
+	check := func(s *Session) {
+		t.Helper()
+		msgs := s.Messages()
+		if len(msgs) != 3 || msgs[0].Content != "you are guru" || msgs[2].Content != "hi" {
+			t.Fatalf("%d messages in the session %s", len(msgs), s.sid)
+		}
+		if !s.mm.pinned[msgs[0]] || s.mm.pinned[msgs[1]] {
+			t.Errorf("the pinned messages are not kept in the session %s", s.sid)
+		}
+	}
+	check(sess)
+
+	// the forked session is saved, and the original one is kept
+	sess.Append(&Message{Role: User, Content: "more"})
+	for _, sid := range []string{"forked", from} {
+		if _, err := os.Stat(path.Join(dir, sid)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sess.switchSession(from)
+	check(sess)
+	sess.switchSession("forked")
+	if msgs := sess.Messages(); len(msgs) != 4 || !sess.mm.pinned[msgs[0]] {
+		t.Fatalf("%d messages in the forked session, want 4 with the first pinned", len(msgs))
+	}
+}
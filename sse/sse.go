@@ -0,0 +1,182 @@
+// Package sse decodes and encodes the Server-Sent Events stream
+// defined by https://html.spec.whatwg.org/multipage/server-sent-events.html
+package sse
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultEventType is the type of an event without the event field
+const DefaultEventType = "message"
+
+// Event is a dispatched event
+type Event struct {
+	ID    string        // the last event id when the event is dispatched
+	Type  string        // the event type, "message" if not set
+	Data  string        // the data lines joined by "\n"
+	Retry time.Duration // the reconnection time set by the stream, 0 if not set
+}
+
+// Decoder reads events from a stream
+type Decoder struct {
+	r      *bufio.Reader
+	line   bytes.Buffer
+	bom    bool // the leading BOM has been checked
+	lastCR bool // the last line ends with "\r", a following "\n" is dropped
+	lastID string
+	retry  time.Duration
+}
+
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r)}
+}
+
+// LastEventID returns the last event id, which should be sent
+// as the Last-Event-ID header when reconnecting
+func (d *Decoder) LastEventID() string {
+	return d.lastID
+}
+
+// Retry returns the reconnection time set by the stream, 0 if not set
+func (d *Decoder) Retry() time.Duration {
+	return d.retry
+}
+
+// Decode reads the next event, io.EOF is returned when the stream ends.
+// The incomplete event at the end of the stream is discarded.
+func (d *Decoder) Decode() (*Event, error) {
+	var typ string
+	var data bytes.Buffer
+	hasData := false
+
+	for {
+		line, err := d.readLine()
+		if err != nil {
+			return nil, err
+		}
+
+		// dispatch the event on a blank line
+		if line == "" {
+			if !hasData {
+				typ = ""
+				continue
+			}
+			if typ == "" {
+				typ = DefaultEventType
+			}
+			// remove the last "\n" appended by the data field
+			text := strings.TrimSuffix(data.String(), "\n")
+			return &Event{ID: d.lastID, Type: typ, Data: text, Retry: d.retry}, nil
+		}
+
+		// ignore the comments
+		if line[0] == ':' {
+			continue
+		}
+
+		field, value := line, ""
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			field, value = line[:i], line[i+1:]
+			value = strings.TrimPrefix(value, " ")
+		}
+
+		switch field {
+		case "event":
+			typ = value
+		case "data":
+			data.WriteString(value)
+			data.WriteByte('\n')
+			hasData = true
+		case "id":
+			if !strings.ContainsRune(value, 0) {
+				d.lastID = value
+			}
+		case "retry":
+			if ms, err := strconv.ParseUint(value, 10, 63); err == nil {
+				d.retry = time.Duration(ms) * time.Millisecond
+			}
+		default:
+			// unknown fields are ignored
+		}
+	}
+}
+
+// readLine reads a line terminated by "\r\n", "\n" or "\r", the line
+// is not limited in length
+func (d *Decoder) readLine() (string, error) {
+	d.line.Reset()
+	for {
+		c, err := d.r.ReadByte()
+		if err == io.EOF && d.line.Len() > 0 {
+			// the stream ends without a line terminator, the line is
+			// returned and the pending event would be discarded later
+			return d.text(), nil
+		}
+		if err != nil {
+			return "", err
+		}
+
+		// the "\n" of "\r\n" is dropped when it arrives, rather than peeked,
+		// which would block the line until the next byte is sent
+		lastCR := d.lastCR
+		d.lastCR = false
+		switch c {
+		case '\n':
+			if lastCR {
+				continue
+			}
+			return d.text(), nil
+		case '\r':
+			d.lastCR = true
+			return d.text(), nil
+		default:
+			d.line.WriteByte(c)
+		}
+	}
+}
+
+// text returns the current line with the leading BOM stripped
+func (d *Decoder) text() string {
+	line := d.line.String()
+	if !d.bom {
+		d.bom = true
+		line = strings.TrimPrefix(line, "\ufeff")
+	}
+	return line
+}
+
+// Encoder writes events to a stream
+type Encoder struct {
+	w io.Writer
+}
+
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encode writes the event, the Type is omitted if it is "message" or empty
+func (e *Encoder) Encode(ev *Event) error {
+	var b strings.Builder
+	if ev.ID != "" {
+		fmt.Fprintf(&b, "id: %s\n", ev.ID)
+	}
+	if ev.Type != "" && ev.Type != DefaultEventType {
+		fmt.Fprintf(&b, "event: %s\n", ev.Type)
+	}
+	if ev.Retry > 0 {
+		fmt.Fprintf(&b, "retry: %d\n", ev.Retry.Milliseconds())
+	}
+	for _, line := range strings.Split(ev.Data, "\n") {
+		fmt.Fprintf(&b, "data: %s\n", line)
+	}
+	b.WriteByte('\n')
+
+	_, err := io.WriteString(e.w, b.String())
+	return err
+}
@@ -0,0 +1,233 @@
+package sse
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func decodeAll(t *testing.T, r io.Reader) ([]*Event, *Decoder) {
+	t.Helper()
+	d := NewDecoder(r)
+	var events []*Event
+	for {
+		ev, err := d.Decode()
+		if err == io.EOF {
+			return events, d
+		}
+		if err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		events = append(events, ev)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	cases := []struct {
+		name   string
+		stream string
+		events []*Event
+	}{
+		{
+			name:   "single data",
+			stream: "data: hello\n\n",
+			events: []*Event{{Type: "message", Data: "hello"}},
+		},
+		{
+			name:   "multi-line data",
+			stream: "data: a\ndata: b\ndata\ndata: c\n\n",
+			events: []*Event{{Type: "message", Data: "a\nb\n\nc"}},
+		},
+		{
+			name:   "crlf",
+			stream: "data: a\r\ndata: b\r\n\r\ndata: c\r\n\r\n",
+			events: []*Event{{Type: "message", Data: "a\nb"}, {Type: "message", Data: "c"}},
+		},
+		{
+			name:   "cr",
+			stream: "data: a\rdata: b\r\rdata: c\r\r",
+			events: []*Event{{Type: "message", Data: "a\nb"}, {Type: "message", Data: "c"}},
+		},
+		{
+			name:   "comments",
+			stream: ": keep alive\ndata: a\n: inside\ndata: b\n\n:\n\n",
+			events: []*Event{{Type: "message", Data: "a\nb"}},
+		},
+		{
+			name:   "event type",
+			stream: "event: content_block_delta\ndata: {}\n\ndata: x\n\n",
+			events: []*Event{{Type: "content_block_delta", Data: "{}"}, {Type: "message", Data: "x"}},
+		},
+		{
+			name:   "event type without data is dropped",
+			stream: "event: ping\n\ndata: x\n\n",
+			events: []*Event{{Type: "message", Data: "x"}},
+		},
+		{
+			name:   "id is kept",
+			stream: "id: 1\ndata: a\n\ndata: b\n\nid\ndata: c\n\n",
+			events: []*Event{{ID: "1", Type: "message", Data: "a"}, {ID: "1", Type: "message", Data: "b"},
+				{Type: "message", Data: "c"}},
+		},
+		{
+			name:   "id with null is ignored",
+			stream: "id: 1\ndata: a\n\nid: 2\x00\ndata: b\n\n",
+			events: []*Event{{ID: "1", Type: "message", Data: "a"}, {ID: "1", Type: "message", Data: "b"}},
+		},
+		{
+			name:   "retry",
+			stream: "retry: 1500\ndata: a\n\nretry: bad\ndata: b\n\n",
+			events: []*Event{{Type: "message", Data: "a", Retry: 1500 * time.Millisecond},
+				{Type: "message", Data: "b", Retry: 1500 * time.Millisecond}},
+		},
+		{
+			name:   "value without space",
+			stream: "data:a\ndata:  b\n\n",
+			events: []*Event{{Type: "message", Data: "a\n b"}},
+		},
+		{
+			name:   "unknown field",
+			stream: "foo: bar\ndata: a\n\n",
+			events: []*Event{{Type: "message", Data: "a"}},
+		},
+		{
+			name:   "bom",
+			stream: "\ufeffdata: a\n\n",
+			events: []*Event{{Type: "message", Data: "a"}},
+		},
+		{
+			name:   "bom only at the beginning",
+			stream: "data: a\n\n\ufeffdata: b\n\n",
+			events: []*Event{{Type: "message", Data: "a"}},
+		},
+		{
+			name:   "final event without blank line is discarded",
+			stream: "data: a\n\ndata: b\n",
+			events: []*Event{{Type: "message", Data: "a"}},
+		},
+		{
+			name:   "final line without terminator is discarded",
+			stream: "data: a\n\ndata: b",
+			events: []*Event{{Type: "message", Data: "a"}},
+		},
+		{
+			name:   "long line",
+			stream: "data: " + strings.Repeat("x", 1<<17) + "\n\n",
+			events: []*Event{{Type: "message", Data: strings.Repeat("x", 1<<17)}},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			events, _ := decodeAll(t, strings.NewReader(c.stream))
+			if !reflect.DeepEqual(events, c.events) {
+				t.Errorf("got %s, want %s", dump(events), dump(c.events))
+			}
+			// the same events are decoded if the stream is read byte by byte,
+			// which cuts the lines and the "\r\n" in the middle
+			events, _ = decodeAll(t, iotest.OneByteReader(strings.NewReader(c.stream)))
+			if !reflect.DeepEqual(events, c.events) {
+				t.Errorf("read by byte: got %s, want %s", dump(events), dump(c.events))
+			}
+		})
+	}
+}
+
+// chunkReader returns the chunks one per read
+type chunkReader struct {
+	chunks []string
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if len(r.chunks) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, r.chunks[0])
+	r.chunks[0] = r.chunks[0][n:]
+	if r.chunks[0] == "" {
+		r.chunks = r.chunks[1:]
+	}
+	return n, nil
+}
+
+func TestDecodePartialRead(t *testing.T) {
+	r := &chunkReader{chunks: []string{"id: 4", "2\r", "\ndata: hel", "lo\r", "\r", "data: world\n", "\n"}}
+	events, d := decodeAll(t, r)
+	want := []*Event{{ID: "42", Type: "message", Data: "hello"}, {ID: "42", Type: "message", Data: "world"}}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("got %s, want %s", dump(events), dump(want))
+	}
+	if d.LastEventID() != "42" {
+		t.Errorf("last event id %q, want 42", d.LastEventID())
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	events := []*Event{
+		{Type: "message", Data: "a\nb"},
+		{ID: "7", Type: "content_block_delta", Data: `{"text":"x"}`, Retry: 2 * time.Second},
+		{ID: "7", Type: "message", Data: "", Retry: 2 * time.Second},
+	}
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	for _, ev := range events {
+		if err := enc.Encode(ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, _ := decodeAll(t, &buf)
+	if !reflect.DeepEqual(got, events) {
+		t.Errorf("got %s, want %s", dump(got), dump(events))
+	}
+}
+
+func dump(events []*Event) string {
+	var b strings.Builder
+	for _, ev := range events {
+		b.WriteString(strings.ReplaceAll(
+			"{id="+ev.ID+" type="+ev.Type+" data="+ev.Data+" retry="+ev.Retry.String()+"}", "\n", `\n`))
+	}
+	return "[" + b.String() + "]"
+}
+
+func TestDecodeCRNotBlocked(t *testing.T) {
+	r, w := io.Pipe()
+	defer w.Close()
+	d := NewDecoder(r)
+	events := make(chan *Event)
+	go func() {
+		defer close(events)
+		for {
+			ev, err := d.Decode()
+			if err != nil {
+				return
+			}
+			events <- ev
+		}
+	}()
+
+	// the event ends by "\r\r", it is dispatched before the next byte
+	go w.Write([]byte("data: first\r\r"))
+	select {
+	case ev := <-events:
+		if ev.Data != "first" {
+			t.Errorf("data %q, want first", ev.Data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the event is blocked until the next byte")
+	}
+
+	// the "\n" following "\r" is the end of the same line
+	go w.Write([]byte("\ndata: second\r\n\r\n"))
+	select {
+	case ev := <-events:
+		if ev.Data != "second" {
+			t.Errorf("data %q, want second", ev.Data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the second event is not dispatched")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"log"
 	"os"
 	"strings"
+	"sync/atomic"
 
 	"github.com/charmbracelet/lipgloss"
 	"github.com/charmbracelet/ssh"
@@ -116,6 +117,23 @@ func outputFromSession(s ssh.Session) *termenv.Output {
 
 func (g *guruSSHServer) handle(sess ssh.Session) {
 	out := outputFromSession(sess)
+	// follow the size of the remote terminal
+	sshPty, winCh, isPty := sess.Pty()
+	var width, height atomic.Int64
+	width.Store(int64(sshPty.Window.Width))
+	height.Store(int64(sshPty.Window.Height))
+	// the channel is nil without a pty, which would block forever
+	if isPty {
+		go func() {
+			for w := range winCh {
+				width.Store(int64(w.Width))
+				height.Store(int64(w.Height))
+				tui.Resize(w.Width, w.Height)
+			}
+		}()
+	}
+	tui.Width = func() int { return int(width.Load()) }
+	tui.Height = func() int { return int(height.Load()) }
 	tui.Stdin = sess
 	tui.Stdout = out
 	tui.Stderr = out
@@ -123,7 +141,12 @@ func (g *guruSSHServer) handle(sess ssh.Session) {
 	builtins.Use(cortana.WithStderr(sess))
 	cortana.Use(cortana.WithStdout(sess))
 	cortana.Use(cortana.WithStderr(sess))
-	lipgloss.SetColorProfile(out.ColorProfile())
+	// NO_COLOR of the remote is respected, and the themes are picked by the
+	// background of the remote terminal, which is asked through the session
+	lipgloss.SetColorProfile(out.EnvColorProfile())
+	if isPty {
+		lipgloss.SetHasDarkBackground(out.HasDarkBackground())
+	}
 
 	args := sess.Command()
 	if len(args) == 0 {
@@ -131,7 +154,8 @@ func (g *guruSSHServer) handle(sess ssh.Session) {
 	}
 	// filter the serve self
 	if args[0] == "serve" {
-		fmt.Sprintln(sess, "serve command is not supported in the sshapp mode")
+		fmt.Fprintln(sess, "serve command is not supported in the sshapp mode")
+		return
 	}
 	builtins.AddCommand(":exit", func() string {
 		sess.Close()
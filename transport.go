@@ -0,0 +1,108 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// newTransport returns the transport with the proxy and the TLS options, the
+// proxy is dialed with the context of the request, so it is canceled on
+// timeout or Ctrl+C
+func newTransport(opts *ClientOptions) (*http.Transport, error) {
+	tlsConf, err := tlsConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	proxy, err := proxyFunc(opts)
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxy
+	if tlsConf != nil {
+		transport.TLSClientConfig = tlsConf
+	}
+	return transport, nil
+}
+
+// proxyFunc returns the proxy of the request by --proxy or the environment
+// variables like HTTPS_PROXY, the hosts in no-proxy or NO_PROXY are not proxied
+func proxyFunc(opts *ClientOptions) (func(*http.Request) (*url.URL, error), error) {
+	conf := httpproxy.FromEnvironment()
+	proxy := opts.Proxy
+	// --socks5 is kept for compatibility
+	if proxy == "" && opts.Socks5 != "" {
+		proxy = "socks5://" + opts.Socks5
+	}
+	if proxy != "" {
+		u, err := url.Parse(proxy)
+		if err != nil {
+			// the error has the url, whose password is redacted as well
+			return nil, fmt.Errorf("invalid proxy %s: %s", redact(proxy), redact(err.Error()))
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return nil, fmt.Errorf("unsupported proxy %s, should be http://, https:// or socks5://", redact(proxy))
+		}
+		// the password of the proxy is redacted in the messages
+		if p, ok := u.User.Password(); ok {
+			addSecret(p)
+		}
+		conf.HTTPProxy, conf.HTTPSProxy = proxy, proxy
+	}
+	if opts.NoProxy != "" {
+		conf.NoProxy = opts.NoProxy
+	}
+	fn := conf.ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return fn(req.URL)
+	}, nil
+}
+
+// tlsConfig loads the CA bundle and the client certificate, it is nil if
+// neither is set
+func tlsConfig(opts *ClientOptions) (*tls.Config, error) {
+	if opts.CACert == "" && opts.ClientCert == "" && opts.ClientKey == "" {
+		return nil, nil
+	}
+	conf := &tls.Config{}
+	if opts.CACert != "" {
+		data, err := os.ReadFile(expandPath(opts.CACert))
+		if err != nil {
+			return nil, err
+		}
+		// the CA bundle is trusted besides the system ones
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %s", opts.CACert)
+		}
+		conf.RootCAs = pool
+	}
+	if opts.ClientCert != "" || opts.ClientKey != "" {
+		if opts.ClientCert == "" {
+			return nil, errors.New("client-key is set without client-cert")
+		}
+		// the key could be in the same file as the certificate
+		key := opts.ClientKey
+		if key == "" {
+			key = opts.ClientCert
+		}
+		cert, err := tls.LoadX509KeyPair(expandPath(opts.ClientCert), expandPath(key))
+		if err != nil {
+			return nil, fmt.Errorf("load the client certificate failed: %w", err)
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	return conf, nil
+}
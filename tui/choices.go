@@ -0,0 +1,94 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+var _ Model[int] = &ChoicesModel{}
+
+// ChoicesModel shows the candidates in tabs and returns
+// the index of the selected one
+type ChoicesModel struct {
+	choices  []string
+	renderer Renderer
+	index    int
+	selected bool
+	quiting  bool
+}
+
+func NewChoicesModel(choices []string, rendererName string) *ChoicesModel {
+	return &ChoicesModel{choices: choices, renderer: NewRenderer(rendererName)}
+}
+
+func (m *ChoicesModel) Init() tea.Cmd {
+	return nil
+}
+
+func (m *ChoicesModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "ctrl+c", "esc", "q":
+			// keep the first candidate if no one is selected
+			m.index = 0
+			m.quiting = true
+			return m, tea.Quit
+		case "enter":
+			m.selected = true
+			m.quiting = true
+			return m, tea.Quit
+		case "tab", "right", "l", "n":
+			m.index = (m.index + 1) % len(m.choices)
+		case "shift+tab", "left", "h", "p":
+			m.index = (m.index - 1 + len(m.choices)) % len(m.choices)
+		default:
+			// jump to the candidate by its number
+			s := msg.String()
+			if len(s) == 1 && s[0] >= '1' && s[0] <= '9' {
+				if i := int(s[0] - '1'); i < len(m.choices) {
+					m.index = i
+				}
+			}
+		}
+	}
+	return m, nil
+}
+
+func (m *ChoicesModel) View() string {
+	if m.quiting {
+		return ""
+	}
+	var b strings.Builder
+
+	var tabs []string
+	for i := range m.choices {
+		if i == m.index {
+			tabs = append(tabs, focusedStyle().Render(fmt.Sprintf("[ %d ]", i+1)))
+			continue
+		}
+		tabs = append(tabs, fmt.Sprintf("[ %s ]", blurredStyle().Render(fmt.Sprint(i+1))))
+	}
+	b.WriteString(strings.Join(tabs, " "))
+	b.WriteString("\n")
+
+	text, err := m.renderer.Render(m.choices[m.index])
+	if err != nil {
+		text = err.Error()
+	}
+	b.WriteString(text)
+	b.WriteString("\n")
+	b.WriteString(helpStyle().Render("(tab or ←/→ to switch, enter to select, ctrl+c, esc or q to keep the first)"))
+
+	return b.String()
+}
+
+func (m *ChoicesModel) Value() int {
+	return m.index
+}
+
+func (m *ChoicesModel) Error() error {
+	return nil
+}
@@ -23,10 +23,10 @@ type ConfirmModel struct {
 func NewConfimModel(prompt string) *ConfirmModel {
 	buttons := []string{"Yes", "No"}
 	focusedRender := func(button string) string {
-		return focusedStyle.Copy().Render(fmt.Sprintf("[ %s ]", button))
+		return focusedStyle().Render(fmt.Sprintf("[ %s ]", button))
 	}
 	blurredRender := func(button string) string {
-		return fmt.Sprintf("[ %s ]", blurredStyle.Render(button))
+		return fmt.Sprintf("[ %s ]", blurredStyle().Render(button))
 	}
 	return &ConfirmModel{prompt: prompt, buttons: buttons,
 		focusedRender: focusedRender, blurredRender: blurredRender}
@@ -90,7 +90,7 @@ func (m *ConfirmModel) View() string {
 	}
 
 	fmt.Fprintf(&b, "%s  %s\n\n", buttons[0], buttons[1])
-	b.WriteString(helpStyle.Render("(ctrl+c, esc or q to quit)"))
+	b.WriteString(helpStyle().Render("(ctrl+c, esc or q to quit)"))
 
 	return b.String()
 }
@@ -0,0 +1,178 @@
+package tui
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/charmbracelet/glamour"
+	"github.com/muesli/reflow/ansi"
+	"github.com/muesli/reflow/wrap"
+)
+
+// MarkdownStream renders the markdown written piece by piece, the completed
+// blocks are rendered once and only the trailing open block is rendered
+// again on each write
+type MarkdownStream struct {
+	width  int
+	md     *glamour.TermRenderer
+	text   strings.Builder
+	open   int      // the offset of the open block
+	line   int      // the offset of the line not scanned yet
+	fence  string   // the marker of the fenced code block scanned into
+	list   string   // the marker of the list the open block begins with
+	blank  bool     // if the last scanned line is blank
+	blocks []string // the rendered completed blocks
+}
+
+func NewMarkdownStream(width int) *MarkdownStream {
+	s := &MarkdownStream{}
+	s.SetWidth(width)
+	return s
+}
+
+// SetWidth changes the width to wrap the text, the blocks are rendered again
+func (s *MarkdownStream) SetWidth(width int) {
+	if width <= 0 {
+		width = 80
+	}
+	if width == s.width && s.md != nil {
+		return
+	}
+	s.width = width
+	s.md, _ = glamour.NewTermRenderer(append(theme.MarkdownOptions(), glamour.WithWordWrap(width))...)
+	text := s.text.String()
+	s.Reset()
+	s.Write(text)
+}
+
+// Reset drops the text written
+func (s *MarkdownStream) Reset() {
+	s.text.Reset()
+	s.open, s.line, s.fence, s.list, s.blank, s.blocks = 0, 0, "", "", false, nil
+}
+
+func (s *MarkdownStream) Write(text string) {
+	s.text.WriteString(text)
+	data := s.text.String()
+	for {
+		i := strings.IndexByte(data[s.line:], '\n')
+		if i < 0 {
+			return
+		}
+		start := s.line
+		line := data[start : start+i]
+		s.line += i + 1
+
+		if s.fence != "" {
+			if strings.HasPrefix(strings.TrimSpace(line), s.fence) {
+				s.fence = ""
+			}
+			continue
+		}
+		// a block begins with a line not indented after the blank line, the
+		// indented one or the item of the same kind continues the list above
+		if s.blank && line != "" && line[0] != ' ' && line[0] != '\t' &&
+			(s.list == "" || listMarker(line) != s.list) {
+			s.blocks = append(s.blocks, s.render(data[s.open:start], s.open == 0))
+			s.open = start
+		}
+		if start == s.open {
+			s.list = listMarker(line)
+		}
+		s.blank = strings.TrimSpace(line) == ""
+		if trimmed := strings.TrimLeft(line, " "); len(line)-len(trimmed) < 4 {
+			if strings.HasPrefix(trimmed, "```") {
+				s.fence = "```"
+			} else if strings.HasPrefix(trimmed, "~~~") {
+				s.fence = "~~~"
+			}
+		}
+	}
+}
+
+// Render returns the completed blocks with the open block rendered
+func (s *MarkdownStream) Render() (string, error) {
+	blocks := s.blocks
+	if open := s.text.String()[s.open:]; strings.TrimSpace(open) != "" {
+		blocks = append(blocks[:len(blocks):len(blocks)], s.render(open, s.open == 0))
+	}
+	if len(blocks) == 0 {
+		return "", nil
+	}
+	// it is the same as the document rendered by glamour as a whole
+	return "\n" + strings.Join(blocks, "\n\n") + "\n\n", nil
+}
+
+// render renders a block without the margins of the document, the block is
+// kept as is if it fails to render
+func (s *MarkdownStream) render(block string, first bool) string {
+	out, err := s.md.Render(block)
+	if err != nil {
+		return block
+	}
+	lines := strings.Split(out, "\n")
+	// the document begins with a blank line, and the blocks like the lists
+	// and the code begin with another one which separates them from the
+	// block above, the blank lines in the code are kept
+	margin := 1
+	if !first {
+		margin = 2
+	}
+	for i := 0; i < margin && len(lines) > 0 && isBlank(lines[0]); i++ {
+		lines = lines[1:]
+	}
+	// the document ends with a blank line after the last line
+	for i := 0; i < 2 && len(lines) > 0 && isBlank(lines[len(lines)-1]); i++ {
+		lines = lines[:len(lines)-1]
+	}
+	for i, line := range lines {
+		lines[i] = hardWrap(line, s.width)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// listMarker returns the bullet of the list item like - or *, the
+// delimiter like . or ) of the ordered one, or empty if not a list item
+func listMarker(line string) string {
+	if len(line) >= 2 && strings.ContainsRune("-*+", rune(line[0])) && line[1] == ' ' {
+		return line[:1]
+	}
+	i := 0
+	for i < len(line) && i < 9 && line[i] >= '0' && line[i] <= '9' {
+		i++
+	}
+	if i > 0 && i+1 < len(line) && (line[i] == '.' || line[i] == ')') && line[i+1] == ' ' {
+		return line[i : i+1]
+	}
+	return ""
+}
+
+var ansiSequence = regexp.MustCompile("\x1b\\[[0-9;]*[a-zA-Z]")
+
+func isBlank(line string) bool {
+	return strings.TrimSpace(ansiSequence.ReplaceAllString(line, "")) == ""
+}
+
+// hardWrap breaks the line wider than width, like the east asian text which
+// could not be wrapped by words, the indent is kept for the broken lines
+func hardWrap(line string, width int) string {
+	if ansi.PrintableRuneWidth(line) <= width {
+		return line
+	}
+	indent, n := 0, 0
+	for _, loc := range ansiSequence.FindAllStringIndex(line, -1) {
+		if loc[0] != n {
+			break
+		}
+		n = loc[1]
+	}
+	for n < len(line) && line[n] == ' ' {
+		indent++
+		n++
+	}
+	if indent >= width/2 {
+		indent, n = 0, 0
+	}
+	wrapped := wrap.String(line[n:], width-indent)
+	return line[:n] + strings.ReplaceAll(wrapped, "\n", "\n"+strings.Repeat(" ", indent))
+}
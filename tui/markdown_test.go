@@ -0,0 +1,89 @@
+package tui
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/charmbracelet/glamour"
+	"github.com/muesli/reflow/ansi"
+)
+
+func TestMarkdownStreamMatchesDocument(t *testing.T) {
+	docs := map[string]string{
+		"paragraphs":                   "hello\nworld\n\nthe second paragraph\n",
+		"list across blank lines":      "- one\n- two\n\n- three\n\n  continued\n\n1. first\n\n2. second\n\n* other\n\nafter the lists\n",
+		"fenced code with blank lines": "run it:\n\n```go\nfunc main() {\n\n\tfmt.Println(\"hi\")\n}\n```\n\n~~~\n\n```\n~~~\n\n```\ntrailing\n\n```\n\ndone\n",
+		"setext headings":              "Title\n=====\n\nSubtitle\n--------\n\ntext\n",
+		"atx headings and quotes":      "# Title\n\n> quoted\n>\n> lines\n\n## Section\n\n| a | b |\n|---|---|\n| 1 | 2 |\n",
+		"indented code":                "text\n\n    code\n\n    more code\n\nafter\n",
+		"code first":                   "```\n\nfirst\n```\ntext\n",
+	}
+	const width = 60
+	md, err := glamour.NewTermRenderer(append(theme.MarkdownOptions(), glamour.WithWordWrap(width))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, doc := range docs {
+		t.Run(name, func(t *testing.T) {
+			want, err := md.Render(doc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the document is written in pieces of any size
+			for _, size := range []int{1, 3, 7, len(doc)} {
+				s := NewMarkdownStream(width)
+				for i := 0; i < len(doc); i += size {
+					end := i + size
+					if end > len(doc) {
+						end = len(doc)
+					}
+					s.Write(doc[i:end])
+					s.Render()
+				}
+				got, err := s.Render()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if stripped(got) != stripped(want) {
+					t.Errorf("written by %d bytes:\n%s\nwant:\n%s", size, got, want)
+				}
+			}
+		})
+	}
+}
+
+// stripped drops the styles and the trailing spaces of the lines
+func stripped(text string) string {
+	lines := strings.Split(ansiSequence.ReplaceAllString(text, ""), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestHardWrap(t *testing.T) {
+	cases := []struct {
+		name  string
+		line  string
+		width int
+		want  []string
+	}{
+		{"fits", "hello world", 20, []string{"hello world"}},
+		{"cjk", "中文没有空格所以不能按词换行", 10, []string{"中文没有空", "格所以不能", "按词换行"}},
+		{"cjk indented", "  中文没有空格所以不能按词换行", 12, []string{"  中文没有空", "  格所以不能", "  按词换行"}},
+		{"cjk styled", "\x1b[1m  中文没有空格\x1b[0m", 8, []string{"\x1b[1m  中文没", "  有空格\x1b[0m"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := strings.Split(hardWrap(c.line, c.width), "\n")
+			if strings.Join(got, "|") != strings.Join(c.want, "|") {
+				t.Errorf("got %q, want %q", got, c.want)
+			}
+			for _, line := range got {
+				if w := ansi.PrintableRuneWidth(line); w > c.width {
+					t.Errorf("the line %q is %d wide, wider than %d", line, w, c.width)
+				}
+			}
+		})
+	}
+}
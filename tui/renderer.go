@@ -2,12 +2,19 @@ package tui
 
 import (
 	"bytes"
+	"encoding/json"
+	"fmt"
 	"os"
+	"sort"
+	"strings"
 
 	"github.com/alecthomas/chroma/quick"
 	"github.com/charmbracelet/glamour"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
 	"golang.org/x/term"
+	"gopkg.in/yaml.v3"
 )
 
 type Renderer interface {
@@ -18,8 +25,16 @@ type JSONRenderer struct {
 }
 
 func (r *JSONRenderer) Render(text string) (string, error) {
+	// pretty print the text if it is a complete json
+	indented := bytes.NewBuffer(nil)
+	if err := json.Indent(indented, []byte(text), "", "  "); err == nil {
+		text = indented.String() + "\n"
+	}
+	if theme.colorless() {
+		return text, nil
+	}
 	out := bytes.NewBuffer(nil)
-	if err := quick.Highlight(out, string(text), "json", "terminal256", "monokai"); err != nil {
+	if err := quick.Highlight(out, string(text), "json", "terminal256", theme.CodeStyle()); err != nil {
 		return "", err
 	}
 	return out.String(), nil
@@ -38,10 +53,7 @@ type MarkdownRender struct {
 
 func (r MarkdownRender) Render(text string) (string, error) {
 	// use the markdown renderer to render the response
-	md, err := glamour.NewTermRenderer(
-		// detect background color and pick either the default dark or light theme
-		glamour.WithAutoStyle(),
-	)
+	md, err := glamour.NewTermRenderer(theme.MarkdownOptions()...)
 	if err != nil {
 		return "", err
 	}
@@ -53,17 +65,131 @@ func (r MarkdownRender) Render(text string) (string, error) {
 	}
 }
 
+// RawRenderer keeps the text as is without any style, which is used for piping
+type RawRenderer struct {
+}
+
+func (r *RawRenderer) Render(text string) (string, error) {
+	return text, nil
+}
+
+// CodeRenderer renders the fenced code blocks only, the text is kept if there
+// is no code block
+type CodeRenderer struct {
+}
+
+func (r *CodeRenderer) Render(text string) (string, error) {
+	var blocks []string
+	var code strings.Builder
+	fence := ""
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case fence == "" && (strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~")):
+			fence = trimmed[:3]
+		case fence != "" && strings.HasPrefix(trimmed, fence):
+			blocks = append(blocks, code.String())
+			code.Reset()
+			fence = ""
+		case fence != "":
+			code.WriteString(line + "\n")
+		}
+	}
+	// the block is not closed yet when streaming
+	if code.Len() > 0 {
+		blocks = append(blocks, code.String())
+	}
+	if len(blocks) == 0 {
+		return text, nil
+	}
+	return strings.Join(blocks, "\n"), nil
+}
+
+// HTMLRenderer converts the markdown to html, the raw html in the text is
+// omitted as goldmark is not configured to be unsafe
+type HTMLRenderer struct {
+}
+
+func (r *HTMLRenderer) Render(text string) (string, error) {
+	out := bytes.NewBuffer(nil)
+	md := goldmark.New(goldmark.WithExtensions(extension.GFM))
+	if err := md.Convert([]byte(text), out); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// YAMLRenderer highlights the yaml, the json is converted to yaml
+type YAMLRenderer struct {
+}
+
+func (r *YAMLRenderer) Render(text string) (string, error) {
+	var v any
+	if err := json.Unmarshal([]byte(text), &v); err == nil {
+		buf := bytes.NewBuffer(nil)
+		enc := yaml.NewEncoder(buf)
+		enc.SetIndent(2)
+		if err := enc.Encode(v); err != nil {
+			return "", err
+		}
+		text = buf.String()
+	}
+	if theme.colorless() {
+		return text, nil
+	}
+	out := bytes.NewBuffer(nil)
+	if err := quick.Highlight(out, text, "yaml", "terminal256", theme.CodeStyle()); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+var renderers = map[string]func() Renderer{
+	"markdown": func() Renderer { return &MarkdownRender{} },
+	"text":     func() Renderer { return &TextRenderer{} },
+	"raw":      func() Renderer { return &RawRenderer{} },
+	"plain":    func() Renderer { return &RawRenderer{} },
+	"json":     func() Renderer { return &JSONRenderer{} },
+	"yaml":     func() Renderer { return &YAMLRenderer{} },
+	"code":     func() Renderer { return &CodeRenderer{} },
+	"html":     func() Renderer { return &HTMLRenderer{} },
+}
+
+// RegisterRenderer adds the renderer by name, the one with the same name is
+// replaced
+func RegisterRenderer(name string, newRenderer func() Renderer) {
+	renderers[name] = newRenderer
+}
+
+// Renderers returns the names of the renderers sorted
+func Renderers() []string {
+	var names []string
+	for name := range renderers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LookupRenderer returns the renderer by name, markdown is used if name is
+// empty
+func LookupRenderer(name string) (Renderer, error) {
+	if name == "" {
+		name = "markdown"
+	}
+	newRenderer, ok := renderers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown renderer %q, it should be one of %s", name, strings.Join(Renderers(), ", "))
+	}
+	return newRenderer(), nil
+}
+
+// NewRenderer returns the renderer by name, the name should be checked by
+// LookupRenderer first, markdown is used for the unknown ones
 func NewRenderer(name string) Renderer {
-	var renderer Renderer
-	switch name {
-	case "text":
-		renderer = &TextRenderer{}
-	case "markdown":
-		renderer = &MarkdownRender{}
-	case "json":
-		renderer = &JSONRenderer{}
-	default:
-		renderer = &MarkdownRender{}
+	renderer, err := LookupRenderer(name)
+	if err != nil {
+		return &MarkdownRender{}
 	}
 	return renderer
 }
@@ -0,0 +1,19 @@
+package tui
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHTMLRendererOmitsRawHTML(t *testing.T) {
+	text, err := (&HTMLRenderer{}).Render("# Title\n\n<script>alert(1)</script>\n\nsee <b>this</b>\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(text, "<h1>Title</h1>") {
+		t.Errorf("the markdown is not converted: %q", text)
+	}
+	if strings.Contains(text, "<script>") || strings.Contains(text, "<b>") || strings.Contains(text, "&lt;script") {
+		t.Errorf("the raw html is not omitted: %q", text)
+	}
+}
@@ -1,13 +1,11 @@
 package tui
 
 import (
-	"errors"
 	"fmt"
 	"strings"
 
 	"github.com/charmbracelet/bubbles/spinner"
 	tea "github.com/charmbracelet/bubbletea"
-	"github.com/charmbracelet/lipgloss"
 )
 
 var _ tea.Model = &SpinnerModel[any]{}
@@ -27,7 +25,7 @@ func NewSpinnerModel[V any](hint string, do func() (V, error)) *SpinnerModel[V]
 		do:   do,
 		Model: spinner.Model{
 			Spinner: spinner.Dot,
-			Style:   lipgloss.NewStyle().Foreground(lipgloss.Color("205")),
+			Style:   theme.Style(theme.Focused),
 		},
 	}
 }
@@ -47,11 +45,12 @@ func (s *SpinnerModel[V]) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case tea.KeyMsg:
 		switch msg.String() {
 		case "ctrl+c":
-			s.err = errors.New("Ctrl+C interrupted")
+			s.err = ErrInterrupted
 			return s, tea.Quit
 		}
 	case errMsg:
 		s.err = msg
+		return s, tea.Quit
 	case doneMsg[V]:
 		s.Val = msg.v
 		return s, tea.Quit
@@ -0,0 +1,35 @@
+package tui
+
+import (
+	"errors"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+func TestSpinnerQuitsOnError(t *testing.T) {
+	want := errors.New("rate limit exceeded")
+	s := NewSpinnerModel("asking", func() (string, error) { return "", want })
+
+	_, cmd := s.Update(errMsg(want))
+	if cmd == nil {
+		t.Fatal("the spinner does not quit on the error")
+	}
+	if cmd() != tea.Quit() {
+		t.Fatal("the spinner does not quit on the error")
+	}
+	if !errors.Is(s.Error(), want) {
+		t.Errorf("error %v, want %v", s.Error(), want)
+	}
+}
+
+func TestSpinnerQuitsOnDone(t *testing.T) {
+	s := NewSpinnerModel("asking", func() (string, error) { return "ok", nil })
+	_, cmd := s.Update(doneMsg[string]{v: "ok"})
+	if cmd == nil {
+		t.Fatal("the spinner does not quit when done")
+	}
+	if cmd() != tea.Quit() || s.Value() != "ok" {
+		t.Fatalf("value %q, want ok", s.Value())
+	}
+}
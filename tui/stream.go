@@ -2,14 +2,13 @@ package tui
 
 import (
 	"bytes"
-	"errors"
 	"fmt"
 	"strings"
 	"unicode/utf8"
 
 	"github.com/charmbracelet/bubbles/spinner"
 	tea "github.com/charmbracelet/bubbletea"
-	"github.com/charmbracelet/lipgloss"
+	"github.com/mattn/go-runewidth"
 )
 
 var _ tea.Model = &StreamModel[any, chan any]{}
@@ -21,21 +20,28 @@ type StreamModel[E any, S chan E] struct {
 	text     string // the text to draw by view
 	stream   S
 	renderer Renderer
+	md       *MarkdownStream // renders the markdown incrementally
 	onEvent  func(event E) (string, error)
 	height   int // the window height
 }
 
 func NewStreamModel[E any, S chan E](stream S, rendererName string, onEvent func(event E) (string, error)) *StreamModel[E, S] {
-	return &StreamModel[E, S]{
+	s := &StreamModel[E, S]{
 		out:      bytes.NewBuffer(nil),
 		stream:   stream,
 		renderer: NewRenderer(rendererName),
 		onEvent:  onEvent,
 		Model: spinner.Model{
 			Spinner: spinner.Points,
-			Style:   lipgloss.NewStyle().Foreground(lipgloss.Color("205")),
+			Style:   theme.Style(theme.Focused),
 		},
 	}
+	// the markdown is rendered as is if the stdout is not a terminal, but
+	// it is rendered for the ssh app
+	if _, ok := s.renderer.(*MarkdownRender); ok && IsRenderable() {
+		s.md = NewMarkdownStream(Width())
+	}
+	return s
 }
 
 func (s *StreamModel[E, S]) drainEvent() tea.Msg {
@@ -61,12 +67,16 @@ func (s *StreamModel[E, S]) Update(msg tea.Msg) (m tea.Model, cmd tea.Cmd) {
 	case tea.KeyMsg:
 		switch msg.String() {
 		case "ctrl+c":
-			s.err = errors.New("Ctrl+C interrupted")
+			s.err = ErrInterrupted
 			quiting = true
 			return s, tea.Quit
 		}
 	case tea.WindowSizeMsg:
 		s.height = msg.Height
+		if s.md != nil {
+			s.md.SetWidth(msg.Width)
+			s.render()
+		}
 	case errMsg:
 		s.err = msg
 		quiting = true
@@ -78,14 +88,11 @@ func (s *StreamModel[E, S]) Update(msg tea.Msg) (m tea.Model, cmd tea.Cmd) {
 			return s, tea.Quit
 		} else {
 			s.out.WriteString(text)
+			if s.md != nil {
+				s.md.Write(text)
+			}
 		}
-		// this is a work around to wrap words for Chinese
-		// TODO: find a better way
-		data, err := s.renderer.Render(string(WrapWord(s.out.Bytes(), 110)))
-		if err != nil {
-			s.text = err.Error()
-		}
-		s.text = string(data)
+		s.render()
 		return s, s.drainEvent
 	case doneMsg[E]:
 		quiting = true
@@ -95,6 +102,24 @@ func (s *StreamModel[E, S]) Update(msg tea.Msg) (m tea.Model, cmd tea.Cmd) {
 	return s, cmd
 }
 
+// render renders the text to draw, only the open block of the markdown is
+// rendered again
+func (s *StreamModel[E, S]) render() {
+	var text string
+	var err error
+	if s.md != nil {
+		text, err = s.md.Render()
+	} else {
+		text, err = s.renderer.Render(s.out.String())
+	}
+	if err != nil {
+		text = err.Error()
+	}
+	s.text = text
+}
+
+// WrapWord breaks the words wider than width, like the east asian text
+// without spaces, the width is counted by the cells of the terminal
 func WrapWord(text []byte, width int) []byte {
 	if len(text) < width {
 		return text
@@ -104,7 +129,7 @@ func WrapWord(text []byte, width int) []byte {
 	out := bytes.NewBuffer(nil)
 	for i, w := 0, 0; i < len(text); i += w {
 		r, size := utf8.DecodeRune(text[i:])
-		length += size
+		length += runewidth.RuneWidth(r)
 
 		if size == 1 && (byte(r) == '\n' || byte(r) == ' ') {
 			length = 0
@@ -10,17 +10,12 @@ import (
 	"github.com/charmbracelet/lipgloss"
 )
 
-var (
-	focusedStyle        = lipgloss.NewStyle().Foreground(lipgloss.Color("205"))
-	blurredStyle        = lipgloss.NewStyle().Foreground(lipgloss.Color("240"))
-	cursorStyle         = focusedStyle.Copy()
-	noStyle             = lipgloss.NewStyle()
-	helpStyle           = blurredStyle.Copy()
-	cursorModeHelpStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("244"))
-
-	focusedButton = focusedStyle.Copy().Render("[ Save ]")
-	blurredButton = fmt.Sprintf("[ %s ]", blurredStyle.Render("Save"))
-)
+var noStyle = lipgloss.NewStyle()
+
+// the styles of the controls follow the current theme
+func focusedStyle() lipgloss.Style { return theme.Style(theme.Focused) }
+func blurredStyle() lipgloss.Style { return theme.Style(theme.Muted) }
+func helpStyle() lipgloss.Style    { return theme.Style(theme.Muted) }
 
 var _ Model[[]string] = &ConfigInputModel{}
 
@@ -39,18 +34,25 @@ func NewConfigInputModel(prompts ...string) *ConfigInputModel {
 	for _, p := range prompts {
 		t := textinput.New()
 		t.Placeholder = p
-		t.CursorStyle = cursorStyle
-		t.CharLimit = 64
+		t.CursorStyle = focusedStyle()
+		t.CharLimit = 256
 		inputs = append(inputs, t)
 	}
 
-	inputs[0].PromptStyle = focusedStyle
-	inputs[0].TextStyle = focusedStyle
+	inputs[0].PromptStyle = focusedStyle()
+	inputs[0].TextStyle = focusedStyle()
 	inputs[0].Focus()
 
 	return &ConfigInputModel{inputs: inputs}
 }
 
+// Secret hides the input of the i-th prompt like a password
+func (m *ConfigInputModel) Secret(i int) *ConfigInputModel {
+	m.inputs[i].EchoMode = textinput.EchoPassword
+	m.inputs[i].EchoCharacter = '•'
+	return m
+}
+
 func (m *ConfigInputModel) Init() tea.Cmd {
 	return textinput.Blink
 }
@@ -90,8 +92,8 @@ func (m *ConfigInputModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				if i == m.focusIndex {
 					// Set focused state
 					cmds[i] = m.inputs[i].Focus()
-					m.inputs[i].PromptStyle = focusedStyle
-					m.inputs[i].TextStyle = focusedStyle
+					m.inputs[i].PromptStyle = focusedStyle()
+					m.inputs[i].TextStyle = focusedStyle()
 					continue
 				}
 				// Remove focused state
@@ -132,13 +134,13 @@ func (m *ConfigInputModel) View() string {
 		}
 	}
 
-	button := &blurredButton
+	button := fmt.Sprintf("[ %s ]", blurredStyle().Render("Save"))
 	if m.focusIndex == len(m.inputs) {
-		button = &focusedButton
+		button = focusedStyle().Render("[ Save ]")
 	}
-	fmt.Fprintf(&b, "\n\n%s\n\n", *button)
+	fmt.Fprintf(&b, "\n\n%s\n\n", button)
 
-	b.WriteString(helpStyle.Render("(ctrl+c, esc or q to quit)"))
+	b.WriteString(helpStyle().Render("(ctrl+c, esc or q to quit)"))
 
 	return b.String()
 }
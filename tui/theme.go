@@ -0,0 +1,218 @@
+package tui
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/charmbracelet/glamour"
+	"github.com/charmbracelet/glamour/ansi"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/muesli/termenv"
+	"gopkg.in/yaml.v3"
+)
+
+// Theme is the colors of the output, the themes are the yaml files in the
+// themes directory like ~/.guru/themes/ocean.yaml, and dark and light are
+// built in. The fields not set are taken from the built-in theme matching
+// the background of the terminal
+type Theme struct {
+	Text      string      `yaml:"text,omitempty"`
+	Error     string      `yaml:"error,omitempty"`
+	Highlight string      `yaml:"highlight,omitempty"`
+	Accent    string      `yaml:"accent,omitempty"`  // the current session
+	Muted     string      `yaml:"muted,omitempty"`   // the hints, the rules and the controls not focused
+	Focused   string      `yaml:"focused,omitempty"` // the spinners and the controls focused
+	Status    StatusTheme `yaml:"status,omitempty"`  // the status bar of the full-screen app
+	Prompt    PromptTheme `yaml:"prompt,omitempty"`
+	// Markdown is the glamour style, which is a standard style like dracula,
+	// a json file relative to the themes directory, or the style itself
+	Markdown yaml.Node `yaml:"markdown,omitempty"`
+	// Code is the chroma style of the code, like monokai or github, the
+	// colors of the markdown style are used for the code blocks if not set
+	Code string `yaml:"code,omitempty"`
+
+	// NoColor is set by NO_COLOR, the output is not colored at all
+	NoColor bool `yaml:"-"`
+
+	styles ansi.StyleConfig // the glamour style resolved
+	light  bool             // based on the light theme
+}
+
+type StatusTheme struct {
+	Foreground string `yaml:"foreground,omitempty"`
+	Background string `yaml:"background,omitempty"`
+}
+
+type PromptTheme struct {
+	Prefix    string `yaml:"prefix,omitempty"`
+	Delimiter string `yaml:"delimiter,omitempty"`
+	Suffix    string `yaml:"suffix,omitempty"`
+}
+
+var builtinThemes = map[string]string{
+	"dark": `
+text: "#79b3ec"
+error: "#e61919"
+highlight: "#0aacf8"
+accent: "#2da9d2"
+muted: "244"
+focused: "205"
+status: {foreground: "252", background: "236"}
+prompt: {prefix: "#13f911", delimiter: "#13f911", suffix: "#13f911"}
+markdown: dark
+`,
+	"light": `
+text: "#1d5fa8"
+error: "#c81818"
+highlight: "#0b6fb8"
+accent: "#1a7fa3"
+muted: "243"
+focused: "162"
+status: {foreground: "235", background: "254"}
+prompt: {prefix: "#138a12", delimiter: "#138a12", suffix: "#138a12"}
+markdown: light
+`,
+}
+
+var theme = mustTheme(builtinThemes["dark"])
+
+func mustTheme(text string) *Theme {
+	t := &Theme{}
+	if err := yaml.Unmarshal([]byte(text), t); err != nil {
+		panic(err)
+	}
+	if err := t.resolve(""); err != nil {
+		panic(err)
+	}
+	t.NoColor = os.Getenv("NO_COLOR") != ""
+	return t
+}
+
+// CurrentTheme returns the theme used by the renderers
+func CurrentTheme() *Theme {
+	return theme
+}
+
+// SetTheme changes the theme used by the renderers, the colors are
+// disabled if NoColor is set
+func SetTheme(t *Theme) {
+	theme = t
+	if t.NoColor {
+		lipgloss.SetColorProfile(termenv.Ascii)
+	}
+}
+
+// LoadTheme loads the theme by name from dir, auto or an empty name picks
+// dark or light by the background of the terminal
+func LoadTheme(dir, name string) (*Theme, error) {
+	base := "dark"
+	if !lipgloss.HasDarkBackground() {
+		base = "light"
+	}
+	if name == "" || name == "auto" {
+		name = base
+	}
+	if _, ok := builtinThemes[name]; ok {
+		base = name
+	}
+
+	t := mustTheme(builtinThemes[base])
+	t.light = base == "light"
+	data, err := os.ReadFile(filepath.Join(dir, name+".yaml"))
+	if os.IsNotExist(err) {
+		text, ok := builtinThemes[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown theme %q, it is not found in %s", name, dir)
+		}
+		data = []byte(text)
+	} else if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, t); err != nil {
+		return nil, fmt.Errorf("parse theme %s: %w", name, err)
+	}
+	if err := t.resolve(dir); err != nil {
+		return nil, fmt.Errorf("theme %s: %w", name, err)
+	}
+	return t, nil
+}
+
+// resolve resolves the glamour style of the markdown
+func (t *Theme) resolve(dir string) error {
+	var data []byte
+	switch m := &t.Markdown; {
+	case m.Kind == yaml.ScalarNode && glamour.DefaultStyles[m.Value] != nil:
+		t.styles = *glamour.DefaultStyles[m.Value]
+	case m.Kind == yaml.ScalarNode:
+		file := m.Value
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+		var err error
+		if data, err = os.ReadFile(file); err != nil {
+			return err
+		}
+	default:
+		// the style is written in the theme, in yaml or json
+		var style map[string]any
+		if err := m.Decode(&style); err != nil {
+			return err
+		}
+		var err error
+		if data, err = json.Marshal(style); err != nil {
+			return err
+		}
+	}
+	if data != nil {
+		t.styles = ansi.StyleConfig{}
+		if err := json.Unmarshal(data, &t.styles); err != nil {
+			return fmt.Errorf("parse the markdown style: %w", err)
+		}
+	}
+	// the code theme takes the place of the colors of the style
+	if t.Code != "" {
+		t.styles.CodeBlock.Theme = t.Code
+		t.styles.CodeBlock.Chroma = nil
+	}
+	return nil
+}
+
+// MarkdownOptions returns the options of glamour to render in the theme
+func (t *Theme) MarkdownOptions() []glamour.TermRendererOption {
+	if t.NoColor {
+		return []glamour.TermRendererOption{
+			glamour.WithStandardStyle("notty"), glamour.WithColorProfile(termenv.Ascii)}
+	}
+	return []glamour.TermRendererOption{
+		glamour.WithStyles(t.styles), glamour.WithColorProfile(lipgloss.ColorProfile())}
+}
+
+// CodeStyle returns the chroma style to highlight the code like json
+func (t *Theme) CodeStyle() string {
+	if t.Code != "" {
+		return t.Code
+	}
+	if t.light {
+		return "github"
+	}
+	return "monokai"
+}
+
+// colorless reports if the colors are disabled, or not supported like
+// writing to a pipe
+func (t *Theme) colorless() bool {
+	return t.NoColor || lipgloss.ColorProfile() == termenv.Ascii
+}
+
+// Style returns the style with the color as the foreground
+func (t *Theme) Style(color string) lipgloss.Style {
+	return lipgloss.NewStyle().Foreground(lipgloss.Color(strings.TrimSpace(color)))
+}
+
+// StatusStyle returns the style of the status bar
+func (t *Theme) StatusStyle() lipgloss.Style {
+	return t.Style(t.Status.Foreground).Background(lipgloss.Color(strings.TrimSpace(t.Status.Background)))
+}
@@ -0,0 +1,85 @@
+package tui
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+func TestLoadTheme(t *testing.T) {
+	dir := t.TempDir()
+	ocean := "text: \"#79b3ec\"\naccent: \"#0aacf8\"\ncode: monokai\n"
+	if err := os.WriteFile(filepath.Join(dir, "ocean.yaml"), []byte(ocean), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "broken.yaml"), []byte("text: [\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	light, err := LoadTheme(dir, "light")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if light.Text != "#1d5fa8" || light.Status.Background != "254" || !light.light {
+		t.Errorf("the built-in light theme is not loaded: %+v", light)
+	}
+
+	theme, err := LoadTheme(dir, "ocean")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if theme.Text != "#79b3ec" || theme.Accent != "#0aacf8" || theme.CodeStyle() != "monokai" {
+		t.Errorf("the colors of the file are not used: %+v", theme)
+	}
+	// the colors not set are taken from the built-in theme
+	if theme.Error == "" || theme.Muted == "" || theme.Prompt.Prefix == "" || theme.Status.Foreground == "" {
+		t.Errorf("the colors not set are not taken from the built-in theme: %+v", theme)
+	}
+
+	if _, err := LoadTheme(dir, "broken"); err == nil || !strings.Contains(err.Error(), "parse theme broken") {
+		t.Errorf("the broken theme is loaded: %v", err)
+	}
+	if _, err := LoadTheme(dir, "missing"); err == nil || !strings.Contains(err.Error(), "unknown theme") {
+		t.Errorf("the missing theme is loaded: %v", err)
+	}
+}
+
+func TestNoColor(t *testing.T) {
+	t.Setenv("NO_COLOR", "1")
+	noColor, err := LoadTheme(t.TempDir(), "dark")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !noColor.NoColor {
+		t.Fatal("NO_COLOR is not taken")
+	}
+	saved, profile := theme, lipgloss.ColorProfile()
+	SetTheme(noColor)
+	defer func() {
+		theme = saved
+		lipgloss.SetColorProfile(profile)
+	}()
+
+	text, err := (&JSONRenderer{}).Render(`{"a":[1,"b"]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(text, "\x1b[") || !strings.Contains(text, "\"a\": [") {
+		t.Errorf("the json is colored: %q", text)
+	}
+
+	text, err = MarkdownRender{}.Render("# Title\n\nsome **bold** and `code`\n\n```go\nfunc main() {}\n```\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(text, "\x1b[") || !strings.Contains(text, "func main() {}") {
+		t.Errorf("the markdown is colored: %q", text)
+	}
+
+	if text := noColor.Style(noColor.Accent).Render("accent"); text != "accent" {
+		t.Errorf("the style is colored: %q", text)
+	}
+}
@@ -2,12 +2,15 @@ package tui
 
 import (
 	"context"
+	"errors"
 	"io"
 	"os"
+	"sync"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/chzyer/readline"
 	"github.com/muesli/termenv"
+	"golang.org/x/term"
 )
 
 var (
@@ -19,6 +22,64 @@ var (
 // run as a ssh app
 var SSHAPPMode bool
 
+// Width returns the width of the terminal, it is replaced by the ssh app
+// with the width of the remote terminal
+var Width = func() int {
+	w, _, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil {
+		return 0
+	}
+	return w
+}
+
+// Height returns the height of the terminal, it is replaced by the ssh app
+// as the width
+var Height = func() int {
+	_, h, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil {
+		return 0
+	}
+	return h
+}
+
+// resized is called by Resize, which is set by the full-screen app and
+// called by the ssh app in another goroutine
+var resized struct {
+	sync.Mutex
+	fn func(width, height int)
+}
+
+// OnResize sets fn to be called when the remote terminal of the ssh app is
+// resized, the size of which is not known by the full-screen app otherwise.
+// It is unset if fn is nil
+func OnResize(fn func(width, height int)) {
+	resized.Lock()
+	defer resized.Unlock()
+	resized.fn = fn
+}
+
+// Resize reports the size of the remote terminal to the function set by
+// OnResize if any
+func Resize(width, height int) {
+	resized.Lock()
+	fn := resized.fn
+	resized.Unlock()
+	if fn != nil {
+		fn(width, height)
+	}
+}
+
+// FullScreen is set when the full-screen app is running, the content is
+// printed in it, and the other models take the terminal from it to display
+var FullScreen interface {
+	Print(text string)
+	// Suspend releases the terminal, which is taken back by resume
+	Suspend() (resume func())
+}
+
+// ErrInterrupted is returned when the user presses Ctrl+C
+var ErrInterrupted = errors.New("Ctrl+C interrupted")
+
 type (
 	errMsg         error
 	doneMsg[V any] struct {
@@ -35,7 +96,17 @@ type Model[T any] interface {
 	Error() error
 }
 
-func Display[M Model[V], V any](ctx context.Context, m M) (V, error) {
+// Display runs the model until it quits, the options like tea.WithInput
+// override the default ones
+func Display[M Model[V], V any](ctx context.Context, m M, options ...tea.ProgramOption) (V, error) {
+	if FullScreen != nil {
+		if c, ok := any(m).(*ContentModel); ok {
+			FullScreen.Print(c.View())
+			return m.Value(), nil
+		}
+		resume := FullScreen.Suspend()
+		defer resume()
+	}
 	// set the default output using termenv, tea.WithOutput(Stdout) does not work for vscode terminal
 	// TODO figure out why tea.WithOutput breaks
 	termenv.SetDefaultOutput(termenv.NewOutput(Stdout, termenv.WithColorCache(true)))
@@ -43,9 +114,13 @@ func Display[M Model[V], V any](ctx context.Context, m M) (V, error) {
 	if !IsRenderable() {
 		opts = append(opts, tea.WithoutRenderer())
 	}
-	p := tea.NewProgram(m, opts...)
+	p := tea.NewProgram(m, append(opts, options...)...)
 	defer p.ReleaseTerminal()
 	done, err := p.Run()
+	// report why the program is killed
+	if errors.Is(err, tea.ErrProgramKilled) && ctx.Err() != nil {
+		err = ctx.Err()
+	}
 	res := done.(M)
 	if res.Error() != nil {
 		err = res.Error()
@@ -0,0 +1,37 @@
+package tui
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestResize(t *testing.T) {
+	// nothing is called if not set
+	Resize(80, 24)
+
+	var mu sync.Mutex
+	var sizes [][2]int
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		// the ssh app reports the size in another goroutine
+		for i := 0; i < 100; i++ {
+			Resize(i, i)
+		}
+	}()
+	OnResize(func(width, height int) {
+		mu.Lock()
+		sizes = append(sizes, [2]int{width, height})
+		mu.Unlock()
+	})
+	wg.Wait()
+	OnResize(nil)
+	Resize(100, 100)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if n := len(sizes); n > 0 && sizes[n-1] != [2]int{99, 99} {
+		t.Errorf("the last size %v, want 99x99", sizes[n-1])
+	}
+}
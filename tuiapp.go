@@ -0,0 +1,661 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/spinner"
+	"github.com/charmbracelet/bubbles/textarea"
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/muesli/termenv"
+	"github.com/shafreeck/cortana"
+	"github.com/shafreeck/guru/lineedit"
+	"github.com/shafreeck/guru/tui"
+)
+
+// the messages sent to the full-screen app
+type (
+	appOutputMsg  string // the output of the commands
+	appDeltaMsg   string // the reply streamed
+	appDoneMsg    struct{}
+	appSuspendMsg struct {
+		suspended bool
+		drawn     chan struct{} // closed when the empty view is drawn
+	}
+	appEditedMsg struct {
+		text string
+		err  error
+	}
+)
+
+const (
+	sidebarWidth = 28
+	inputHeight  = 3
+)
+
+// the keys of the builtins, the others are typed in the input like the REPL
+var appKeys = map[string]string{
+	"f1":     ":help",
+	"f2":     ":info",
+	"f3":     ":message list",
+	"f4":     ":session stack",
+	"ctrl+n": ":session new",
+}
+
+// appStyles are the styles of the app, which are taken from the styles of
+// the guru to follow the theme
+type appStyles struct {
+	sidebar  lipgloss.Style
+	selected lipgloss.Style
+	current  lipgloss.Style // the current session
+	status   lipgloss.Style
+	hint     lipgloss.Style
+	rule     lipgloss.Style
+	spinner  lipgloss.Style
+}
+
+func newAppStyles(g *Guru) appStyles {
+	return appStyles{
+		sidebar: lipgloss.NewStyle().Border(lipgloss.NormalBorder(), false, true, false, false).
+			BorderForeground(g.mutedStyle.GetForeground()).Padding(0, 1),
+		selected: lipgloss.NewStyle().Reverse(true),
+		current:  g.accentStyle.Copy(),
+		status:   g.statusStyle.Copy(),
+		hint:     g.statusStyle.Copy().Foreground(g.mutedStyle.GetForeground()),
+		rule:     g.mutedStyle.Copy(),
+		spinner:  g.focusedStyle.Copy(),
+	}
+}
+
+// chatApp is the full-screen chat, which shows the conversation, the
+// sessions and the input together. The input is evaluated the same as
+// the REPL, so the builtins and the shell commands work as well
+type chatApp struct {
+	p        *tea.Program
+	g        *Guru
+	sess     *Session
+	cc       *ChatCommand
+	e        *Evaluator
+	opts     *ChatCommandOptions
+	renderer tui.Renderer
+	styles   appStyles
+	md       *tui.MarkdownStream // renders the messages if the renderer is markdown
+	stream   *tui.MarkdownStream // renders the reply arriving
+	stdout   io.Writer           // the terminal, tui.Stdout is the app when running
+	first    func()              // evaluated at the beginning if set
+
+	width, height int
+	conv          viewport.Model
+	input         textarea.Model
+	spinner       spinner.Model
+	sidebar       bool // the sessions are shown
+	focused       bool // the sidebar is focused
+	sessions      []string
+	cursor        int // the session selected
+
+	// the snapshots taken when idle, the session is changed by the
+	// evaluation running in the background
+	messages []*Message
+	sid      string
+	prompt   string
+	tokens   int
+	cost     float64
+
+	ctrlX     bool // Ctrl+X is pressed, waiting for Ctrl+E
+	busy      bool
+	suspended bool
+	drawn     chan struct{}
+	cancel    context.CancelFunc
+	pending   string // the message sent
+	reply     strings.Builder
+	output    strings.Builder
+	rendered  map[*Message]string
+}
+
+func newChatApp(g *Guru, sess *Session, cc *ChatCommand, e *Evaluator, opts *ChatCommandOptions) *chatApp {
+	input := textarea.New()
+	input.Placeholder = "Send a message, Alt+Enter for a new line"
+	input.ShowLineNumbers = false
+	input.CharLimit = 0
+	input.SetHeight(inputHeight)
+	input.KeyMap.InsertNewline.SetKeys("alt+enter", "ctrl+j")
+	input.Focus()
+
+	styles := newAppStyles(g)
+	a := &chatApp{
+		g:        g,
+		sess:     sess,
+		cc:       cc,
+		e:        e,
+		opts:     opts,
+		renderer: tui.NewRenderer(opts.Renderer),
+		styles:   styles,
+		stdout:   tui.Stdout,
+		input:    input,
+		sidebar:  true,
+		rendered: make(map[*Message]string),
+		spinner: spinner.Model{
+			Spinner: spinner.Dot,
+			Style:   styles.spinner,
+		},
+	}
+	if _, ok := a.renderer.(*tui.MarkdownRender); ok {
+		a.md, a.stream = tui.NewMarkdownStream(0), tui.NewMarkdownStream(0)
+	}
+	a.snapshot()
+	return a
+}
+
+// Write shows the output of the commands, it is called by the evaluation
+// running in the background
+func (a *chatApp) Write(data []byte) (int, error) {
+	a.p.Send(appOutputMsg(data))
+	return len(data), nil
+}
+
+func (a *chatApp) Print(text string) {
+	a.Write([]byte(text + "\n"))
+}
+
+// Suspend gives the terminal to the models like the confirmation of the
+// executor until resumed
+func (a *chatApp) Suspend() func() {
+	// the last frame is repainted when the alt screen exits, so the view
+	// is emptied first
+	drawn := make(chan struct{})
+	a.p.Send(appSuspendMsg{suspended: true, drawn: drawn})
+	<-drawn
+	a.p.ReleaseTerminal()
+	stdout := tui.Stdout
+	tui.Stdout = a.stdout
+	return func() {
+		tui.Stdout = stdout
+		a.p.RestoreTerminal()
+		a.p.Send(appSuspendMsg{})
+	}
+}
+
+// run runs the app until quit, first is evaluated at the beginning if set
+func (a *chatApp) run(first func()) error {
+	a.first = first
+	output := termenv.NewOutput(a.stdout, termenv.WithColorCache(true))
+	termenv.SetDefaultOutput(output)
+	// the background is queried before the program reads the input, which
+	// would take the answer of the terminal as the keys typed
+	output.HasDarkBackground()
+	lipgloss.SetHasDarkBackground(lipgloss.HasDarkBackground())
+	a.p = tea.NewProgram(a, tea.WithAltScreen(), tea.WithInput(tui.Stdin))
+
+	// the output is shown in the conversation instead of the terminal
+	stdout, stderr := a.g.stdout, a.g.stderr
+	a.g.stdout, a.g.stderr = a, a
+	tui.Stdout, tui.Stderr = a, a
+	builtins.Use(cortana.WithStdout(a), cortana.WithStderr(a))
+	tui.FullScreen = a
+	a.cc.sink = func(text string) { a.p.Send(appDeltaMsg(text)) }
+	if tui.SSHAPPMode {
+		// the size is reported by the ssh session instead of the tty
+		tui.OnResize(func(width, height int) {
+			a.p.Send(tea.WindowSizeMsg{Width: width, Height: height})
+		})
+		go tui.Resize(tui.Width(), tui.Height())
+	}
+	defer func() {
+		a.g.stdout, a.g.stderr = stdout, stderr
+		tui.Stdout, tui.Stderr = a.stdout, a.stdout
+		builtins.Use(cortana.WithStdout(a.stdout), cortana.WithStderr(a.stdout))
+		tui.FullScreen = nil
+		tui.OnResize(nil)
+		a.cc.sink = nil
+	}()
+
+	_, err := a.p.Run()
+	if a.cancel != nil {
+		a.cancel()
+	}
+	return err
+}
+
+func (a *chatApp) Init() tea.Cmd {
+	if a.first != nil {
+		return tea.Batch(textarea.Blink, a.spinner.Tick, a.start(a.first))
+	}
+	return textarea.Blink
+}
+
+// start marks the app busy and returns the command evaluating in the
+// background, the request is canceled by Ctrl+C
+func (a *chatApp) start(eval func()) tea.Cmd {
+	ctx, cancel := context.WithCancel(context.Background())
+	a.busy, a.cancel, a.cc.ctx = true, cancel, ctx
+	a.reply.Reset()
+	a.output.Reset()
+	if a.stream != nil {
+		a.stream.Reset()
+	}
+	return func() tea.Msg {
+		eval()
+		return appDoneMsg{}
+	}
+}
+
+// submit evaluates the text typed
+func (a *chatApp) submit(text string) tea.Cmd {
+	trimmed := strings.TrimSpace(text)
+	if trimmed == "" {
+		return nil
+	}
+	if trimmed == ":exit" || trimmed == ":quit" {
+		return tea.Quit
+	}
+	cmd := a.start(func() { a.e.eval(text) })
+	// the commands are echoed with their output
+	if a.e.mode != ChatEval || strings.ContainsAny(trimmed[:1], ":$<>") {
+		a.output.WriteString(a.prompt + trimmed + "\n")
+	} else {
+		a.pending = trimmed
+	}
+	a.input.Reset()
+	a.refresh(true)
+	return tea.Batch(a.spinner.Tick, cmd)
+}
+
+// command runs the builtins by the keys
+func (a *chatApp) command(texts ...string) tea.Cmd {
+	if a.busy {
+		return nil
+	}
+	cmd := a.start(func() {
+		for _, text := range texts {
+			builtinCommandEval(a.sess, text)
+		}
+	})
+	a.refresh(true)
+	return tea.Batch(a.spinner.Tick, cmd)
+}
+
+// edit edits the input in $VISUAL or $EDITOR, which is easier for the
+// long messages
+func (a *chatApp) edit() tea.Cmd {
+	f, err := os.CreateTemp("", "guru-*.md")
+	if err != nil {
+		return func() tea.Msg { return appEditedMsg{err: err} }
+	}
+	_, err = f.WriteString(a.input.Value())
+	f.Close()
+	if err != nil {
+		os.Remove(f.Name())
+		return func() tea.Msg { return appEditedMsg{err: err} }
+	}
+	cmd := lineedit.EditorCommand(f.Name())
+	return tea.ExecProcess(cmd, func(err error) tea.Msg {
+		defer os.Remove(f.Name())
+		if err != nil {
+			return appEditedMsg{err: fmt.Errorf("%s: %w", cmd.Args[0], err)}
+		}
+		data, err := os.ReadFile(f.Name())
+		return appEditedMsg{text: strings.TrimSuffix(string(data), "\n"), err: err}
+	})
+}
+
+// snapshot takes the state of the session when idle
+func (a *chatApp) snapshot() {
+	a.messages = append([]*Message(nil), a.sess.Messages()...)
+	a.sid = a.sess.sid
+	a.prompt = a.e.lp.Render()
+	a.tokens, a.cost = a.sess.usage()
+
+	a.sessions = a.sessions[:0]
+	entries, err := os.ReadDir(a.sess.dir)
+	if err != nil {
+		a.output.WriteString(err.Error() + "\n")
+	}
+	// the latest first
+	for i := len(entries) - 1; i >= 0; i-- {
+		a.sessions = append(a.sessions, entries[i].Name())
+	}
+	if a.cursor >= len(a.sessions) {
+		a.cursor = len(a.sessions) - 1
+	}
+	if a.cursor < 0 {
+		a.cursor = 0
+	}
+}
+
+func (a *chatApp) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	var cmds []tea.Cmd
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		if msg.Width == 0 || msg.Height == 0 {
+			// the size of the placeholder tty of the ssh app
+			return a, nil
+		}
+		a.width, a.height = msg.Width, msg.Height
+		a.rendered = make(map[*Message]string)
+		a.layout()
+		a.refresh(true)
+		return a, nil
+	case appOutputMsg:
+		a.output.WriteString(string(msg))
+		a.refresh(false)
+		return a, nil
+	case appDeltaMsg:
+		a.reply.WriteString(string(msg))
+		if a.stream != nil {
+			a.stream.Write(string(msg))
+		}
+		a.refresh(false)
+		return a, nil
+	case appSuspendMsg:
+		a.suspended, a.drawn = msg.suspended, msg.drawn
+		return a, nil
+	case appEditedMsg:
+		if msg.err != nil {
+			a.output.WriteString(a.g.errStyle.Render(msg.err.Error()) + "\n")
+			a.refresh(true)
+			return a, nil
+		}
+		a.input.SetValue(msg.text)
+		return a, nil
+	case appDoneMsg:
+		a.busy, a.pending = false, ""
+		a.cancel()
+		a.cancel = nil
+		a.reply.Reset()
+		a.snapshot()
+		a.layout()
+		a.refresh(true)
+		return a, nil
+	case spinner.TickMsg:
+		if !a.busy {
+			return a, nil
+		}
+		var cmd tea.Cmd
+		a.spinner, cmd = a.spinner.Update(msg)
+		return a, cmd
+	case tea.KeyMsg:
+		ctrlX := a.ctrlX
+		a.ctrlX = false
+		switch key := msg.String(); key {
+		case "ctrl+c":
+			if a.busy {
+				a.cancel()
+			} else if a.input.Value() != "" {
+				a.input.Reset()
+			} else {
+				return a, tea.Quit
+			}
+			return a, nil
+		case "ctrl+x":
+			a.ctrlX = !a.focused
+			return a, nil
+		case "ctrl+e":
+			// Ctrl+E alone moves to the end of the line
+			if ctrlX {
+				return a, a.edit()
+			}
+		case "ctrl+s":
+			a.sidebar = !a.sidebar
+			a.focused = a.focused && a.sidebar
+			a.layout()
+			a.refresh(false)
+			return a, nil
+		case "tab":
+			if a.sidebar {
+				a.focused = !a.focused
+				if a.focused {
+					a.input.Blur()
+				} else {
+					a.input.Focus()
+				}
+			}
+			return a, nil
+		case "pgup":
+			a.conv.ViewUp()
+			return a, nil
+		case "pgdown":
+			a.conv.ViewDown()
+			return a, nil
+		case "ctrl+up":
+			a.conv.LineUp(1)
+			return a, nil
+		case "ctrl+down":
+			a.conv.LineDown(1)
+			return a, nil
+		}
+		if text, ok := appKeys[msg.String()]; ok {
+			return a, a.command(text)
+		}
+		if a.focused {
+			return a, a.updateSidebar(msg)
+		}
+		if msg.Type == tea.KeyEnter && !msg.Alt {
+			if a.busy {
+				return a, nil
+			}
+			return a, a.submit(a.input.Value())
+		}
+	}
+
+	var cmd tea.Cmd
+	a.input, cmd = a.input.Update(msg)
+	cmds = append(cmds, cmd)
+	return a, tea.Batch(cmds...)
+}
+
+// updateSidebar handles the keys when the sessions are focused
+func (a *chatApp) updateSidebar(msg tea.KeyMsg) tea.Cmd {
+	if len(a.sessions) == 0 {
+		return nil
+	}
+	sid := a.sessions[a.cursor]
+	switch msg.String() {
+	case "up", "k":
+		if a.cursor > 0 {
+			a.cursor--
+		}
+	case "down", "j":
+		if a.cursor < len(a.sessions)-1 {
+			a.cursor++
+		}
+	case "enter":
+		if sid != a.sid {
+			return a.command(":session switch " + sid)
+		}
+	case "f":
+		if sid != a.sid {
+			return a.command(":session switch "+sid, ":session fork")
+		}
+		return a.command(":session fork")
+	case "n":
+		return a.command(":session new")
+	case "d", "delete":
+		// the current session is kept
+		if sid != a.sid {
+			return a.command(":session remove " + sid)
+		}
+	}
+	return nil
+}
+
+// layout sizes the panes by the window
+func (a *chatApp) layout() {
+	width := a.width
+	if a.sidebar {
+		width -= sidebarWidth
+	}
+	a.input.SetWidth(width)
+	// the rule above the input and the status bar
+	height := a.height - inputHeight - 2
+	if height < 1 {
+		height = 1
+	}
+	if a.conv.Width == 0 {
+		a.conv = viewport.New(width, height)
+		a.conv.KeyMap = viewport.KeyMap{}
+	}
+	a.conv.Width, a.conv.Height = width, height
+	if a.md != nil {
+		a.md.SetWidth(width)
+		a.stream.SetWidth(width)
+	}
+}
+
+// refresh renders the conversation, it is scrolled to the bottom if
+// bottom is true or it was at the bottom
+func (a *chatApp) refresh(bottom bool) {
+	if a.width == 0 {
+		return
+	}
+	bottom = bottom || a.conv.AtBottom()
+
+	var b strings.Builder
+	for _, m := range a.messages {
+		text, ok := a.rendered[m]
+		if !ok {
+			text = a.render(m, false)
+			a.rendered[m] = text
+		}
+		b.WriteString(text)
+	}
+	if a.pending != "" {
+		b.WriteString(a.render(&Message{Role: User, Content: a.pending}, false))
+	}
+	if a.reply.Len() > 0 {
+		b.WriteString(a.render(&Message{Role: Assistant, Content: a.reply.String()}, true))
+	}
+	if a.output.Len() > 0 {
+		b.WriteString(lipgloss.NewStyle().Width(a.conv.Width).Render(a.output.String()))
+	}
+	a.conv.SetContent(b.String())
+	if bottom {
+		a.conv.GotoBottom()
+	}
+}
+
+// render renders the message with its role, the markdown of the reply is
+// rendered by the stream as it arrives
+func (a *chatApp) render(m *Message, reply bool) string {
+	header := a.g.promptStyle.Copy().Bold(true).Render(string(m.Role))
+	content := m.Content
+	if m.Role == Assistant {
+		header = a.g.highlightStyle.Copy().Bold(true).Render(string(m.Role))
+		var text string
+		var err error
+		switch {
+		case reply && a.stream != nil:
+			text, err = a.stream.Render()
+		case a.md != nil:
+			a.md.Reset()
+			a.md.Write(m.Content)
+			text, err = a.md.Render()
+		default:
+			text, err = a.renderer.Render(m.Content)
+		}
+		if err == nil {
+			content = strings.Trim(text, "\n")
+		}
+	}
+	return header + "\n" + lipgloss.NewStyle().Width(a.conv.Width).Render(content) + "\n\n"
+}
+
+func (a *chatApp) View() string {
+	if a.width == 0 {
+		return ""
+	}
+	// nothing is drawn when the terminal is taken
+	if a.suspended {
+		if a.drawn != nil {
+			close(a.drawn)
+			a.drawn = nil
+		}
+		return ""
+	}
+	rule := a.styles.rule.Render(strings.Repeat("─", a.conv.Width))
+	main := lipgloss.JoinVertical(lipgloss.Left, a.conv.View(), rule, a.input.View())
+	if a.sidebar {
+		main = lipgloss.JoinHorizontal(lipgloss.Top, a.sidebarView(lipgloss.Height(main)), main)
+	}
+	return lipgloss.JoinVertical(lipgloss.Left, main, a.statusView())
+}
+
+func (a *chatApp) sidebarView(height int) string {
+	width := sidebarWidth - 3 // the padding and the border
+	lines := []string{a.g.highlightStyle.Copy().Bold(true).Render("sessions")}
+	// keep the selected one visible
+	start := 0
+	if n := height - 1; a.cursor >= n {
+		start = a.cursor - n + 1
+	}
+	for i := start; i < len(a.sessions) && len(lines) < height; i++ {
+		sid := a.sessions[i]
+		label := truncate(sessionLabel(sid), width-2)
+		if sid == a.sid {
+			label = "* " + label
+		} else {
+			label = "  " + label
+		}
+		switch {
+		case a.focused && i == a.cursor:
+			label = a.styles.selected.Render(label)
+		case sid == a.sid:
+			label = a.styles.current.Render(label)
+		}
+		lines = append(lines, label)
+	}
+	return a.styles.sidebar.Width(sidebarWidth - 1).Height(height).MaxHeight(height).
+		Render(strings.Join(lines, "\n"))
+}
+
+func (a *chatApp) statusView() string {
+	state := ""
+	if a.busy {
+		state = a.spinner.View() + " "
+	}
+	status := fmt.Sprintf(" %s%s │ ", state, a.opts.Model)
+	// the current session is marked in the sidebar
+	if !a.sidebar {
+		status += truncate(sessionLabel(a.sid), 24) + " │ "
+	}
+	status += fmt.Sprintf("tokens %d │ $%.4f ", a.tokens, a.cost)
+	hint := "enter send • tab sessions • ctrl+s sidebar • f1 help • ctrl+c quit "
+	if a.focused {
+		hint = "enter switch • f fork • n new • d delete • tab input "
+	}
+	hint = truncate(hint, a.width-lipgloss.Width(status))
+	gap := a.width - lipgloss.Width(status) - lipgloss.Width(hint)
+	if gap < 0 {
+		gap = 0
+	}
+	return a.styles.status.Render(status+strings.Repeat(" ", gap)) + a.styles.hint.Render(hint)
+}
+
+// sessionLabel shows the session like chat-1680190522751-43e17bad-... by
+// the time it is created
+func sessionLabel(sid string) string {
+	parts := strings.SplitN(sid, "-", 3)
+	if len(parts) == 3 && parts[0] == "chat" {
+		if ms, err := strconv.ParseInt(parts[1], 10, 64); err == nil {
+			return time.UnixMilli(ms).Format("2006-01-02 15:04:05")
+		}
+	}
+	return sid
+}
+
+func truncate(s string, width int) string {
+	if width <= 0 || lipgloss.Width(s) <= width {
+		return s
+	}
+	runes := []rune(s)
+	for len(runes) > 0 && lipgloss.Width(string(runes))+1 > width {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes) + "…"
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// newTestChatApp creates the app without running it, the text sent is
+// replied by chatEval
+func newTestChatApp(t *testing.T) *chatApp {
+	t.Helper()
+	dir := t.TempDir()
+	g := New(WithStdout(io.Discard))
+	sess := NewSession(dir, WithCommandOutput(g))
+	if err := sess.Open(""); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sess.Close() })
+
+	opts := &ChatCommandOptions{Dir: dir, Renderer: "text"}
+	opts.Model = "gpt-test"
+	e := NewEvaluator(sess, &LivePrompt{Prefix: "guru", Delimiter: ">"}, func(text string) {
+		text, cont := g.handleSysBuiltinCommands(text)
+		if !cont {
+			return
+		}
+		sess.Append(&Message{Role: User, Content: text})
+		sess.AppendReply(&Message{Role: Assistant, Content: "reply to " + text})
+	})
+	a := newChatApp(g, sess, &ChatCommand{sess: sess}, e, opts)
+	a.Update(tea.WindowSizeMsg{Width: 100, Height: 30})
+	return a
+}
+
+func typeText(a *chatApp, text string) {
+	a.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(text)})
+}
+
+// run runs the command, and the commands batched, until appDoneMsg is
+// returned, which is sent to the app
+func run(t *testing.T, a *chatApp, cmd tea.Cmd) {
+	t.Helper()
+	cmds := []tea.Cmd{cmd}
+	for len(cmds) > 0 {
+		cmd, cmds = cmds[0], cmds[1:]
+		if cmd == nil {
+			continue
+		}
+		switch msg := cmd().(type) {
+		case tea.BatchMsg:
+			cmds = append(cmds, msg...)
+		case appDoneMsg:
+			a.Update(msg)
+			return
+		}
+	}
+	t.Fatal("the evaluation is not done")
+}
+
+func TestChatAppSubmit(t *testing.T) {
+	a := newTestChatApp(t)
+	typeText(a, "hello")
+	_, cmd := a.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	if !a.busy || a.input.Value() != "" || !strings.Contains(a.View(), "hello") {
+		t.Fatalf("the message is not sent:\n%s", a.View())
+	}
+	// the keys are not sent when busy
+	typeText(a, "more")
+	if _, cmd := a.Update(tea.KeyMsg{Type: tea.KeyEnter}); cmd != nil {
+		t.Errorf("the message is sent when busy")
+	}
+	run(t, a, cmd)
+	if a.busy || len(a.messages) != 2 || !strings.Contains(a.View(), "reply to hello") {
+		t.Errorf("the reply is not shown:\n%s", a.View())
+	}
+
+	// Ctrl+C clears the input first, then quits
+	if _, cmd := a.Update(tea.KeyMsg{Type: tea.KeyCtrlC}); cmd != nil || a.input.Value() != "" {
+		t.Errorf("the input %q is not cleared", a.input.Value())
+	}
+	if _, cmd := a.Update(tea.KeyMsg{Type: tea.KeyCtrlC}); cmd == nil || cmd() != tea.Quit() {
+		t.Errorf("the app does not quit")
+	}
+}
+
+func TestChatAppCommandsEchoed(t *testing.T) {
+	a := newTestChatApp(t)
+	typeText(a, ":message list")
+	_, cmd := a.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	run(t, a, cmd)
+	if !strings.Contains(a.View(), a.prompt+":message list") {
+		t.Errorf("the command is not echoed:\n%s", a.View())
+	}
+	if len(a.messages) != 0 {
+		t.Errorf("%d messages, the command is sent", len(a.messages))
+	}
+}
+
+func TestChatAppEdit(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	a := newTestChatApp(t)
+	typeText(a, "hello")
+
+	// Ctrl+E alone moves to the end of the line as the textarea does
+	a.Update(tea.KeyMsg{Type: tea.KeyCtrlA})
+	a.Update(tea.KeyMsg{Type: tea.KeyCtrlE})
+	typeText(a, "!")
+	if v := a.input.Value(); v != "hello!" {
+		t.Errorf("input %q, want the cursor moved to the end", v)
+	}
+
+	// Ctrl+X Ctrl+E edits the input in $EDITOR
+	if _, cmd := a.Update(tea.KeyMsg{Type: tea.KeyCtrlX}); cmd != nil {
+		t.Errorf("ctrl+x returns a command")
+	}
+	if _, cmd := a.Update(tea.KeyMsg{Type: tea.KeyCtrlE}); cmd == nil {
+		t.Errorf("ctrl+x ctrl+e does not edit the input")
+	}
+	a.Update(appEditedMsg{text: "edited\ntext"})
+	if v := a.input.Value(); v != "edited\ntext" {
+		t.Errorf("input %q, want the edited text", v)
+	}
+}
+
+func TestChatAppSidebar(t *testing.T) {
+	a := newTestChatApp(t)
+	a.sess.Append(&Message{Role: User, Content: "saved"})
+	from := a.sess.sid
+
+	// the sessions are focused by Tab, and forked by f
+	a.Update(tea.KeyMsg{Type: tea.KeyTab})
+	if !a.focused || !strings.Contains(a.View(), "enter switch") {
+		t.Fatalf("the sidebar is not focused:\n%s", a.View())
+	}
+	// the keys typed are not inserted when focused
+	a.Update(tea.KeyMsg{Type: tea.KeyCtrlX})
+	if a.ctrlX {
+		t.Errorf("ctrl+x is taken by the sidebar")
+	}
+	_, cmd := a.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("f")})
+	run(t, a, cmd)
+	if a.input.Value() != "" || a.sid == from || len(a.sessions) != 2 || len(a.messages) != 1 {
+		t.Errorf("the session %s is not forked from %s: %d sessions, %d messages",
+			a.sid, from, len(a.sessions), len(a.messages))
+	}
+
+	// Ctrl+S hides the sidebar, and the current session is shown in the
+	// status bar instead
+	a.Update(tea.KeyMsg{Type: tea.KeyCtrlS})
+	if a.sidebar || a.focused || !strings.Contains(a.View(), "│ "+sessionLabel(a.sid)+" │") {
+		t.Errorf("the sidebar is not hidden:\n%s", a.View())
+	}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/shafreeck/cortana"
+)
+
+// Price is the price in USD per 1K tokens
+type Price struct {
+	Prompt     float64 `yaml:"prompt" json:"prompt"`
+	Completion float64 `yaml:"completion" json:"completion"`
+}
+
+// defaultPrices is used if the model is not in the configured prices,
+// the model is matched by the longest prefix
+var defaultPrices = map[string]Price{
+	"gpt-3.5-turbo": {Prompt: 0.0005, Completion: 0.0015},
+	"gpt-4":         {Prompt: 0.03, Completion: 0.06},
+	"gpt-4-32k":     {Prompt: 0.06, Completion: 0.12},
+	"gpt-4-turbo":   {Prompt: 0.01, Completion: 0.03},
+	"gpt-4o":        {Prompt: 0.005, Completion: 0.015},
+	"gpt-4o-mini":   {Prompt: 0.00015, Completion: 0.0006},
+}
+
+// lookupPrice finds the price of model by the longest prefix
+func lookupPrice(prices map[string]Price, model string) (Price, bool) {
+	find := func(prices map[string]Price) (price Price, key string) {
+		for k, p := range prices {
+			if strings.HasPrefix(model, k) && len(k) > len(key) {
+				price, key = p, k
+			}
+		}
+		return
+	}
+	if p, k := find(prices); k != "" {
+		return p, true
+	}
+	p, k := find(defaultPrices)
+	return p, k != ""
+}
+
+// UsageEntry is the usage of a request, which is recorded
+// in the session history and the ledger
+type UsageEntry struct {
+	Time      time.Time
+	SessionID string `json:",omitempty"`
+	Model     string
+	Usage
+	Cost      float64
+	Estimated bool `json:",omitempty"` // the tokens are counted locally
+	Cached    bool `json:",omitempty"` // replied from the cache
+}
+
+func NewUsageEntry(prices map[string]Price, sid, model string, u Usage, estimated bool) *UsageEntry {
+	e := &UsageEntry{Time: time.Now(), SessionID: sid, Model: model, Usage: u, Estimated: estimated}
+	if p, ok := lookupPrice(prices, model); ok {
+		e.Cost = (float64(u.PromptTokens)*p.Prompt + float64(u.CompletionTokens)*p.Completion) / 1000
+	}
+	return e
+}
+
+// estimateTokens counts the tokens of text roughly, it is used when
+// the api does not reply the usage. A CJK character is counted as a
+// token, and about 4 other characters are counted as a token.
+func estimateTokens(text string) int {
+	var cjk, others int
+	for _, r := range text {
+		if unicode.In(r, unicode.Han, unicode.Hangul, unicode.Hiragana, unicode.Katakana) {
+			cjk++
+			continue
+		}
+		others++
+	}
+	return cjk + (others+3)/4
+}
+
+// estimateUsage counts the usage of messages and the reply locally
+func estimateUsage(messages []*Message, reply string) Usage {
+	var u Usage
+	for _, m := range messages {
+		// every message follows <im_start>{role/name}\n{content}<im_end>\n
+		u.PromptTokens += estimateTokens(m.Content) + 4
+	}
+	u.PromptTokens += 2 // every reply is primed with <im_start>assistant
+	u.CompletionTokens = estimateTokens(reply)
+	u.TotalTokens = u.PromptTokens + u.CompletionTokens
+	return u
+}
+
+// Ledger records the usage of all requests
+type Ledger struct {
+	filename string
+
+	// the cost of the month is summed up by reading the entries
+	// appended after offset, so the ledger is not read again and again
+	mu     sync.Mutex
+	month  time.Time
+	offset int64
+	cost   float64
+}
+
+func NewLedger(dir string) *Ledger {
+	return &Ledger{filename: path.Join(dir, "usage.jsonl")}
+}
+
+func (l *Ledger) Append(e *UsageEntry) error {
+	f, err := os.OpenFile(l.filename, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(f, string(data))
+	return err
+}
+
+// scan reads the entries from offset, the malformed lines like the ones
+// broken by a crash are skipped and counted. The offset after the last
+// complete line is returned, the line being appended is read next time
+func (l *Ledger) scan(offset int64, fn func(e *UsageEntry)) (next int64, skipped int, err error) {
+	f, err := os.Open(l.filename)
+	if os.IsNotExist(err) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return offset, 0, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return offset, 0, err
+	}
+
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			return offset, skipped, nil
+		}
+		if err != nil {
+			return offset, skipped, err
+		}
+		offset += int64(len(line))
+		e := &UsageEntry{}
+		if err := json.Unmarshal(line, e); err != nil {
+			skipped++
+			continue
+		}
+		fn(e)
+	}
+}
+
+// Entries returns the entries recorded since the time, and the number of
+// the malformed lines skipped
+func (l *Ledger) Entries(since time.Time) ([]*UsageEntry, int, error) {
+	var entries []*UsageEntry
+	_, skipped, err := l.scan(0, func(e *UsageEntry) {
+		if !e.Time.Before(since) {
+			entries = append(entries, e)
+		}
+	})
+	return entries, skipped, err
+}
+
+// MonthCost returns the cost of the current month, only the entries
+// appended since the last call are read
+func (l *Ledger) MonthCost() (float64, error) {
+	now := time.Now()
+	begin := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// sum up again in a new month, or if the ledger is truncated
+	if info, err := os.Stat(l.filename); !l.month.Equal(begin) || (err == nil && info.Size() < l.offset) {
+		l.month, l.offset, l.cost = begin, 0, 0
+	}
+	offset, _, err := l.scan(l.offset, func(e *UsageEntry) {
+		if !e.Time.Before(begin) {
+			l.cost += e.Cost
+		}
+	})
+	l.offset = offset
+	return l.cost, err
+}
+
+// parseSince parses a duration like 7d, 12h, or a date like 2006-01-02
+func parseSince(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.Now().AddDate(0, 0, -days), nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Now().Add(-d), nil
+}
+
+// UsageCommand reports the usage and cost
+func (g *Guru) UsageCommand() {
+	opts := struct {
+		Dir   string `cortana:"--dir,-, ~/.guru, the guru directory"`
+		Since string `cortana:"--since, -, 30d, report the usage since the duration(7d, 12h) or date(2006-01-02)"`
+		By    string `cortana:"--by, -, model, group the usage by model, session or day"`
+	}{}
+	cortana.Parse(&opts)
+
+	since, err := parseSince(opts.Since)
+	if err != nil {
+		g.Fatalln(err)
+	}
+
+	var key func(e *UsageEntry) string
+	switch opts.By {
+	case "model":
+		key = func(e *UsageEntry) string { return e.Model }
+	case "session":
+		key = func(e *UsageEntry) string { return e.SessionID }
+	case "day":
+		key = func(e *UsageEntry) string { return e.Time.Local().Format("2006-01-02") }
+	default:
+		g.Fatalln("unknown group:", opts.By)
+	}
+
+	entries, skipped, err := NewLedger(expandPath(opts.Dir)).Entries(since)
+	if err != nil {
+		g.Fatalln(err)
+	}
+	if skipped > 0 {
+		g.Errorln(fmt.Sprintf("%d malformed lines in the ledger are skipped", skipped))
+	}
+
+	type group struct {
+		key       string
+		requests  int
+		estimated bool
+		usage     Usage
+		cost      float64
+	}
+	var total group
+	total.key = "total"
+	groups := make(map[string]*group)
+	for _, e := range entries {
+		k := key(e)
+		grp := groups[k]
+		if grp == nil {
+			grp = &group{key: k}
+			groups[k] = grp
+		}
+		for _, grp := range []*group{grp, &total} {
+			grp.requests++
+			grp.usage.PromptTokens += e.PromptTokens
+			grp.usage.CompletionTokens += e.CompletionTokens
+			grp.usage.TotalTokens += e.TotalTokens
+			grp.cost += e.Cost
+			grp.estimated = grp.estimated || e.Estimated
+		}
+	}
+
+	var sorted []*group
+	for _, grp := range groups {
+		sorted = append(sorted, grp)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].key < sorted[j].key })
+
+	width := len(opts.By)
+	for _, grp := range sorted {
+		if n := utf8.RuneCountInString(grp.key); n > width {
+			width = n
+		}
+	}
+	line := func(grp *group) string {
+		mark := ""
+		if grp.estimated {
+			mark = "~" // some tokens are estimated
+		}
+		return fmt.Sprintf("%-*s %10d %12d %12d %12d %s$%.4f", width, grp.key, grp.requests,
+			grp.usage.PromptTokens, grp.usage.CompletionTokens, grp.usage.TotalTokens, mark, grp.cost)
+	}
+	g.StylePrintln(g.highlightStyle, fmt.Sprintf("%-*s %10s %12s %12s %12s %s", width, opts.By,
+		"requests", "prompt", "completion", "total", "cost"))
+	for _, grp := range sorted {
+		g.Println(line(grp))
+	}
+	g.Println(strings.Repeat("-", width+56))
+	g.Println(line(&total))
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+)
+
+// writeLedger writes the lines into the ledger as is
+func writeLedger(t *testing.T, l *Ledger, lines ...string) {
+	t.Helper()
+	f, err := os.OpenFile(l.filename, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for _, line := range lines {
+		f.WriteString(line)
+	}
+}
+
+func entryLine(t *testing.T, at time.Time, cost float64) string {
+	data, err := json.Marshal(&UsageEntry{Time: at, Model: "gpt-4o", Cost: cost})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data) + "\n"
+}
+
+func TestLedgerSkipsMalformedLines(t *testing.T) {
+	l := NewLedger(t.TempDir())
+	now := time.Now()
+	writeLedger(t, l,
+		entryLine(t, now, 1),
+		"{\"Time\": \"2024-\n", // broken by a crash
+		"not json\n",
+		entryLine(t, now, 2),
+		`{"Time": "`, // being appended
+	)
+
+	entries, skipped, err := l.Entries(time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || skipped != 2 {
+		t.Errorf("%d entries and %d skipped, want 2 and 2", len(entries), skipped)
+	}
+	cost, err := l.MonthCost()
+	if err != nil || cost != 3 {
+		t.Errorf("cost %v, err %v, want 3", cost, err)
+	}
+
+	cc := &ChatCommand{ledger: l, budget: 10, budgetAction: "block"}
+	if err := cc.checkBudget(); err != nil {
+		t.Errorf("check the budget: %v", err)
+	}
+}
+
+func TestLedgerMonthCost(t *testing.T) {
+	l := NewLedger(t.TempDir())
+	if cost, err := l.MonthCost(); err != nil || cost != 0 {
+		t.Fatalf("cost %v, err %v of an empty ledger", cost, err)
+	}
+
+	now := time.Now()
+	lastMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location()).Add(-time.Hour)
+	writeLedger(t, l, entryLine(t, lastMonth, 100), entryLine(t, now, 1))
+	if cost, _ := l.MonthCost(); cost != 1 {
+		t.Errorf("cost %v, want 1 of this month", cost)
+	}
+
+	// the entries appended are summed up, including the line completed later
+	offset := l.offset
+	l.Append(&UsageEntry{Time: now, Cost: 2})
+	writeLedger(t, l, `{"Time":"`+now.Format(time.RFC3339Nano)+`",`)
+	if cost, _ := l.MonthCost(); cost != 3 {
+		t.Errorf("cost %v, want 3", cost)
+	}
+	if l.offset <= offset {
+		t.Errorf("the ledger is read again from %d", l.offset)
+	}
+	writeLedger(t, l, `"Cost":4}`+"\n")
+	if cost, _ := l.MonthCost(); cost != 7 {
+		t.Errorf("cost %v, want 7", cost)
+	}
+
+	// the cost is summed up again if the ledger is truncated
+	os.Truncate(l.filename, 0)
+	writeLedger(t, l, entryLine(t, now, 5))
+	if cost, _ := l.MonthCost(); cost != 5 {
+		t.Errorf("cost %v, want 5 after truncated", cost)
+	}
+}
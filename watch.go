@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/shafreeck/cortana"
+	"github.com/shafreeck/guru/tui"
+	"github.com/shafreeck/guru/watch"
+)
+
+type WatchCommandOptions struct {
+	ChatGPTOptions `yaml:"chatgpt,omitempty"`
+	ClientOptions  `yaml:",inline"`
+	Prices         map[string]Price `cortana:"-, -" yaml:"prices,omitempty"`
+	Dir            string           `cortana:"--dir,-, ~/.guru, the guru directory" yaml:"dir,omitempty"`
+	Renderer       string           `cortana:"--renderer,, , the render type, can be markdown, text, raw, json, yaml, code or html. markdown is used if not set" yaml:"renderer,omitempty"`
+	Theme          string           `cortana:"--theme, -, auto, the theme of the colors in ~/.guru/themes, auto picks dark or light by the terminal" yaml:"theme,omitempty"`
+	Prompt         string           `cortana:"--prompt, -p, , the prompt name or text pinned in the session" yaml:"-"`
+	Cmd            string           `cortana:"--cmd, -, , the command to run when the files change, its output is sent to the model" yaml:"-"`
+	Files          []string         `cortana:"--files, -, , the glob patterns of the files to watch separated by commas, ** matches any directories" yaml:"-"`
+	Debounce       time.Duration    `cortana:"--debounce, -, 500ms, wait until the files stop changing for the duration" yaml:"debounce,omitempty"`
+	Poll           bool             `cortana:"--poll, -, false, poll the files instead of using inotify" yaml:"poll,omitempty"`
+	SessionID      string           `cortana:"--session-id, -, , the session to talk in, a new one is created if empty" yaml:"-"`
+}
+
+// WatchCommand runs the command or reads the files when the files change,
+// and asks the model with the output and the diff since the last run
+func (g *Guru) WatchCommand() {
+	opts := &WatchCommandOptions{}
+	cortana.Parse(opts)
+	opts.Cmd = strings.TrimSpace(opts.Cmd)
+	if opts.Cmd == "" && len(opts.Files) == 0 {
+		g.Fatalln("--cmd or --files is required")
+	}
+	if opts.Prompt == "" {
+		g.Fatalln("--prompt is required")
+	}
+	if _, err := tui.LookupRenderer(opts.Renderer); err != nil {
+		g.Fatalln(err)
+	}
+	// the command is run when any file under the current directory changes
+	var patterns []string
+	for _, f := range opts.Files {
+		patterns = append(patterns, strings.Split(f, ",")...)
+	}
+	if len(patterns) == 0 {
+		patterns = []string{"**"}
+	}
+
+	opts.Dir = expandPath(opts.Dir)
+	if err := initGuruDirs(opts.Dir); err != nil {
+		g.Fatalln("initialize guru directories failed", err)
+	}
+	g.useTheme(opts.Dir, opts.Theme)
+
+	sess := NewSession(path.Join(opts.Dir, "session"), WithCommandOutput(g), WithHighlightStyle(g.highlightStyle))
+	sid := opts.SessionID
+	if sid == "" {
+		sid = fmt.Sprintf("watch-%d", time.Now().UnixMilli())
+	}
+	if err := sess.Open(sid); err != nil {
+		g.Fatalln(err)
+	}
+	defer sess.Close()
+	g.sess = sess
+
+	g.resolveAPIKey(&opts.ClientOptions, opts.Dir)
+	httpCli := g.getHTTPClient(&opts.ClientOptions)
+	ap := NewAwesomePrompts(path.Join(opts.Dir, "prompt"), httpCli, g)
+	if err := ap.Load(); err != nil {
+		g.Fatalln(err)
+	}
+	prompt := opts.Prompt
+	if text := ap.PromptText(opts.Prompt); text != "" {
+		prompt = text
+	}
+	if r := ap.PromptRenderer(opts.Prompt); r != "" && opts.Renderer == "" {
+		if _, err := tui.LookupRenderer(r); err != nil {
+			g.Fatalln(err)
+		}
+		opts.Renderer = r
+	}
+	if opts.Renderer == "" {
+		opts.Renderer = "markdown"
+	}
+	// the prompt is pinned, so it is kept when the session is shrunk
+	sess.Append(&Message{Role: User, Content: prompt}, true)
+
+	cli := g.newChatClient(httpCli, &opts.ClientOptions, opts.Dir)
+	cc := NewChatCommand(sess, ap, cli, &ChatCommandOptions{ChatGPTOptions: opts.ChatGPTOptions,
+		ClientOptions: opts.ClientOptions, Dir: opts.Dir, Prices: opts.Prices})
+	if !tui.IsRenderable() {
+		// stdin is not read, /dev/null can not be polled and breaks the
+		// program, Ctrl+C is handled as the signal instead
+		cc.display = []tea.ProgramOption{tea.WithInput(strings.NewReader(""))}
+	}
+
+	w, err := watch.New(patterns, opts.Poll, time.Second)
+	if err != nil {
+		g.Fatalln(err)
+	}
+	defer w.Close()
+
+	// the request of a run is canceled by Ctrl+C as well
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	cc.ctx = ctx
+
+	wr := &watchRun{g: g, cc: cc, opts: opts, patterns: patterns}
+	if len(opts.Files) == 0 {
+		// the command may write the files under the current directory, like
+		// the build outputs, which should not trigger the runs again
+		wr.written = make(map[string]bool)
+	}
+	wr.run(nil)
+	g.StylePrintln(g.highlightStyle, fmt.Sprintf("watching %s, press Ctrl+C to stop", strings.Join(patterns, ", ")))
+
+	changed := make(map[string]bool)
+	var timer <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case err := <-w.Errors:
+			g.Errorln(err)
+			return
+		case name := <-w.Events:
+			if wr.written[name] {
+				continue
+			}
+			// debounce, run once the files stop changing
+			changed[name] = true
+			timer = time.After(opts.Debounce)
+		case <-timer:
+			var names []string
+			for name := range changed {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			changed = make(map[string]bool)
+			timer = nil
+			wr.run(names)
+		}
+	}
+}
+
+type watchRun struct {
+	g        *Guru
+	cc       *ChatCommand
+	opts     *WatchCommandOptions
+	patterns []string
+	last     string
+	ran      bool
+	written  map[string]bool // the files written by the command, nil if not tracked
+}
+
+// run sends the output to the model if it changes since the last run
+func (wr *watchRun) run(changed []string) {
+	g := wr.g
+	title := "== " + time.Now().Format("15:04:05")
+	if len(changed) > 0 {
+		title += " changed: " + strings.Join(changed, ", ")
+	}
+	g.StylePrintln(g.highlightStyle, title)
+
+	output := wr.output()
+	if wr.ran && output == wr.last {
+		g.Println("the output is not changed since the last run")
+		return
+	}
+
+	var text strings.Builder
+	if wr.opts.Cmd != "" {
+		fmt.Fprintf(&text, "$ %s\n", wr.opts.Cmd)
+	}
+	if len(changed) > 0 {
+		fmt.Fprintf(&text, "The changed files: %s\n", strings.Join(changed, ", "))
+	}
+	if wr.ran {
+		fmt.Fprintf(&text, "\nThe diff since the last run:\n```diff\n%s```\n\nThe full output:\n", lineDiff(wr.last, output))
+	}
+	fmt.Fprintf(&text, "```\n%s\n```", strings.TrimRight(output, "\n"))
+	wr.last, wr.ran = output, true
+
+	_, err := wr.cc.Talk(&ChatOptions{ChatGPTOptions: wr.opts.ChatGPTOptions, Renderer: wr.opts.Renderer,
+		NonInteractive: true, Text: text.String()})
+	// the reply is printed without the newline if not rendered by the tui
+	if !tui.IsRenderable() {
+		g.Println()
+	}
+	if err != nil {
+		g.Errorln(err)
+	}
+}
+
+// output runs the command, or reads the files if there is no command
+func (wr *watchRun) output() string {
+	if wr.opts.Cmd == "" {
+		var b strings.Builder
+		for _, name := range watch.Glob(wr.patterns) {
+			data, err := os.ReadFile(name)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(&b, "==> %s <==\n%s\n", name, data)
+		}
+		return b.String()
+	}
+
+	var before map[string]fileStat
+	if wr.written != nil {
+		before = statFiles(wr.patterns)
+	}
+	// the command is run by the shell, so the pipes and the redirections
+	// work, the output is kept when it fails, it is what to look into
+	out, err := exec.Command("sh", "-c", wr.opts.Cmd).CombinedOutput()
+	if wr.written != nil {
+		// the files changed while the command runs are taken as its outputs,
+		// they are collected again by each run
+		after := statFiles(wr.patterns)
+		written := make(map[string]bool)
+		for name, st := range after {
+			if old, ok := before[name]; !ok || old != st {
+				written[name] = true
+			}
+		}
+		for name := range before {
+			if _, ok := after[name]; !ok {
+				written[name] = true
+			}
+		}
+		wr.written = written
+	}
+	output := string(out)
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		output += fmt.Sprintf("\n(exit status %d)", exitErr.ExitCode())
+	} else if err != nil {
+		output += "\n" + err.Error()
+	}
+	wr.g.Println(strings.TrimRight(output, "\n"))
+	return output
+}
+
+type fileStat struct {
+	size    int64
+	modTime time.Time
+}
+
+// statFiles returns the stats of the files matching the patterns
+func statFiles(patterns []string) map[string]fileStat {
+	stats := make(map[string]fileStat)
+	for _, name := range watch.Glob(patterns) {
+		if info, err := os.Stat(name); err == nil {
+			stats[name] = fileStat{size: info.Size(), modTime: info.ModTime()}
+		}
+	}
+	return stats
+}
+
+// lineDiff returns the unified diff of the lines with 3 lines of context
+func lineDiff(before, after string) string {
+	const context = 3
+	x := strings.Split(strings.TrimRight(before, "\n"), "\n")
+	y := strings.Split(strings.TrimRight(after, "\n"), "\n")
+	// it is too large to compute the lcs table
+	if len(x)*len(y) > 4000000 {
+		return "the output is too large to diff\n"
+	}
+
+	// lcs[i][j] is the length of the lcs of x[i:] and y[j:]
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = lcs[i+1][j]
+				if lcs[i][j+1] > lcs[i][j] {
+					lcs[i][j] = lcs[i][j+1]
+				}
+			}
+		}
+	}
+
+	type edit struct {
+		op   byte // ' ', '-' or '+'
+		line string
+	}
+	var edits []edit
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			edits = append(edits, edit{' ', x[i]})
+			i, j = i+1, j+1
+		case i < len(x) && (j == len(y) || lcs[i+1][j] >= lcs[i][j+1]):
+			edits = append(edits, edit{'-', x[i]})
+			i++
+		default:
+			edits = append(edits, edit{'+', y[j]})
+			j++
+		}
+	}
+
+	// keep the changes and the lines around them
+	var b strings.Builder
+	keep := make([]bool, len(edits))
+	for k, e := range edits {
+		if e.op == ' ' {
+			continue
+		}
+		for n := k - context; n <= k+context; n++ {
+			if n >= 0 && n < len(edits) {
+				keep[n] = true
+			}
+		}
+	}
+	for k, e := range edits {
+		if !keep[k] {
+			continue
+		}
+		if k > 0 && !keep[k-1] {
+			b.WriteString("...\n")
+		}
+		b.WriteByte(e.op)
+		b.WriteString(e.line)
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
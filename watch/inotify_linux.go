@@ -0,0 +1,125 @@
+//go:build linux
+
+package watch
+
+import (
+	"io/fs"
+	"path"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const inotifyMask = unix.IN_CLOSE_WRITE | unix.IN_CREATE | unix.IN_DELETE | unix.IN_MODIFY |
+	unix.IN_MOVED_FROM | unix.IN_MOVED_TO | unix.IN_DELETE_SELF
+
+// inotify watches all the directories under the roots, the new
+// directories are watched once they are created
+func (w *Watcher) inotify() error {
+	// the fd is not blocking, so the reading goroutine could be woken up by
+	// the eventfd to exit when the watcher is closed
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return err
+	}
+	wake, err := unix.Eventfd(0, unix.EFD_CLOEXEC|unix.EFD_NONBLOCK)
+	if err != nil {
+		unix.Close(fd)
+		return err
+	}
+
+	var mu sync.Mutex
+	dirs := make(map[int]string)
+	add := func(dir string) error {
+		wd, err := unix.InotifyAddWatch(fd, dir, inotifyMask)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		dirs[wd] = dir
+		mu.Unlock()
+		return nil
+	}
+
+	var addErr error
+	walk(w.patterns, func(name string, d fs.DirEntry) {
+		if d.IsDir() && addErr == nil {
+			addErr = add(name)
+		}
+	})
+	if addErr != nil {
+		unix.Close(fd)
+		unix.Close(wake)
+		return addErr
+	}
+
+	exited := make(chan struct{})
+	w.close = func() error {
+		// wake the goroutine up and wait for it to close the inotify fd
+		unix.Write(wake, []byte{1, 0, 0, 0, 0, 0, 0, 0})
+		<-exited
+		return unix.Close(wake)
+	}
+	go func() {
+		defer close(exited)
+		defer unix.Close(fd)
+
+		buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+		fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}, {Fd: int32(wake), Events: unix.POLLIN}}
+		for {
+			_, err := unix.Poll(fds, -1)
+			if err == nil && fds[1].Revents != 0 {
+				return // closed
+			}
+			var n int
+			if err == nil {
+				n, err = unix.Read(fd, buf)
+			}
+			if err == unix.EINTR || err == unix.EAGAIN {
+				continue
+			}
+			if err != nil {
+				select {
+				case <-w.done:
+				case w.Errors <- err:
+				}
+				return
+			}
+			for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+				e := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+				nameBytes := buf[offset+unix.SizeofInotifyEvent : offset+unix.SizeofInotifyEvent+int(e.Len)]
+				offset += unix.SizeofInotifyEvent + int(e.Len)
+
+				mu.Lock()
+				dir, ok := dirs[int(e.Wd)]
+				if e.Mask&(unix.IN_DELETE_SELF|unix.IN_IGNORED) != 0 {
+					delete(dirs, int(e.Wd))
+				}
+				mu.Unlock()
+				if !ok || e.Len == 0 {
+					continue
+				}
+
+				name := path.Join(dir, unix.ByteSliceToString(nameBytes))
+				if e.Mask&unix.IN_ISDIR != 0 {
+					// watch the new directory and the ones under it
+					if e.Mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0 && !skipDir("", name) {
+						walkDir(name, func(name string, d fs.DirEntry) {
+							if d.IsDir() {
+								add(name)
+							} else if matchAny(w.patterns, name) {
+								w.send(name)
+							}
+						})
+					}
+					continue
+				}
+				if matchAny(w.patterns, name) {
+					w.send(name)
+				}
+			}
+		}
+	}()
+	return nil
+}
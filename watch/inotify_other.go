@@ -0,0 +1,10 @@
+//go:build !linux
+
+package watch
+
+import "errors"
+
+// inotify is only available on linux, the files are polled otherwise
+func (w *Watcher) inotify() error {
+	return errors.New("inotify is not supported")
+}
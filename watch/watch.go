@@ -0,0 +1,239 @@
+// Package watch notifies the changes of the files matching the glob
+// patterns, it uses inotify on linux and polls the files otherwise
+package watch
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Match reports whether the slash separated name matches the pattern,
+// which supports "**" to match any number of directories besides the
+// syntax of path.Match
+func Match(pattern, name string) bool {
+	return matchSegments(strings.Split(path.Clean(pattern), "/"), strings.Split(path.Clean(name), "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			// "**" matches zero or more segments
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// root returns the directory to watch for the pattern, which is the
+// leading segments without the meta characters
+func root(pattern string) string {
+	var dirs []string
+	segments := strings.Split(path.Clean(pattern), "/")
+	for _, s := range segments[:len(segments)-1] {
+		if strings.ContainsAny(s, "*?[\\") {
+			break
+		}
+		dirs = append(dirs, s)
+	}
+	if len(dirs) == 0 {
+		return "."
+	}
+	if dirs[0] == "" { // the absolute path
+		return "/" + path.Join(dirs[1:]...)
+	}
+	return path.Join(dirs...)
+}
+
+// roots returns the directories to watch, the nested ones are removed
+func roots(patterns []string) []string {
+	var dirs []string
+	for _, p := range patterns {
+		dirs = append(dirs, root(p))
+	}
+	sort.Strings(dirs)
+	var result []string
+	for _, d := range dirs {
+		if n := len(result); n > 0 && (d == result[n-1] || isUnder(d, result[n-1])) {
+			continue
+		}
+		result = append(result, d)
+	}
+	return result
+}
+
+func isUnder(name, dir string) bool {
+	return dir == "." && !strings.HasPrefix(name, "/") ||
+		strings.HasPrefix(name, strings.TrimSuffix(dir, "/")+"/")
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if Match(p, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// skipDir reports whether the directory should not be watched, the hidden
+// directories like .git are skipped
+func skipDir(root, dir string) bool {
+	return dir != root && strings.HasPrefix(path.Base(dir), ".") && path.Base(dir) != "."
+}
+
+// walk visits the directories and the matched files under the roots
+func walk(patterns []string, visit func(name string, d fs.DirEntry)) {
+	for _, r := range roots(patterns) {
+		walkDir(r, func(name string, d fs.DirEntry) {
+			if d.IsDir() || matchAny(patterns, name) {
+				visit(name, d)
+			}
+		})
+	}
+}
+
+// walkDir visits the files and directories under root except the hidden ones
+func walkDir(root string, visit func(name string, d fs.DirEntry)) {
+	filepath.WalkDir(root, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		name = filepath.ToSlash(name)
+		if d.IsDir() && skipDir(root, name) {
+			return filepath.SkipDir
+		}
+		visit(name, d)
+		return nil
+	})
+}
+
+// Glob returns the files matching the patterns in order
+func Glob(patterns []string) []string {
+	var files []string
+	walk(patterns, func(name string, d fs.DirEntry) {
+		if !d.IsDir() {
+			files = append(files, name)
+		}
+	})
+	sort.Strings(files)
+	return files
+}
+
+// Watcher sends the names of the files changed to Events
+type Watcher struct {
+	Events chan string
+	Errors chan error
+
+	patterns []string
+	done     chan struct{}
+	once     sync.Once
+	close    func() error
+}
+
+// New watches the files matching the patterns, inotify is used on linux
+// unless poll is set, and it falls back to polling if inotify fails
+func New(patterns []string, poll bool, interval time.Duration) (*Watcher, error) {
+	w := &Watcher{
+		Events:   make(chan string, 64),
+		Errors:   make(chan error, 1),
+		patterns: patterns,
+		done:     make(chan struct{}),
+	}
+	if !poll {
+		if err := w.inotify(); err == nil {
+			return w, nil
+		}
+	}
+	w.poll(interval)
+	return w, nil
+}
+
+func (w *Watcher) Close() error {
+	var err error
+	w.once.Do(func() {
+		close(w.done)
+		if w.close != nil {
+			err = w.close()
+		}
+	})
+	return err
+}
+
+func (w *Watcher) send(name string) {
+	select {
+	case w.Events <- name:
+	case <-w.done:
+	}
+}
+
+type fileStat struct {
+	size    int64
+	modTime time.Time
+}
+
+// poll compares the stats of files every interval
+func (w *Watcher) poll(interval time.Duration) {
+	if interval <= 0 {
+		interval = time.Second
+	}
+	scan := func() map[string]fileStat {
+		stats := make(map[string]fileStat)
+		walk(w.patterns, func(name string, d fs.DirEntry) {
+			if d.IsDir() {
+				return
+			}
+			if info, err := os.Stat(name); err == nil {
+				stats[name] = fileStat{size: info.Size(), modTime: info.ModTime()}
+			}
+		})
+		return stats
+	}
+
+	last := scan()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-w.done:
+				return
+			case <-ticker.C:
+			}
+			current := scan()
+			var changed []string
+			for name, s := range current {
+				if old, ok := last[name]; !ok || old != s {
+					changed = append(changed, name)
+				}
+			}
+			for name := range last {
+				if _, ok := current[name]; !ok {
+					changed = append(changed, name)
+				}
+			}
+			sort.Strings(changed)
+			for _, name := range changed {
+				w.send(name)
+			}
+			last = current
+		}
+	}()
+}
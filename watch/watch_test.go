@@ -0,0 +1,128 @@
+package watch
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestMatch(t *testing.T) {
+	cases := []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"**", "a.go", true},
+		{"**", "a/b/c.go", true},
+		{"**/*.go", "a.go", true},
+		{"**/*.go", "a/b/c.go", true},
+		{"**/*.go", "a/b/c.txt", false},
+		{"src/**", "src/a/b", true},
+		{"src/**", "lib/a", false},
+		{"src/*.go", "src/a/b.go", false},
+		{"a/**/b", "a/b", true},
+		{"a/**/b", "a/x/y/b", true},
+		{"./a.go", "a.go", true},
+	}
+	for _, c := range cases {
+		if got := Match(c.pattern, c.name); got != c.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", c.pattern, c.name, got, c.want)
+		}
+	}
+}
+
+func TestRoots(t *testing.T) {
+	got := roots([]string{"src/**/*.go", "src/a/*.go", "lib/*.go", "/abs/**"})
+	want := []string{"/abs", "lib", "src"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("roots %v, want %v", got, want)
+	}
+}
+
+// chdir changes to a temp directory with the files
+func chdir(t *testing.T, files ...string) string {
+	t.Helper()
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	for _, f := range files {
+		os.MkdirAll(filepath.Dir(f), 0755)
+		if err := os.WriteFile(f, []byte(f), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestGlob(t *testing.T) {
+	chdir(t, "a.go", "b.txt", "sub/c.go", ".git/d.go")
+	got := Glob([]string{"**/*.go"})
+	want := []string{"a.go", "sub/c.go"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("glob %v, want %v", got, want)
+	}
+}
+
+func testWatch(t *testing.T, poll bool) {
+	chdir(t, "a.go", "b.txt")
+	w, err := New([]string{"**/*.go"}, poll, 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	os.WriteFile("b.txt", []byte("changed"), 0644)
+	os.MkdirAll("sub", 0755)
+	time.Sleep(100 * time.Millisecond) // the new directory is watched
+	os.WriteFile("sub/c.go", []byte("new"), 0644)
+	os.WriteFile("a.go", []byte("changed"), 0644)
+
+	got := make(map[string]bool)
+	timeout := time.After(3 * time.Second)
+	for len(got) < 2 {
+		select {
+		case name := <-w.Events:
+			got[name] = true
+		case err := <-w.Errors:
+			t.Fatal(err)
+		case <-timeout:
+			t.Fatalf("events %v, want a.go and sub/c.go", got)
+		}
+	}
+	if !got["a.go"] || !got["sub/c.go"] || got["b.txt"] {
+		t.Errorf("events %v, want a.go and sub/c.go", got)
+	}
+}
+
+func TestWatchInotify(t *testing.T) { testWatch(t, false) }
+func TestWatchPoll(t *testing.T)    { testWatch(t, true) }
+
+func TestCloseStopsWatching(t *testing.T) {
+	chdir(t, "a.go")
+	before := runtime.NumGoroutine()
+	for i := 0; i < 10; i++ {
+		w, err := New([]string{"**"}, false, 50*time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the goroutines exit once the watchers are closed
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Errorf("%d goroutines leaked", n-before)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestWatchRunWrittenFiles(t *testing.T) {
+	wd, _ := os.Getwd()
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	os.WriteFile("main.go", []byte("package main"), 0644)
+	os.WriteFile("stale.log", []byte("old"), 0644)
+
+	var out bytes.Buffer
+	wr := &watchRun{g: New(WithStdout(&out)), patterns: []string{"**"}, written: make(map[string]bool),
+		opts: &WatchCommandOptions{Cmd: "echo built > app.out; rm stale.log; echo done"}}
+	if output := wr.output(); output != "done\n" {
+		t.Errorf("output %q, want done", output)
+	}
+	want := map[string]bool{"app.out": true, "stale.log": true}
+	if !reflect.DeepEqual(wr.written, want) {
+		t.Errorf("written %v, want %v", wr.written, want)
+	}
+
+	// the files are collected again by each run
+	wr.opts.Cmd = "echo nothing"
+	wr.output()
+	if len(wr.written) != 0 {
+		t.Errorf("written %v, want none", wr.written)
+	}
+}
+
+func TestWatchRunShell(t *testing.T) {
+	var out bytes.Buffer
+	wr := &watchRun{g: New(WithStdout(&out)), patterns: []string{"**"}}
+	for cmd, want := range map[string]string{
+		"echo apple | tr a A":     "Apple\n",
+		"echo failed >&2; exit 3": "failed\n\n(exit status 3)",
+	} {
+		wr.opts = &WatchCommandOptions{Cmd: cmd}
+		if output := wr.output(); output != want {
+			t.Errorf("%s: output %q, want %q", cmd, output, want)
+		}
+	}
+}
+
+func TestLineDiff(t *testing.T) {
+	diff := lineDiff("a\nb\nc\n", "a\nB\nc\nd\n")
+	want := " a\n-b\n+B\n c\n+d\n"
+	if diff != want {
+		t.Errorf("diff %q, want %q", diff, want)
+	}
+}
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/chzyer/readline"
+	"github.com/shafreeck/cortana"
+	"github.com/shafreeck/guru/tui"
+	"gopkg.in/yaml.v3"
+)
+
+// Workflow is a chain of steps, the outputs of the previous steps could be
+// referred by the templates of the later steps like {{.steps.summary.output}}
+type Workflow struct {
+	Name    string            `yaml:"name"`
+	Vars    map[string]string `yaml:"vars"`    // the default vars, overridden by the arguments
+	ChatGPT yaml.Node         `yaml:"chatgpt"` // the chatgpt options of all steps
+	Steps   []*WorkflowStep   `yaml:"steps"`
+}
+
+// WorkflowStep asks the model with the prompt, or runs the shell command
+type WorkflowStep struct {
+	ID     string `yaml:"id"`
+	If     string `yaml:"if"`     // the template of the condition, the step is skipped if it is false
+	System string `yaml:"system"` // the template of the system message
+	Prompt string `yaml:"prompt"` // the template of the prompt
+	// the command is executed without a shell and not rendered, so the
+	// outputs are never injected into the command line, pass them by input
+	Shell       string    `yaml:"shell"`
+	Input       string    `yaml:"input"`        // the template of the stdin of the command
+	KeepContext bool      `yaml:"keep_context"` // continue the conversation of the previous steps
+	ChatGPT     yaml.Node `yaml:"chatgpt"`      // the chatgpt options of the step
+
+	tmpl map[string]*template.Template
+}
+
+var invalidRunIDChars = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// the step ids are referred like {{.steps.id.output}} by the templates,
+// which do not take the other characters like "-" in the field names
+var validStepID = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+var workflowFuncs = template.FuncMap{
+	"trim":      strings.TrimSpace,
+	"lower":     strings.ToLower,
+	"upper":     strings.ToUpper,
+	"contains":  strings.Contains,
+	"hasPrefix": strings.HasPrefix,
+	"hasSuffix": strings.HasSuffix,
+	"replace":   strings.ReplaceAll,
+}
+
+func LoadWorkflow(filename string) (*Workflow, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	w := &Workflow{}
+	if err := yaml.Unmarshal(data, w); err != nil {
+		return nil, err
+	}
+	if w.Name == "" {
+		w.Name = strings.TrimSuffix(path.Base(filename), path.Ext(filename))
+	}
+	if len(w.Steps) == 0 {
+		return nil, errors.New("no steps in the workflow")
+	}
+
+	seen := make(map[string]bool)
+	for i, step := range w.Steps {
+		if step.ID == "" {
+			return nil, fmt.Errorf("the step %d has no id", i+1)
+		}
+		if !validStepID.MatchString(step.ID) {
+			return nil, fmt.Errorf("invalid step id %q, use letters, digits and underscores", step.ID)
+		}
+		if seen[step.ID] {
+			return nil, fmt.Errorf("duplicated step: %s", step.ID)
+		}
+		seen[step.ID] = true
+		if (step.Prompt == "") == (step.Shell == "") {
+			return nil, fmt.Errorf("the step %s should have either prompt or shell", step.ID)
+		}
+
+		// parse the templates ahead, so the errors are reported before running
+		step.tmpl = make(map[string]*template.Template)
+		for name, text := range map[string]string{"if": step.If, "system": step.System,
+			"prompt": step.Prompt, "input": step.Input} {
+			t, err := template.New(step.ID + "." + name).Funcs(workflowFuncs).
+				Option("missingkey=error").Parse(text)
+			if err != nil {
+				return nil, err
+			}
+			step.tmpl[name] = t
+		}
+	}
+	return w, nil
+}
+
+func (s *WorkflowStep) render(name string, data any) (string, error) {
+	out := bytes.NewBuffer(nil)
+	if err := s.tmpl[name].Execute(out, data); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// the status of steps, and the runs which are running, done or failed
+const (
+	StepRunning = "running"
+	StepDone    = "done"
+	StepSkipped = "skipped"
+	StepFailed  = "failed"
+)
+
+type StepState struct {
+	Status string `json:"status"`
+	Output string `json:"output"`
+	Error  string `json:"error,omitempty"`
+}
+
+// RunState is saved after every step, so a failed run could be resumed
+type RunState struct {
+	ID        string                `json:"id"`
+	Workflow  string                `json:"workflow"` // the absolute path of the workflow file
+	SessionID string                `json:"session_id"`
+	Created   time.Time             `json:"created"`
+	Status    string                `json:"status"`
+	Input     string                `json:"input"`
+	Vars      map[string]string     `json:"vars"`
+	Steps     map[string]*StepState `json:"steps"`
+}
+
+func (s *RunState) save(dir string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path.Join(dir, "."+s.ID)
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path.Join(dir, s.ID+".json"))
+}
+
+func loadRunState(dir, id string) (*RunState, error) {
+	data, err := os.ReadFile(path.Join(dir, id+".json"))
+	if err != nil {
+		return nil, err
+	}
+	s := &RunState{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// lastFailedRun returns the last run of the workflow not done, which failed
+// or was interrupted
+func lastFailedRun(dir, workflow string) (*RunState, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var runs []*RunState
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, ".json") || strings.HasPrefix(name, ".") {
+			continue
+		}
+		s, err := loadRunState(dir, strings.TrimSuffix(name, ".json"))
+		if err != nil || s.Workflow != workflow || s.Status == StepDone {
+			continue
+		}
+		runs = append(runs, s)
+	}
+	if len(runs) == 0 {
+		return nil, errors.New("no failed run of the workflow to resume")
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].Created.After(runs[j].Created) })
+	return runs[0], nil
+}
+
+type RunCommandOptions struct {
+	ChatGPTOptions `yaml:"chatgpt,omitempty"`
+	ClientOptions  `yaml:",inline"`
+	Prices         map[string]Price `cortana:"-, -" yaml:"prices,omitempty"`
+	Dir            string           `cortana:"--dir,-, ~/.guru, the guru directory" yaml:"dir,omitempty"`
+	Renderer       string           `cortana:"--renderer,, markdown, the render type, can be markdown, text, raw, json, yaml, code or html" yaml:"renderer,omitempty"`
+	Theme          string           `cortana:"--theme, -, auto, the theme of the colors in ~/.guru/themes, auto picks dark or light by the terminal" yaml:"theme,omitempty"`
+	Input          string           `cortana:"--input, -i, , the file referred as {{.input}}, stdin is read if it is not a terminal" yaml:"-"`
+	Resume         string           `cortana:"--resume, -, , resume the run by id from the failed step, or last for the last failed run of the workflow" yaml:"-"`
+	Workflow       string           `cortana:"workflow, -" yaml:"-"`
+	Vars           []string         `cortana:"vars, -" yaml:"-"` // key=value referred as {{.vars.key}}
+}
+
+// RunCommand runs the workflow, and the run is recorded as a session
+func (g *Guru) RunCommand() {
+	opts := &RunCommandOptions{}
+	cortana.Parse(opts)
+	if opts.Workflow == "" {
+		g.Fatalln("the workflow file is required")
+	}
+	if _, err := tui.LookupRenderer(opts.Renderer); err != nil {
+		g.Fatalln(err)
+	}
+
+	filename, err := filepath.Abs(opts.Workflow)
+	if err != nil {
+		g.Fatalln(err)
+	}
+	w, err := LoadWorkflow(filename)
+	if err != nil {
+		g.Fatalln(err)
+	}
+
+	opts.Dir = expandPath(opts.Dir)
+	if err := initGuruDirs(opts.Dir); err != nil {
+		g.Fatalln("initialize guru directories failed", err)
+	}
+	g.useTheme(opts.Dir, opts.Theme)
+	runDir := path.Join(opts.Dir, "run")
+	if err := os.MkdirAll(runDir, 0755); err != nil {
+		g.Fatalln(err)
+	}
+
+	var state *RunState
+	switch opts.Resume {
+	case "":
+		// the id names the files of the state and the session
+		name := strings.Trim(invalidRunIDChars.ReplaceAllString(w.Name, "-"), "-")
+		state = &RunState{ID: fmt.Sprintf("run-%s-%d", name, time.Now().UnixMilli()),
+			Workflow: filename, Created: time.Now(), Vars: make(map[string]string),
+			Steps: make(map[string]*StepState)}
+		state.SessionID = state.ID
+		for k, v := range w.Vars {
+			state.Vars[k] = v
+		}
+		for _, kv := range opts.Vars {
+			k, v, ok := strings.Cut(kv, "=")
+			if !ok {
+				g.Fatalln("invalid var, should be key=value:", kv)
+			}
+			state.Vars[k] = v
+		}
+		if opts.Input != "" {
+			state.Input, err = g.readFile(opts.Input)
+		} else if !readline.IsTerminal(int(os.Stdin.Fd())) {
+			state.Input, err = g.readStdin()
+		}
+		if err != nil {
+			g.Fatalln(err)
+		}
+	case "last":
+		state, err = lastFailedRun(runDir, filename)
+	default:
+		state, err = loadRunState(runDir, opts.Resume)
+	}
+	if err != nil {
+		g.Fatalln(err)
+	}
+
+	sess := NewSession(path.Join(opts.Dir, "session"), WithCommandOutput(g), WithHighlightStyle(g.highlightStyle))
+	if err := sess.Open(state.SessionID); err != nil {
+		g.Fatalln(err)
+	}
+	g.sess = sess
+
+	g.resolveAPIKey(&opts.ClientOptions, opts.Dir)
+	httpCli := g.getHTTPClient(&opts.ClientOptions)
+	cli := g.newChatClient(httpCli, &opts.ClientOptions, opts.Dir)
+	cc := NewChatCommand(sess, nil, cli, &ChatCommandOptions{ChatGPTOptions: opts.ChatGPTOptions,
+		ClientOptions: opts.ClientOptions, Dir: opts.Dir, Prices: opts.Prices})
+
+	base := opts.ChatGPTOptions
+	if !w.ChatGPT.IsZero() {
+		if err := w.ChatGPT.Decode(&base); err != nil {
+			g.Fatalln(err)
+		}
+	}
+
+	err = g.runWorkflow(w, state, runDir, cc, base, opts.Renderer)
+	sess.Close()
+	if err != nil {
+		g.Errorln(err)
+		g.Println(fmt.Sprintf("resume the run by: guru run %s --resume %s", opts.Workflow, state.ID))
+		os.Exit(exitCode(err))
+	}
+	g.Println(fmt.Sprintf("run %s done, recorded in the session %s", state.ID, state.SessionID))
+}
+
+// runWorkflow runs the steps not done, the state is saved as running first,
+// and as done or failed when it returns
+func (g *Guru) runWorkflow(w *Workflow, state *RunState, runDir string, cc *ChatCommand,
+	base ChatGPTOptions, renderer string) (err error) {
+	steps := make(map[string]map[string]string)
+	data := map[string]any{"input": state.Input, "vars": state.Vars, "steps": steps}
+	// the output is kept with the error, like what a command printed
+	fail := func(step *WorkflowStep, output string, err error) error {
+		state.Steps[step.ID] = &StepState{Status: StepFailed, Output: strings.TrimSpace(output), Error: err.Error()}
+		state.Status = StepFailed
+		err = fmt.Errorf("step %s failed: %w", step.ID, err)
+		if serr := state.save(runDir); serr != nil {
+			return fmt.Errorf("%w, and save the state failed: %v", err, serr)
+		}
+		return err
+	}
+	// the state is failed if it is not saved
+	defer func() {
+		if err != nil {
+			state.Status = StepFailed
+		}
+	}()
+
+	state.Status = StepRunning
+	if err := state.save(runDir); err != nil {
+		return err
+	}
+
+	for _, step := range w.Steps {
+		// the steps done are not run again when resuming
+		if s := state.Steps[step.ID]; s != nil && s.Status != StepFailed {
+			steps[step.ID] = map[string]string{"output": s.Output, "status": s.Status}
+			continue
+		}
+
+		cond, err := step.render("if", data)
+		if err != nil {
+			return fail(step, "", err)
+		}
+		if step.If != "" && !truthy(cond) {
+			g.StylePrintln(g.highlightStyle, fmt.Sprintf("== %s skipped", step.ID))
+			state.Steps[step.ID] = &StepState{Status: StepSkipped}
+			steps[step.ID] = map[string]string{"output": "", "status": StepSkipped}
+			if err := state.save(runDir); err != nil {
+				return err
+			}
+			continue
+		}
+
+		g.StylePrintln(g.highlightStyle, fmt.Sprintf("== %s", step.ID))
+		var output string
+		if step.Shell != "" {
+			output, err = g.runShellStep(step, data)
+		} else {
+			output, err = g.runPromptStep(step, data, cc, base, renderer)
+		}
+		if err != nil {
+			return fail(step, output, err)
+		}
+		output = strings.TrimSpace(output)
+		state.Steps[step.ID] = &StepState{Status: StepDone, Output: output}
+		steps[step.ID] = map[string]string{"output": output, "status": StepDone}
+		if err := state.save(runDir); err != nil {
+			return err
+		}
+	}
+	state.Status = StepDone
+	return state.save(runDir)
+}
+
+func (g *Guru) runShellStep(step *WorkflowStep, data any) (string, error) {
+	input, err := step.render("input", data)
+	if err != nil {
+		return "", err
+	}
+	output, err := NewExecutor(step.Shell).Exec(input)
+	g.Println(output)
+	if err != nil {
+		return output, err
+	}
+	// record the command and its output in the session
+	g.sess.Append(&Message{Role: User, Content: fmt.Sprintf("$ %s\n%s", step.Shell, output)})
+	return output, nil
+}
+
+func (g *Guru) runPromptStep(step *WorkflowStep, data any, cc *ChatCommand,
+	base ChatGPTOptions, renderer string) (string, error) {
+	system, err := step.render("system", data)
+	if err != nil {
+		return "", err
+	}
+	prompt, err := step.render("prompt", data)
+	if err != nil {
+		return "", err
+	}
+
+	copts := &ChatOptions{ChatGPTOptions: base, Renderer: renderer, NonInteractive: true}
+	if !step.ChatGPT.IsZero() {
+		if err := step.ChatGPT.Decode(&copts.ChatGPTOptions); err != nil {
+			return "", err
+		}
+	}
+	if !step.KeepContext {
+		g.sess.ClearMessage()
+	}
+	if system != "" {
+		g.sess.Append(&Message{Role: System, Content: system})
+	}
+	copts.Text = prompt
+	reply, err := cc.Talk(copts)
+	// the reply is printed without the newline if not rendered by the tui
+	if !tui.IsRenderable() {
+		g.Println()
+	}
+	return reply, err
+}
+
+// truthy reports if the rendered condition is true
+func truthy(s string) bool {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "false", "0", "no", "<no value>":
+		return false
+	}
+	return true
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/shafreeck/guru/chat"
+	"github.com/shafreeck/guru/mock"
+)
+
+// newTestWorkflow loads the workflow, and the guru talks to the mock server
+// which echoes the prompts
+func newTestWorkflow(t *testing.T, text string) (*Workflow, *Guru, *ChatCommand, string) {
+	t.Helper()
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "flow.yaml")
+	if err := os.WriteFile(filename, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+	w, err := LoadWorkflow(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(mock.New())
+	t.Cleanup(srv.Close)
+	g := New(WithStdout(io.Discard))
+	if err := initGuruDirs(dir); err != nil {
+		t.Fatal(err)
+	}
+	sess := NewSession(filepath.Join(dir, "session"), WithCommandOutput(g))
+	if err := sess.Open("run-test"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sess.Close() })
+	g.sess = sess
+
+	opts := &ChatGPTOptions{Model: "gpt-test"}
+	cc := &ChatCommand{sess: sess, ledger: NewLedger(dir), sink: func(string) {},
+		c: NewChatGPTClient(http.DefaultClient, srv.URL+"/v1", "sk-test-key", opts, chat.WithRetry(0, 0, 0))}
+	runDir := filepath.Join(dir, "run")
+	if err := os.MkdirAll(runDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	return w, g, cc, runDir
+}
+
+func newTestRunState(input string) *RunState {
+	return &RunState{ID: "run-test", Workflow: "flow.yaml", SessionID: "run-test", Input: input,
+		Vars: map[string]string{"project": "guru"}, Steps: make(map[string]*StepState)}
+}
+
+func TestRunWorkflow(t *testing.T) {
+	w, g, cc, runDir := newTestWorkflow(t, `
+steps:
+  - id: summary
+    prompt: "summary of {{.vars.project}}: {{.input}}"
+  - id: skipped
+    if: '{{eq .steps.summary.output "nothing"}}'
+    prompt: "never asked"
+  - id: upper
+    shell: "tr a-z A-Z"
+    input: "{{.steps.summary.output}} {{.steps.skipped.status}}"
+`)
+	state := newTestRunState("a panic")
+	if err := g.runWorkflow(w, state, runDir, cc, ChatGPTOptions{Model: "gpt-test"}, "text"); err != nil {
+		t.Fatal(err)
+	}
+	if state.Status != StepDone {
+		t.Errorf("the run is %s, want done", state.Status)
+	}
+	// the steps run in order, and refer the outputs of the previous ones
+	want := map[string]*StepState{
+		"summary": {Status: StepDone, Output: "summary of guru: a panic"},
+		"skipped": {Status: StepSkipped},
+		"upper":   {Status: StepDone, Output: "SUMMARY OF GURU: A PANIC SKIPPED"},
+	}
+	for id, s := range want {
+		if got := state.Steps[id]; got == nil || *got != *s {
+			t.Errorf("the step %s is %+v, want %+v", id, got, s)
+		}
+	}
+	saved, err := loadRunState(runDir, state.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Status != StepDone || len(saved.Steps) != 3 {
+		t.Errorf("the state saved is %+v", saved)
+	}
+}
+
+func TestRunWorkflowResume(t *testing.T) {
+	w, g, cc, runDir := newTestWorkflow(t, `
+steps:
+  - id: first
+    prompt: "first {{.input}}"
+  - id: check
+    shell: "sh -c 'echo checking; test -f ok'"
+  - id: last
+    prompt: "last after {{.steps.first.output}}"
+`)
+	wd, _ := os.Getwd()
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	state := newTestRunState("input")
+	err := g.runWorkflow(w, state, runDir, cc, ChatGPTOptions{Model: "gpt-test"}, "text")
+	if err == nil || !strings.Contains(err.Error(), "step check failed") {
+		t.Fatalf("the run returns %v, want the check failed", err)
+	}
+	saved, err := loadRunState(runDir, state.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := saved.Steps["check"]
+	if saved.Status != StepFailed || check == nil || check.Status != StepFailed ||
+		check.Output != "checking" || !strings.Contains(check.Error, "exit status 1") {
+		t.Fatalf("the state saved is %+v, the check is %+v", saved, check)
+	}
+	if saved.Steps["last"] != nil {
+		t.Errorf("the step after the failed one is run: %+v", saved.Steps["last"])
+	}
+	if last, err := lastFailedRun(runDir, "flow.yaml"); err != nil || last.ID != state.ID {
+		t.Errorf("the last failed run is %v, %v", last, err)
+	}
+
+	// the first step is not asked again when resumed
+	if err := os.WriteFile("ok", nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	saved.Steps["first"].Output = "first from the state"
+	if err := g.runWorkflow(w, saved, runDir, cc, ChatGPTOptions{Model: "gpt-test"}, "text"); err != nil {
+		t.Fatal(err)
+	}
+	if saved.Status != StepDone || saved.Steps["check"].Status != StepDone ||
+		saved.Steps["last"].Output != "last after first from the state" {
+		t.Errorf("the resumed run is %+v, the last is %+v", saved, saved.Steps["last"])
+	}
+	if _, err := lastFailedRun(runDir, "flow.yaml"); err == nil {
+		t.Error("the run done is resumed")
+	}
+}
+
+func TestLoadWorkflowErrors(t *testing.T) {
+	dir := t.TempDir()
+	for text, want := range map[string]string{
+		"steps: []":                                         "no steps",
+		"steps: [{prompt: hi}]":                             "has no id",
+		"steps: [{id: my-step, prompt: hi}]":                `invalid step id "my-step"`,
+		"steps: [{id: a, prompt: hi}, {id: a, prompt: hi}]": "duplicated step: a",
+		"steps: [{id: a, prompt: hi, shell: ls}]":           "either prompt or shell",
+		"steps: [{id: a, prompt: '{{.input'}]":              "unclosed action",
+	} {
+		filename := filepath.Join(dir, "flow.yaml")
+		if err := os.WriteFile(filename, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadWorkflow(filename); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: %v, want %q", text, err, want)
+		}
+	}
+}
+
+func TestExecutorKeepsOutput(t *testing.T) {
+	out, err := NewExecutor("sh -c 'echo broken; exit 2'").Exec("")
+	if err == nil || !strings.Contains(out, "broken") {
+		t.Errorf("exec returns %q, %v, want the output with the error", out, err)
+	}
+	if _, err := NewExecutor(" ").Exec(""); err == nil {
+		t.Error("the empty command is run")
+	}
+}